@@ -16,6 +16,7 @@ func main() {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 	srv := orchestrator.NewServer()
+	go srv.RunScheduler()
 	grpcServer := grpc.NewServer()
 	orchestrator.RegisterTaskServiceServer(grpcServer, srv)
 	go func() {
@@ -4,9 +4,12 @@ import (
 	"context"
 	"time"
 
+	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
 	"github.com/TimofeySar/ya_go_calculate.go/internal/orchestrator"
+	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -19,8 +22,10 @@ func Run(power int, conn *grpc.ClientConn) {
 
 func worker(conn *grpc.ClientConn) {
 	client := orchestrator.NewTaskServiceClient(conn)
+	// worker-id позволяет оркестратору считать живых агентов.
+	base := metadata.AppendToOutgoingContext(context.Background(), orchestrator.WorkerIDKey, uuid.New().String())
 	for {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(base, time.Second)
 		task, err := client.GetTask(ctx, &orchestrator.Empty{})
 		cancel()
 		if err != nil {
@@ -33,28 +38,41 @@ func worker(conn *grpc.ClientConn) {
 		}
 
 		time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
-		var result float64
-		if task.Arg1 != 0 {
-			switch task.Operation {
-			case "+":
-				result = task.Arg1 + task.Arg2
-			case "-":
-				result = task.Arg1 - task.Arg2
-			case "*":
-				result = task.Arg1 * task.Arg2
-			case "/":
-				if task.Arg2 == 0 {
-					continue
-				}
-				result = task.Arg1 / task.Arg2
+		res := &orchestrator.Result{Id: task.Id}
+		value, err := compute(task)
+		if err != nil {
+			res.Error = err.Error()
+			if e, ok := err.(*calculation.Error); ok {
+				res.ErrorId, res.ErrorArgs = string(e.ID), e.Args
 			}
+		} else {
+			res.Result = value.Approx()
+			res.Value = orchestrator.ValueToProto(value)
 		}
 
-		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
-		_, err = client.SendResult(ctx, &orchestrator.Result{Id: task.Id, Result: result})
+		ctx, cancel = context.WithTimeout(base, time.Second)
+		_, err = client.SendResult(ctx, res)
 		cancel()
 		if err != nil {
 			continue
 		}
 	}
 }
+
+func compute(task *orchestrator.Task) (calculation.Value, error) {
+	if len(task.Args) == 0 {
+		return calculation.Apply(calculation.ModeFloat, task.Operation, calculation.FloatValue(task.Arg1), calculation.FloatValue(task.Arg2))
+	}
+	args := make([]calculation.Value, len(task.Args))
+	for i, arg := range task.Args {
+		value, err := orchestrator.ValueFromProto(arg)
+		if err != nil {
+			return calculation.Value{}, err
+		}
+		args[i] = value
+	}
+	if calculation.IsRangeOp(task.Operation) {
+		return calculation.PartialRange(calculation.Mode(task.Mode), task.Operation, task.Body, args[0], args[1])
+	}
+	return calculation.Apply(calculation.Mode(task.Mode), task.Operation, args...)
+}
@@ -0,0 +1,528 @@
+package calculation
+
+import (
+	"maps"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Агрегатные функции sum, avg, median, stddev и product. Аргументы - список значений
+// sum(1, 2, 3), вектор или матрица sum(v) либо диапазон целых чисел sum(1..n) с
+// выражением от переменной диапазона sum(1..n, k -> k^2).
+//
+// Диапазон вычисляется по частям: каждая часть даёт частичный результат (сумму,
+// произведение, [число, сумма] для avg, [число, среднее, сумма квадратов отклонений
+// от среднего] для stddev, упорядоченные значения для median), частичные результаты
+// попарно объединяются операцией merge:name, а итог получается операцией finish:name.
+// Части stddev объединяются формулами Чана (параллельный алгоритм Уэлфорда), которые
+// не теряют точность на больших смещениях значений; в режиме integer среднее не
+// представимо, и частичный результат stddev - точные [число, сумма, сумма квадратов].
+//
+// Остатки делений внутри агрегатной функции - в выражении от переменной диапазона,
+// в avg, median и stddev - не вернуть вместе с остатками выражения, поэтому в режиме
+// integer такое деление с остатком - ошибка.
+
+var aggregates = map[string]bool{"sum": true, "avg": true, "median": true, "stddev": true, "product": true}
+
+// maxRangeLength - наибольшее число значений в диапазоне.
+const maxRangeLength = 10_000_000
+
+// maxCost - наибольшая оценка Cost выражения, которое можно вычислить: ограничивает
+// вложенные диапазоны, значения которых не делятся между агентами.
+const maxCost = 1_000_000_000
+
+const (
+	mergePrefix  = "merge:"
+	finishPrefix = "finish:"
+)
+
+func IsAggregate(name string) bool {
+	return aggregates[name]
+}
+
+// RangeOp возвращает операцию агрегатной функции name над диапазоном. Если variable
+// не пуста, операндами будут границы и выражение от variable, иначе только границы.
+func RangeOp(name, variable string) string {
+	return name + ".." + variable
+}
+
+// ParseRangeOp разбирает операцию, созданную RangeOp.
+func ParseRangeOp(op string) (name, variable string, ok bool) {
+	name, variable, ok = strings.Cut(op, "..")
+	return name, variable, ok && aggregates[name] && (variable == "" || ValidVariable(variable))
+}
+
+func IsRangeOp(op string) bool {
+	_, _, ok := ParseRangeOp(op)
+	return ok
+}
+
+// MergeOp возвращает операцию, объединяющую два частичных результата функции name.
+func MergeOp(name string) string {
+	return mergePrefix + name
+}
+
+// FinishOp возвращает операцию, получающую значение функции name из частичного результата.
+func FinishOp(name string) string {
+	return finishPrefix + name
+}
+
+// isPartialOp сообщает, что op - операция над частичными результатами.
+func isPartialOp(op string) bool {
+	_, ok := reduceArity(op)
+	return ok && !IsRangeOp(op)
+}
+
+// reduceArity возвращает число операндов операций диапазонов и частичных результатов.
+func reduceArity(op string) (int, bool) {
+	if _, variable, ok := ParseRangeOp(op); ok {
+		if variable == "" {
+			return 2, true
+		}
+		return 3, true
+	}
+	if name, ok := strings.CutPrefix(op, mergePrefix); ok && aggregates[name] {
+		return 2, true
+	}
+	if name, ok := strings.CutPrefix(op, finishPrefix); ok && aggregates[name] {
+		return 1, true
+	}
+	return 0, false
+}
+
+// operands возвращает аргументы узла, вычисляемые задачами: у диапазона выражение
+// от переменной вычисляется вместе с агрегатной функцией.
+func (n *Node) operands() []*Node {
+	if IsRangeOp(n.Op) {
+		return n.Args[:2]
+	}
+	return n.Args
+}
+
+// maxBoundCost - наибольшая оценка операций границы диапазона, которую Cost
+// вычисляет, чтобы оценить длину диапазона.
+const maxBoundCost = 1000
+
+// Cost оценивает сверху число операций при вычислении дерева. В отличие от TaskCount,
+// учитывает каждое значение диапазона и каждое вычисление выражения от его переменной,
+// в том числе во вложенных диапазонах. Границы, зависящие от переменных внешних
+// диапазонов, оцениваются интервальной арифметикой; диапазон, границы которого
+// оценить не удалось, считается длиной maxRangeLength.
+func (n *Node) Cost() int64 {
+	return n.cost(nil)
+}
+
+// cost - Cost при значениях переменных внешних диапазонов из ranges: каждая
+// переменная заменена отрезком своих значений.
+func (n *Node) cost(ranges map[string]*Node) int64 {
+	if n.IsLiteral() {
+		return 0
+	}
+	total := int64(1)
+	for _, arg := range n.operands() {
+		total = addCost(total, arg.cost(ranges))
+	}
+	_, variable, ok := ParseRangeOp(n.Op)
+	if !ok {
+		return total
+	}
+	lo, _, okLo := boundEstimate(n.Args[0], ranges)
+	_, hi, okHi := boundEstimate(n.Args[1], ranges)
+	length := float64(maxRangeLength)
+	if okLo && okHi {
+		length = min(max(math.Floor(hi)-math.Ceil(lo)+1, 0), maxRangeLength)
+	}
+	body := int64(0)
+	if variable != "" {
+		inner := maps.Clone(ranges)
+		if inner == nil {
+			inner = make(map[string]*Node, 1)
+		}
+		delete(inner, variable)
+		if okLo && okHi && length > 0 {
+			center, radius := (math.Ceil(lo)+math.Floor(hi))/2, (math.Floor(hi)-math.Ceil(lo))/2
+			inner[variable] = Literal(strconv.FormatFloat(center, 'g', -1, 64) + PlusMinus + strconv.FormatFloat(radius, 'g', -1, 64))
+		}
+		body = n.Args[2].cost(inner)
+	}
+	return addCost(total, mulCost(int64(length), addCost(body, 1)))
+}
+
+// boundEstimate возвращает отрезок, содержащий все значения границы диапазона при
+// значениях переменных внешних диапазонов из ranges.
+func boundEstimate(bound *Node, ranges map[string]*Node) (float64, float64, bool) {
+	if bound.cost(ranges) > maxBoundCost {
+		return 0, 0, false
+	}
+	v, err := Evaluate(bound.Bind(ranges), ModeInterval)
+	if err != nil || v.Interval == nil || math.IsInf(v.Interval.Lo, 0) || math.IsInf(v.Interval.Hi, 0) {
+		return 0, 0, false
+	}
+	return v.Interval.Lo, v.Interval.Hi, true
+}
+
+// addCost и mulCost складывают и умножают оценки операций без переполнения.
+func addCost(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+func mulCost(a, b int64) int64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
+
+// RangeBounds возвращает границы диапазона; они должны быть целыми числами, а
+// диапазон - не длиннее maxRangeLength.
+func RangeBounds(lo, hi Value) (int64, int64, error) {
+	bound := func(v Value) (int64, bool) {
+		switch {
+		case v.IsArray() || v.IsComplex():
+			return 0, false
+		case v.Int != nil:
+			return v.Int.Int64(), v.Int.IsInt64()
+		case v.Rat != nil:
+			return v.Rat.Num().Int64(), v.Rat.IsInt() && v.Rat.Num().IsInt64()
+		case v.Interval != nil:
+			x := v.Interval.Lo
+			return int64(x), v.Interval.Hi == x && x == float64(int64(x)) && x > -(1<<53) && x < 1<<53
+		}
+		return int64(v.Float), v.Float == float64(int64(v.Float)) && v.Float > -(1<<53) && v.Float < 1<<53
+	}
+	a, okA := bound(lo)
+	b, okB := bound(hi)
+	if !okA || !okB {
+		return 0, 0, errorf(MsgRangeBounds, lo, hi)
+	}
+	if float64(b)-float64(a)+1 > maxRangeLength {
+		return 0, 0, errorf(MsgRangeTooLarge, a, b, maxRangeLength)
+	}
+	return a, b, nil
+}
+
+func intValue(mode Mode, k int64) Value {
+	v, _ := ParseValue(mode, strconv.FormatInt(k, 10))
+	return v
+}
+
+// emptyPartial возвращает частичный результат функции name без значений.
+func emptyPartial(mode Mode, name string) Value {
+	zero := intValue(mode, 0)
+	switch name {
+	case "product":
+		return intValue(mode, 1)
+	case "avg":
+		return VectorValue([]Value{zero, zero})
+	case "stddev":
+		return VectorValue([]Value{zero, zero, zero})
+	case "median":
+		return VectorValue([]Value{})
+	}
+	return zero
+}
+
+// accumulate добавляет значение v к частичному результату функции name. Значения
+// median добавляются без упорядочивания, см. sortPartial.
+func accumulate(mode Mode, name string, partial, v Value) (Value, error) {
+	if v.IsArray() {
+		return Value{}, errorf(MsgAggregateValue, name)
+	}
+	switch name {
+	case "sum":
+		return Apply(mode, "+", partial, v)
+	case "product":
+		return Apply(mode, "*", partial, v)
+	case "median":
+		return VectorValue(append(partial.Elems, v)), nil
+	}
+	next := []Value{intValue(mode, 1), v}
+	if name == "stddev" {
+		if mode != ModeInteger {
+			return mergeMoments(mode, partial, VectorValue(append(next, intValue(mode, 0))))
+		}
+		square, err := Apply(mode, "*", v, v)
+		if err != nil {
+			return Value{}, err
+		}
+		next = append(next, square)
+	}
+	return Apply(mode, "+", partial, VectorValue(next))
+}
+
+// mergeMoments объединяет частичные результаты stddev [n, среднее, M2], где M2 -
+// сумма квадратов отклонений от среднего:
+//
+//	δ = mean_b - mean_a, n = n_a + n_b,
+//	mean = mean_a + δ*n_b/n, M2 = M2_a + M2_b + δ²*n_a*n_b/n.
+func mergeMoments(mode Mode, a, b Value) (Value, error) {
+	na, nb := a.Elems[0], b.Elems[0]
+	switch {
+	case !Truthy(nb):
+		return a, nil
+	case !Truthy(na):
+		return b, nil
+	}
+	n, err := Apply(mode, "+", na, nb)
+	if err != nil {
+		return Value{}, err
+	}
+	delta, err := Apply(mode, "-", b.Elems[1], a.Elems[1])
+	if err != nil {
+		return Value{}, err
+	}
+	// mean_a + δ*n_b/n
+	mean := delta
+	for _, step := range []struct {
+		op string
+		v  Value
+	}{{"*", nb}, {"/", n}, {"+", a.Elems[1]}} {
+		if mean, err = Apply(mode, step.op, mean, step.v); err != nil {
+			return Value{}, err
+		}
+	}
+	// M2_a + M2_b + δ²*n_a*n_b/n
+	m2 := delta
+	for _, step := range []struct {
+		op string
+		v  Value
+	}{{"*", delta}, {"*", na}, {"*", nb}, {"/", n}, {"+", a.Elems[2]}, {"+", b.Elems[2]}} {
+		if m2, err = Apply(mode, step.op, m2, step.v); err != nil {
+			return Value{}, err
+		}
+	}
+	return VectorValue([]Value{n, mean, m2}), nil
+}
+
+// sortPartial упорядочивает значения частичного результата median.
+func sortPartial(name string, partial Value) (Value, error) {
+	if name != "median" {
+		return partial, nil
+	}
+	var err error
+	less := func(a, b Value) bool {
+		result, cmpErr := compare("<", a, b)
+		if cmpErr != nil {
+			err = cmpErr
+		}
+		return result
+	}
+	slices.SortFunc(partial.Elems, func(a, b Value) int {
+		switch {
+		case less(a, b):
+			return -1
+		case less(b, a):
+			return 1
+		}
+		return 0
+	})
+	return partial, err
+}
+
+// Partial вычисляет частичный результат функции name над значениями values.
+func Partial(mode Mode, name string, values []Value) (Value, error) {
+	partial := emptyPartial(mode, name)
+	for _, v := range values {
+		var err error
+		if partial, err = accumulate(mode, name, partial, v); err != nil {
+			return Value{}, err
+		}
+	}
+	return sortPartial(name, partial)
+}
+
+// PartialRange вычисляет частичный результат операции диапазона op над его частью
+// от lo до hi; body - запись выражения от переменной диапазона.
+func PartialRange(mode Mode, op, body string, lo, hi Value) (Value, error) {
+	name, variable, ok := ParseRangeOp(op)
+	if !ok {
+		return Value{}, errorf(MsgUnknownOperation, op)
+	}
+	a, b, err := RangeBounds(lo, hi)
+	if err != nil {
+		return Value{}, err
+	}
+	var tree *Node
+	if variable != "" {
+		if tree, err = ParseWith(body, variable); err != nil {
+			return Value{}, err
+		}
+	}
+	return partialRange(mode, name, variable, tree, a, b, nil)
+}
+
+// partialRange вычисляет частичный результат над значениями body при variable от a до b;
+// env - значения внешних переменных диапазонов.
+func partialRange(mode Mode, name, variable string, body *Node, a, b int64, env map[string]Value) (Value, error) {
+	partial := emptyPartial(mode, name)
+	scope := maps.Clone(env)
+	if scope == nil {
+		scope = make(map[string]Value, 1)
+	}
+	var remainders []Remainder
+	for k := a; k <= b; k++ {
+		v := intValue(mode, k)
+		if body != nil {
+			scope[variable] = v
+			var err error
+			if v, err = evaluate(body, mode, scope, &remainders); err != nil {
+				return Value{}, err
+			}
+			if len(remainders) > 0 {
+				r := remainders[0]
+				return Value{}, errorf(MsgAggregateRemainder, name, r.Dividend, r.Divisor, r.Remainder)
+			}
+		}
+		var err error
+		if partial, err = accumulate(mode, name, partial, v); err != nil {
+			return Value{}, err
+		}
+	}
+	return sortPartial(name, partial)
+}
+
+// mergePartial объединяет частичные результаты функции name.
+func mergePartial(mode Mode, name string, a, b Value) (Value, error) {
+	switch name {
+	case "product":
+		return Apply(mode, "*", a, b)
+	case "stddev":
+		if mode != ModeInteger {
+			return mergeMoments(mode, a, b)
+		}
+	case "median":
+		merged := make([]Value, 0, len(a.Elems)+len(b.Elems))
+		i, j := 0, 0
+		for i < len(a.Elems) && j < len(b.Elems) {
+			less, err := compare("<", b.Elems[j], a.Elems[i])
+			if err != nil {
+				return Value{}, err
+			}
+			if less {
+				merged = append(merged, b.Elems[j])
+				j++
+			} else {
+				merged = append(merged, a.Elems[i])
+				i++
+			}
+		}
+		merged = append(merged, a.Elems[i:]...)
+		return VectorValue(append(merged, b.Elems[j:]...)), nil
+	}
+	return Apply(mode, "+", a, b)
+}
+
+// finishPartial возвращает значение функции name по частичному результату.
+func finishPartial(mode Mode, name string, partial Value) (Value, error) {
+	switch name {
+	case "sum", "product":
+		return partial, nil
+	case "median":
+		n := len(partial.Elems)
+		if n == 0 {
+			return Value{}, errorf(MsgEmptyAggregate, name)
+		}
+		if n%2 == 1 {
+			return partial.Elems[n/2], nil
+		}
+		sum, err := Apply(mode, "+", partial.Elems[n/2-1], partial.Elems[n/2])
+		if err != nil {
+			return Value{}, err
+		}
+		return exactQuo(mode, name, sum, intValue(mode, 2))
+	}
+	count, sum := partial.Elems[0], partial.Elems[1]
+	if !Truthy(count) {
+		return Value{}, errorf(MsgEmptyAggregate, name)
+	}
+	if name == "avg" {
+		return exactQuo(mode, name, sum, count)
+	}
+	var variance Value
+	var err error
+	if mode == ModeInteger {
+		variance, err = integerVariance(count, sum, partial.Elems[2])
+	} else {
+		// дисперсия M2 / n
+		variance, err = Apply(mode, "/", partial.Elems[2], count)
+	}
+	if err != nil {
+		return Value{}, err
+	}
+	// отрезок квадрата отклонения может захватить отрицательные числа
+	if variance.Interval != nil && variance.Interval.Lo < 0 {
+		variance = IntervalValue(0, variance.Interval.Hi)
+	}
+	if variance.Rat != nil && variance.Rat.Sign() >= 0 && variance.Dim == nil {
+		return ratSqrt(variance)
+	}
+	return Apply(mode, "sqrt", variance)
+}
+
+// exactQuo делит a на b внутри агрегатной функции name; остаток - ошибка.
+func exactQuo(mode Mode, name string, a, b Value) (Value, error) {
+	v, err := Apply(mode, "/", a, b)
+	if err == nil && v.Rem != nil {
+		return Value{}, errorf(MsgAggregateRemainder, name, a, b, v.Rem)
+	}
+	return v, err
+}
+
+// integerVariance возвращает точную дисперсию (n*Σx² - (Σx)²) / n² в режиме integer.
+func integerVariance(count, sum, squares Value) (Value, error) {
+	squares, err := Apply(ModeInteger, "*", count, squares)
+	if err != nil {
+		return Value{}, err
+	}
+	square, err := Apply(ModeInteger, "*", sum, sum)
+	if err != nil {
+		return Value{}, err
+	}
+	if squares, err = Apply(ModeInteger, "-", squares, square); err != nil {
+		return Value{}, err
+	}
+	n2, err := Apply(ModeInteger, "*", count, count)
+	if err != nil {
+		return Value{}, err
+	}
+	return exactQuo(ModeInteger, "stddev", squares, n2)
+}
+
+// ratSqrt извлекает точный корень из рационального числа.
+func ratSqrt(v Value) (Value, error) {
+	num, den := new(big.Int).Sqrt(v.Rat.Num()), new(big.Int).Sqrt(v.Rat.Denom())
+	if new(big.Int).Mul(num, num).Cmp(v.Rat.Num()) != 0 || new(big.Int).Mul(den, den).Cmp(v.Rat.Denom()) != 0 {
+		return Value{}, errorf(MsgFunctionUnavailable, "sqrt", ModeRational)
+	}
+	return RatValue(new(big.Rat).SetFrac(num, den)), nil
+}
+
+// applyAggregate выполняет агрегатную функцию над элементами вектора или матрицы
+// (или над одним числом), а также операции merge: и finish:.
+func applyAggregate(mode Mode, op string, args []Value) (Value, error) {
+	if IsRangeOp(op) {
+		// значения диапазона вычисляет Evaluate или PartialRange
+		return Value{}, errorf(MsgUnknownOperation, op)
+	}
+	if name, ok := strings.CutPrefix(op, mergePrefix); ok {
+		return mergePartial(mode, name, args[0], args[1])
+	}
+	if name, ok := strings.CutPrefix(op, finishPrefix); ok {
+		return finishPartial(mode, name, args[0])
+	}
+	values := []Value{args[0]}
+	if args[0].IsArray() {
+		values = args[0].Elems
+	}
+	partial, err := Partial(mode, op, values)
+	if err != nil {
+		return Value{}, err
+	}
+	return finishPartial(mode, op, partial)
+}
@@ -0,0 +1,416 @@
+package calculation
+
+import (
+	"math/big"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// Векторы и матрицы. Вектор - значение с Elems и Rows = 0, матрица - с Rows > 0,
+// элементы хранятся по строкам. Арифметические операции и функции от одного аргумента
+// выполняются поэлементно, число с вектором или матрицей - с каждым элементом.
+
+// arrayFunctions - функции, определённые для векторов и матриц.
+var arrayFunctions = map[string]bool{"dot": true, "matmul": true, "transpose": true, "det": true, "inv": true}
+
+func (v Value) IsArray() bool {
+	return v.Elems != nil
+}
+
+func (v Value) IsMatrix() bool {
+	return v.Rows > 0
+}
+
+func VectorValue(elems []Value) Value {
+	return Value{Elems: elems, Cols: len(elems)}
+}
+
+func MatrixValue(rows, cols int, elems []Value) Value {
+	return Value{Elems: elems, Rows: rows, Cols: cols}
+}
+
+func (v Value) at(i, j int) Value {
+	return v.Elems[i*v.Cols+j]
+}
+
+// row возвращает строку i матрицы как вектор.
+func (v Value) row(i int) Value {
+	return VectorValue(v.Elems[i*v.Cols : (i+1)*v.Cols])
+}
+
+// shape возвращает размер значения для сообщений: "3" у вектора, "2x3" у матрицы.
+func (v Value) shape() string {
+	switch {
+	case v.IsMatrix():
+		return strconv.Itoa(v.Rows) + "x" + strconv.Itoa(v.Cols)
+	case v.IsArray():
+		return strconv.Itoa(v.Cols)
+	}
+	return "1"
+}
+
+func (v Value) arrayString() string {
+	var b strings.Builder
+	b.WriteString("[")
+	if v.IsMatrix() {
+		for i := 0; i < v.Rows; i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(v.row(i).arrayString())
+		}
+	} else {
+		for i, elem := range v.Elems {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(elem.String())
+		}
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// SplitRows делит матрицу на блоки не более чем по size строк.
+func SplitRows(m Value, size int) []Value {
+	var blocks []Value
+	for start := 0; start < m.Rows; start += size {
+		end := min(start+size, m.Rows)
+		blocks = append(blocks, MatrixValue(end-start, m.Cols, m.Elems[start*m.Cols:end*m.Cols]))
+	}
+	return blocks
+}
+
+// buildArray собирает вектор из чисел, матрицу из векторов одной длины или матрицу
+// из матриц с одинаковым числом столбцов (строки складываются друг под другом).
+func buildArray(args []Value) (Value, error) {
+	first := args[0]
+	var elems []Value
+	rows := 0
+	for _, arg := range args {
+		if arg.IsArray() != first.IsArray() || arg.IsMatrix() != first.IsMatrix() || arg.Cols != first.Cols {
+			return Value{}, errorf(MsgRaggedArray)
+		}
+		if arg.IsArray() {
+			elems = append(elems, arg.Elems...)
+			rows += max(arg.Rows, 1)
+		} else {
+			elems = append(elems, arg)
+		}
+	}
+	if !first.IsArray() {
+		return VectorValue(elems), nil
+	}
+	return MatrixValue(rows, first.Cols, elems), nil
+}
+
+// applyArray выполняет операцию, в которой участвует вектор или матрица, а также
+// функции векторов и матриц от чисел.
+func applyArray(mode Mode, op string, args []Value) (Value, error) {
+	if IsArrayOp(op) {
+		return buildArray(args)
+	}
+	switch op {
+	case "dot":
+		return dot(mode, args[0], args[1])
+	case "matmul":
+		return matmul(mode, args[0], args[1])
+	case "transpose":
+		return transpose(args[0]), nil
+	case "det":
+		return determinant(mode, args[0])
+	case "inv":
+		return inverse(mode, args[0])
+	}
+	if len(args) == 1 {
+		return elementwise(args[0], func(a Value) (Value, error) { return Apply(mode, op, a) })
+	}
+	a, b := args[0], args[1]
+	switch {
+	case !a.IsArray():
+		return elementwise(b, func(e Value) (Value, error) { return Apply(mode, op, a, e) })
+	case !b.IsArray():
+		return elementwise(a, func(e Value) (Value, error) { return Apply(mode, op, e, b) })
+	case a.Rows != b.Rows || a.Cols != b.Cols:
+		return Value{}, errorf(MsgArrayShape, op, a.shape(), b.shape())
+	}
+	i := -1
+	return elementwise(a, func(e Value) (Value, error) {
+		i++
+		return Apply(mode, op, e, b.Elems[i])
+	})
+}
+
+// elementwise применяет f к каждому элементу. Остатки целочисленного деления
+// у элементов не сохраняются.
+func elementwise(v Value, f func(Value) (Value, error)) (Value, error) {
+	elems := make([]Value, len(v.Elems))
+	for i, elem := range v.Elems {
+		result, err := f(elem)
+		if err != nil {
+			return Value{}, err
+		}
+		if result.IsArray() {
+			return Value{}, errorf(MsgRaggedArray)
+		}
+		result.Rem = nil
+		elems[i] = result
+	}
+	return Value{Elems: elems, Rows: v.Rows, Cols: v.Cols}, nil
+}
+
+// sumProducts возвращает сумму попарных произведений a[i] * b[i].
+func sumProducts(mode Mode, a, b []Value) (Value, error) {
+	sum, _ := ParseValue(mode, "0")
+	for i := range a {
+		product, err := Apply(mode, "*", a[i], b[i])
+		if err != nil {
+			return Value{}, err
+		}
+		if sum, err = Apply(mode, "+", sum, product); err != nil {
+			return Value{}, err
+		}
+	}
+	return sum, nil
+}
+
+func dot(mode Mode, a, b Value) (Value, error) {
+	switch {
+	case !a.IsArray() && !b.IsArray():
+		return Apply(mode, "*", a, b)
+	case a.IsMatrix() || b.IsMatrix() || !a.IsArray() || !b.IsArray() || a.Cols != b.Cols:
+		return Value{}, errorf(MsgArrayShape, "dot", a.shape(), b.shape())
+	}
+	return sumProducts(mode, a.Elems, b.Elems)
+}
+
+// matmul умножает матрицы; вектор слева считается строкой, справа - столбцом.
+func matmul(mode Mode, a, b Value) (Value, error) {
+	if !a.IsArray() || !b.IsArray() {
+		return Apply(mode, "*", a, b)
+	}
+	left, right := a, b
+	if !a.IsMatrix() {
+		left = MatrixValue(1, a.Cols, a.Elems)
+	}
+	if !b.IsMatrix() {
+		right = MatrixValue(b.Cols, 1, b.Elems)
+	}
+	if left.Cols != right.Rows || !a.IsMatrix() && !b.IsMatrix() {
+		return Value{}, errorf(MsgArrayShape, "matmul", a.shape(), b.shape())
+	}
+	columns := transpose(right)
+	elems := make([]Value, 0, left.Rows*right.Cols)
+	for i := 0; i < left.Rows; i++ {
+		for j := 0; j < right.Cols; j++ {
+			cell, err := sumProducts(mode, left.row(i).Elems, columns.row(j).Elems)
+			if err != nil {
+				return Value{}, err
+			}
+			elems = append(elems, cell)
+		}
+	}
+	if !a.IsMatrix() || !b.IsMatrix() {
+		return VectorValue(elems), nil
+	}
+	return MatrixValue(left.Rows, right.Cols, elems), nil
+}
+
+// transpose транспонирует матрицу; вектор становится столбцом.
+func transpose(v Value) Value {
+	switch {
+	case !v.IsArray():
+		return v
+	case !v.IsMatrix():
+		return MatrixValue(v.Cols, 1, v.Elems)
+	}
+	elems := make([]Value, 0, len(v.Elems))
+	for j := 0; j < v.Cols; j++ {
+		for i := 0; i < v.Rows; i++ {
+			elems = append(elems, v.at(i, j))
+		}
+	}
+	return MatrixValue(v.Cols, v.Rows, elems)
+}
+
+// squareRows возвращает строки квадратной матрицы; число считается матрицей 1x1.
+func squareRows(op string, v Value) ([][]Value, error) {
+	if !v.IsArray() {
+		return [][]Value{{v}}, nil
+	}
+	if !v.IsMatrix() || v.Rows != v.Cols {
+		return nil, errorf(MsgNotSquare, op, v.shape())
+	}
+	rows := make([][]Value, v.Rows)
+	for i := range rows {
+		rows[i] = append([]Value(nil), v.row(i).Elems...)
+	}
+	return rows, nil
+}
+
+func magnitude(v Value) float64 {
+	if v.Rat != nil || v.Int != nil || v.Interval != nil {
+		f := v.Approx()
+		if f < 0 {
+			return -f
+		}
+		return f
+	}
+	return cmplx.Abs(v.Complex())
+}
+
+// pivot переставляет в строку k строку с наибольшим по модулю элементом столбца k.
+// Возвращает false, если весь столбец ниже k нулевой, и признак перестановки.
+func pivot(rows [][]Value, k int) (found, swapped bool) {
+	best := k
+	for i := k + 1; i < len(rows); i++ {
+		if magnitude(rows[i][k]) > magnitude(rows[best][k]) {
+			best = i
+		}
+	}
+	if !Truthy(rows[best][k]) {
+		return false, false
+	}
+	rows[k], rows[best] = rows[best], rows[k]
+	return true, best != k
+}
+
+// determinant вычисляет определитель методом Барейсса: все деления точные, поэтому
+// результат верен и в режиме integer.
+func determinant(mode Mode, v Value) (Value, error) {
+	rows, err := squareRows("det", v)
+	if err != nil {
+		return Value{}, err
+	}
+	zero, _ := ParseValue(mode, "0")
+	prev, _ := ParseValue(mode, "1")
+	negate := false
+	n := len(rows)
+	for k := 0; k < n-1; k++ {
+		found, swapped := pivot(rows, k)
+		if !found {
+			return zero, nil
+		}
+		negate = negate != swapped
+		for i := k + 1; i < n; i++ {
+			for j := k + 1; j < n; j++ {
+				a, err := Apply(mode, "*", rows[i][j], rows[k][k])
+				if err != nil {
+					return Value{}, err
+				}
+				b, err := Apply(mode, "*", rows[i][k], rows[k][j])
+				if err != nil {
+					return Value{}, err
+				}
+				if a, err = Apply(mode, "-", a, b); err != nil {
+					return Value{}, err
+				}
+				if rows[i][j], err = Apply(mode, "/", a, prev); err != nil {
+					return Value{}, err
+				}
+			}
+		}
+		prev = rows[k][k]
+	}
+	det := rows[n-1][n-1]
+	if negate {
+		return Apply(mode, "-", zero, det)
+	}
+	return det, nil
+}
+
+// inverse обращает матрицу методом Гаусса-Жордана. В режиме integer промежуточные
+// значения дробные, поэтому матрица обращается в режиме rational, а ошибкой считается
+// только нецелый результат.
+func inverse(mode Mode, v Value) (Value, error) {
+	if mode == ModeInteger {
+		rational, err := elementwise(v, func(e Value) (Value, error) {
+			return RatValue(new(big.Rat).SetInt(e.Int)), nil
+		})
+		if !v.IsArray() {
+			rational, err = RatValue(new(big.Rat).SetInt(v.Int)), nil
+		}
+		if err != nil {
+			return Value{}, err
+		}
+		inv, err := inverse(ModeRational, rational)
+		if err != nil {
+			return Value{}, err
+		}
+		integer := func(e Value) (Value, error) {
+			if !e.Rat.IsInt() {
+				return Value{}, errorf(MsgInexactInverse)
+			}
+			return IntValue(new(big.Int).Set(e.Rat.Num())), nil
+		}
+		if !inv.IsArray() {
+			return integer(inv)
+		}
+		return elementwise(inv, integer)
+	}
+	rows, err := squareRows("inv", v)
+	if err != nil {
+		return Value{}, err
+	}
+	n := len(rows)
+	zero, _ := ParseValue(mode, "0")
+	one, _ := ParseValue(mode, "1")
+	for i := range rows {
+		for j := 0; j < n; j++ {
+			if i == j {
+				rows[i] = append(rows[i], one)
+			} else {
+				rows[i] = append(rows[i], zero)
+			}
+		}
+	}
+	for k := 0; k < n; k++ {
+		if found, _ := pivot(rows, k); !found {
+			return Value{}, errorf(MsgSingularMatrix)
+		}
+		p := rows[k][k]
+		for j := range rows[k] {
+			if rows[k][j], err = Apply(mode, "/", rows[k][j], p); err != nil {
+				return Value{}, err
+			}
+		}
+		for i := range rows {
+			if i == k || !Truthy(rows[i][k]) {
+				continue
+			}
+			factor := rows[i][k]
+			for j := range rows[i] {
+				product, err := Apply(mode, "*", factor, rows[k][j])
+				if err != nil {
+					return Value{}, err
+				}
+				if rows[i][j], err = Apply(mode, "-", rows[i][j], product); err != nil {
+					return Value{}, err
+				}
+			}
+		}
+	}
+	if !v.IsArray() {
+		return rows[0][1], nil
+	}
+	elems := make([]Value, 0, n*n)
+	for _, row := range rows {
+		elems = append(elems, row[n:]...)
+	}
+	return MatrixValue(n, n, elems), nil
+}
+
+// equalArrays сравнивает размеры и элементы.
+func equalArrays(a, b Value) (bool, error) {
+	if a.IsArray() != b.IsArray() || a.Rows != b.Rows || a.Cols != b.Cols {
+		return false, nil
+	}
+	for i := range a.Elems {
+		equal, err := compare("==", a.Elems[i], b.Elems[i])
+		if err != nil || !equal {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,190 @@
+package calculation
+
+import "strings"
+
+// Node - узел дерева разбора. У числа заполнено только поле Token, у операции или
+// функции - Op и Args.
+type Node struct {
+	Op    string  `json:"op,omitempty"`
+	Token string  `json:"token,omitempty"`
+	Args  []*Node `json:"args,omitempty"`
+}
+
+func Literal(token string) *Node {
+	return &Node{Token: token}
+}
+
+func (n *Node) IsLiteral() bool {
+	return n.Op == ""
+}
+
+// Value возвращает значение числового узла в заданном режиме.
+func (n *Node) Value(mode Mode) (Value, error) {
+	return ParseValue(mode, n.Token)
+}
+
+// Parse разбирает выражение в дерево.
+func Parse(expression string) (*Node, error) {
+	postfix, err := InfixToPostfix(expression)
+	if err != nil {
+		return nil, err
+	}
+	return BuildTree(postfix)
+}
+
+// BuildTree строит дерево по постфиксной записи.
+func BuildTree(postfix []string) (*Node, error) {
+	var stack []*Node
+	for _, token := range postfix {
+		n := Arity(token)
+		if n == 0 {
+			stack = append(stack, Literal(token))
+			continue
+		}
+		if len(stack) < n {
+			return nil, errorf(MsgMissingOperand)
+		}
+		node := &Node{Op: token, Args: append([]*Node(nil), stack[len(stack)-n:]...)}
+		stack = append(stack[:len(stack)-n], node)
+	}
+	if len(stack) != 1 {
+		return nil, errorf(MsgExtraOperand)
+	}
+	return stack[0], nil
+}
+
+// Postfix возвращает постфиксную запись дерева.
+func (n *Node) Postfix() []string {
+	if n.IsLiteral() {
+		return []string{n.Token}
+	}
+	var postfix []string
+	for _, arg := range n.Args {
+		postfix = append(postfix, arg.Postfix()...)
+	}
+	return append(postfix, n.Op)
+}
+
+// TaskCount возвращает число задач, которые будут созданы для дерева.
+func (n *Node) TaskCount() int {
+	if n.IsLiteral() {
+		return 0
+	}
+	count := 1
+	for _, arg := range n.operands() {
+		count += arg.TaskCount()
+	}
+	return count
+}
+
+// atomic - приоритет узлов, которые никогда не нужно заключать в скобки.
+const atomic = 100
+
+func (n *Node) precedence() int {
+	switch {
+	case n.IsLiteral():
+		switch {
+		case IsQuantity(n.Token):
+			// 5 km - число, умноженное на единицу
+			number, _, _ := strings.Cut(n.Token, " ")
+			if signed(number) || IsUncertain(number) {
+				return precedence["+"]
+			}
+			return precedence["*"]
+		case signed(n.Token) || IsUncertain(n.Token):
+			return precedence["+"]
+		case strings.Contains(n.Token, "/"):
+			return precedence["/"]
+		}
+		return atomic
+	case n.Op == "fact":
+		return precedence["^"] + 1
+	case IsFunction(n.Op) || IsArrayOp(n.Op) || IsRangeOp(n.Op) || isPartialOp(n.Op):
+		return atomic
+	}
+	return precedence[n.Op]
+}
+
+// signed сообщает, что запись числа содержит знак: так выглядят отрицательные и
+// комплексные значения, подставленные в дерево вместо вычисленных подвыражений.
+// Записи векторов и матриц и ссылки на результаты в скобках не нуждаются.
+func signed(token string) bool {
+	if strings.HasPrefix(token, "[") || IsReference(token) {
+		return false
+	}
+	for i, ch := range token {
+		if (ch == '+' || ch == '-') && (i == 0 || token[i-1] != 'e' && token[i-1] != 'E') {
+			return true
+		}
+	}
+	return false
+}
+
+// String возвращает запись выражения с минимально необходимыми скобками.
+func (n *Node) String() string {
+	var b strings.Builder
+	n.write(&b)
+	return b.String()
+}
+
+func (n *Node) write(b *strings.Builder) {
+	switch {
+	case n.IsLiteral():
+		b.WriteString(n.Token)
+	case n.Op == "fact":
+		n.Args[0].writeWrapped(b, n.Args[0].precedence() < atomic)
+		b.WriteString("!")
+	case n.Op == "not":
+		b.WriteString("not ")
+		n.Args[0].writeWrapped(b, n.Args[0].precedence() < precedence["not"])
+	case IsRangeOp(n.Op):
+		name, variable, _ := ParseRangeOp(n.Op)
+		b.WriteString(name + "(")
+		n.Args[0].write(b)
+		b.WriteString("..")
+		n.Args[1].write(b)
+		if variable != "" {
+			b.WriteString(", " + variable + " -> ")
+			n.Args[2].write(b)
+		}
+		b.WriteString(")")
+	case IsFunction(n.Op), IsArrayOp(n.Op), isPartialOp(n.Op):
+		open, close := n.Op+"(", ")"
+		if IsArrayOp(n.Op) {
+			open, close = "[", "]"
+		}
+		b.WriteString(open)
+		for i, arg := range n.callArgs() {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			arg.write(b)
+		}
+		b.WriteString(close)
+	default:
+		p := precedence[n.Op]
+		left, right := n.Args[0], n.Args[1]
+		left.writeWrapped(b, left.precedence() < p || left.precedence() == p && rightAssoc[n.Op])
+		b.WriteString(" " + n.Op + " ")
+		right.writeWrapped(b, right.precedence() < p || right.precedence() == p && !rightAssoc[n.Op])
+	}
+}
+
+// callArgs возвращает аргументы вызова функции: у агрегатной функции от списка
+// значений sum(1, 2, 3) - элементы списка.
+func (n *Node) callArgs() []*Node {
+	if aggregates[n.Op] && IsArrayOp(n.Args[0].Op) {
+		return n.Args[0].Args
+	}
+	return n.Args
+}
+
+func (n *Node) writeWrapped(b *strings.Builder, parens bool) {
+	if parens {
+		b.WriteString("(")
+	}
+	n.write(b)
+	if parens {
+		b.WriteString(")")
+	}
+}
@@ -1,29 +1,130 @@
 package calculation
 
 import (
-	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"strconv"
 	"strings"
 )
 
 type Task struct {
-	ID            string  `json:"id"`
-	Arg1          float64 `json:"arg1,omitempty"`
-	Arg2          float64 `json:"arg2,omitempty"`
-	Operation     string  `json:"operation"`
-	OperationTime int     `json:"operation_time"`
+	ID            string   `json:"id"`
+	Arg1          float64  `json:"arg1,omitempty"`
+	Arg2          float64  `json:"arg2,omitempty"`
+	Operation     string   `json:"operation"`
+	OperationTime int      `json:"operation_time"`
+	Mode          Mode     `json:"mode,omitempty"`
+	Args          []Value  `json:"args,omitempty"`
+	DependsOn     []string `json:"depends_on,omitempty"`
+	// Body - выражение от переменной диапазона у агрегатной функции над диапазоном.
+	Body string `json:"body,omitempty"`
+	// Local - задача выполняется самим оркестратором (выбор ветви условия).
+	Local bool `json:"local,omitempty"`
+	// Guards - условия, от выбора ветви которых зависит, понадобится ли задача.
+	Guards []Guard `json:"guards,omitempty"`
+}
+
+// Guard связывает задачу с ветвью условной задачи: задача выполняется, только если
+// условная задача TaskID выберет ветвь Branch (1 - истинная, 2 - ложная).
+type Guard struct {
+	TaskID string `json:"task_id"`
+	Branch int    `json:"branch"`
+}
+
+// Ready сообщает, известны ли все операнды задачи и выбраны ли ветви, в которых она находится.
+func (t *Task) Ready() bool {
+	for _, dep := range t.DependsOn {
+		if dep != "" {
+			return false
+		}
+	}
+	return len(t.Guards) == 0
+}
+
+// SetArg подставляет значение операнда с индексом i, вычисленное другой задачей.
+func (t *Task) SetArg(i int, v Value) {
+	t.Args[i] = v
+	t.DependsOn[i] = ""
+	switch i {
+	case 0:
+		t.Arg1 = v.Approx()
+	case 1:
+		t.Arg2 = v.Approx()
+	}
+}
+
+// Branch возвращает номер ветви, которую выбирает условная задача с известным условием.
+func (t *Task) Branch() int {
+	if Truthy(t.Args[0]) {
+		return 1
+	}
+	return 2
 }
 
 var operationTimes = LoadEnv()
 
+// arity - число операндов операций и функций.
+var arity = map[string]int{
+	"+": 2, "-": 2, "*": 2, "/": 2, "^": 2,
+	"<": 2, "<=": 2, "==": 2, "!=": 2, ">=": 2, ">": 2,
+	"and": 2, "or": 2, "not": 1,
+	"re": 1, "im": 1, "abs": 1, "arg": 1, "conj": 1, "sqrt": 1, "fact": 1, "ln": 1, "exp": 1,
+	"dot": 2, "matmul": 2, "transpose": 1, "det": 1, "inv": 1,
+	"sum": 1, "avg": 1, "median": 1, "stddev": 1, "product": 1,
+	"if": 3,
+}
+
+var comparisons = []string{"<", "<=", "==", "!=", ">=", ">"}
+
+// functions - имена, вызываемые со скобками: name(arg, ...).
+var functions = map[string]bool{"re": true, "im": true, "abs": true, "arg": true, "conj": true, "sqrt": true, "fact": true, "ln": true, "exp": true, "if": true,
+	"dot": true, "matmul": true, "transpose": true, "det": true, "inv": true,
+	"sum": true, "avg": true, "median": true, "stddev": true, "product": true}
+
+func IsFunction(name string) bool {
+	return functions[name]
+}
+
+// Arity возвращает число операндов операции или 0, если token - не операция.
+func Arity(token string) int {
+	if n, ok := arrayArity(token); ok {
+		return n
+	}
+	if n, ok := reduceArity(token); ok {
+		return n
+	}
+	return arity[token]
+}
+
+// ArrayOp возвращает операцию, собирающую вектор или матрицу из n элементов: "[n]".
+func ArrayOp(n int) string {
+	return "[" + strconv.Itoa(n) + "]"
+}
+
+// IsArrayOp сообщает, что op собирает вектор или матрицу.
+func IsArrayOp(op string) bool {
+	_, ok := arrayArity(op)
+	return ok
+}
+
+func arrayArity(op string) (int, bool) {
+	if len(op) < 3 || op[0] != '[' || op[len(op)-1] != ']' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(op[1 : len(op)-1])
+	return n, err == nil && n > 0
+}
+
 func LoadEnv() map[string]int {
 	envVars := map[string]string{
 		"TIME_ADDITION_MS":        "1000",
 		"TIME_SUBTRACTION_MS":     "1000",
 		"TIME_MULTIPLICATIONS_MS": "2000",
 		"TIME_DIVISIONS_MS":       "2000",
+		"TIME_POWER_MS":           "2000",
+		"TIME_FUNCTIONS_MS":       "1000",
+		"TIME_COMPARISONS_MS":     "1000",
 	}
 	times := make(map[string]int)
 	for key, defaultVal := range envVars {
@@ -41,125 +142,514 @@ func LoadEnv() map[string]int {
 				times["*"] = ms
 			case "TIME_DIVISIONS_MS":
 				times["/"] = ms
+			case "TIME_POWER_MS":
+				times["^"] = ms
+			case "TIME_FUNCTIONS_MS":
+				for name := range functions {
+					if name != "if" {
+						times[name] = ms
+					}
+				}
+			case "TIME_COMPARISONS_MS":
+				for _, op := range append(comparisons, "and", "or", "not") {
+					times[op] = ms
+				}
 			}
 		}
 	}
 	return times
 }
 
+// precedence - приоритеты операторов; "?" и "?:" - тернарный условный оператор.
+var precedence = map[string]int{
+	"?":   1,
+	"?:":  1,
+	"or":  2,
+	"and": 3,
+	"not": 4,
+	"<":   5,
+	"<=":  5,
+	"==":  5,
+	"!=":  5,
+	">=":  5,
+	">":   5,
+	"+":   6,
+	"-":   6,
+	"*":   7,
+	"/":   7,
+	"^":   8,
+}
+
+var rightAssoc = map[string]bool{"^": true, "?": true, "?:": true, "not": true}
+
+// callFrame описывает открытую скобку: для вызова функции хранит её имя и число
+// уже встреченных аргументов.
+type callFrame struct {
+	function string
+	args     int
+	pos      int
+	// ranged - первый аргумент агрегатной функции - диапазон; variable - объявленная
+	// переменная диапазона, outer - переменные вне вызова.
+	ranged   bool
+	variable string
+	outer    map[string]bool
+}
+
+// errorAt создаёт ошибку разбора, указывающую на лексему tok.
+func errorAt(tok *Token, code ErrorCode, id MessageID, args ...any) *ParseError {
+	return newParseError(code, tok.Pos, tok.Len, errorf(id, args...))
+}
+
 func InfixToPostfix(expression string) ([]string, error) {
-	expression = strings.ReplaceAll(expression, " ", "")
-	if expression == "" {
-		return nil, errors.New("пустое выражение")
+	return infixToPostfix(expression, nil)
+}
+
+// infixToPostfix переводит выражение в постфиксную запись; имена из variables
+// считаются операндами.
+func infixToPostfix(expression string, variables map[string]bool) ([]string, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, newParseError(CodeEmptyExpression, 0, 0, errorf(MsgEmptyExpression))
+	}
+	tokens, err := Tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	if tokens, err = joinUnits(tokens, variables); err != nil {
+		return nil, err
 	}
 
 	var postfix []string
-	var stack []rune
-	precedence := map[rune]int{
-		'+': 1,
-		'-': 1,
-		'*': 2,
-		'/': 2,
-	}
+	var stack []string
+	var frames []callFrame
+	// questions - позиции '?', для которых ещё не встретилось ':'.
+	var questions []*Token
 
-	isOperator := func(ch rune) bool {
-		_, exists := precedence[ch]
-		return exists
+	// emit переносит оператор из стека в выходную последовательность.
+	emit := func(op string) error {
+		switch op {
+		case "?":
+			return errorAt(questions[len(questions)-1], CodeUnmatchedConditional, MsgQuestionWithoutColon)
+		case "?:":
+			op = "if"
+		case "..":
+			// границы диапазона остаются операндами агрегатной функции
+			return nil
+		}
+		postfix = append(postfix, op)
+		return nil
+	}
+	// popWhile выталкивает операторы из стека, пока выполняется условие.
+	popWhile := func(cond func(top string) bool) error {
+		for len(stack) > 0 && cond(stack[len(stack)-1]) {
+			if err := emit(stack[len(stack)-1]); err != nil {
+				return err
+			}
+			stack = stack[:len(stack)-1]
+		}
+		return nil
+	}
+	// pushBinary кладёт в стек бинарный оператор op, предварительно вытолкнув
+	// операторы с большим приоритетом.
+	pushBinary := func(op string) error {
+		err := popWhile(func(top string) bool {
+			return precedence[top] > precedence[op] || precedence[top] == precedence[op] && !rightAssoc[op]
+		})
+		stack = append(stack, op)
+		return err
 	}
 
 	openParentheses := 0
-	lastChar := ' '
-
-	for i, ch := range expression {
-		switch {
-		case ch >= '0' && ch <= '9' || ch == '.':
-			if lastChar >= '0' && lastChar <= '9' || lastChar == '.' {
-				postfix[len(postfix)-1] += string(ch)
-			} else {
-				postfix = append(postfix, string(ch))
-			}
-			lastChar = ch
-		case isOperator(ch):
-			if isOperator(lastChar) || lastChar == '(' || i == 0 {
-				return nil, fmt.Errorf("некорректный оператор: %c", ch)
-			}
-			for len(stack) > 0 && isOperator(stack[len(stack)-1]) && precedence[stack[len(stack)-1]] >= precedence[ch] {
-				postfix = append(postfix, string(stack[len(stack)-1]))
-				stack = stack[:len(stack)-1]
+	var last *Token
+	// operandEnded сообщает, завершился ли перед текущей лексемой операнд.
+	operandEnded := func() bool {
+		if last == nil {
+			return false
+		}
+		switch last.Kind {
+		case TokenNumber, TokenRParen, TokenRBracket, TokenBang:
+			return true
+		case TokenIdent:
+			return last.Text == ImaginaryUnit || variables[last.Text]
+		}
+		return false
+	}
+
+	for i := range tokens {
+		tok := &tokens[i]
+		startsOperand := tok.Kind == TokenNumber || tok.Kind == TokenLParen || tok.Kind == TokenLBracket ||
+			tok.Kind == TokenIdent && (tok.Text == ImaginaryUnit || variables[tok.Text] || tok.Text == "not" || IsFunction(tok.Text))
+		if startsOperand && operandEnded() {
+			if tok.Text == "not" {
+				return nil, errorAt(tok, CodeInvalidOperator, MsgInvalidOperator, tok.Text)
+			}
+			return nil, errorAt(tok, CodeExtraOperand, MsgExtraOperand)
+		}
+		switch tok.Kind {
+		case TokenNumber:
+			postfix = append(postfix, tok.Text)
+		case TokenIdent:
+			switch {
+			case i+1 < len(tokens) && tokens[i+1].Kind == TokenArrow:
+				frame := &callFrame{}
+				if len(frames) > 0 {
+					frame = &frames[len(frames)-1]
+				}
+				if !frame.ranged || frame.args != 2 || frame.variable != "" || last.Kind != TokenComma {
+					return nil, errorAt(&tokens[i+1], CodeInvalidRange, MsgMisplacedArrow)
+				}
+				if !ValidVariable(tok.Text) {
+					return nil, errorAt(tok, CodeInvalidRange, MsgRangeVariable, tok.Text)
+				}
+				frame.variable, frame.outer = tok.Text, variables
+				variables = maps.Clone(variables)
+				if variables == nil {
+					variables = make(map[string]bool, 1)
+				}
+				variables[tok.Text] = true
+			case tok.Text == ImaginaryUnit || variables[tok.Text]:
+				postfix = append(postfix, tok.Text)
+			case tok.Text == "and" || tok.Text == "or":
+				if !operandEnded() {
+					return nil, errorAt(tok, CodeInvalidOperator, MsgInvalidOperator, tok.Text)
+				}
+				if err := pushBinary(tok.Text); err != nil {
+					return nil, err
+				}
+			case tok.Text == "not":
+				stack = append(stack, tok.Text)
+			case i+1 < len(tokens) && tokens[i+1].Kind == TokenLParen:
+				if !IsFunction(tok.Text) {
+					return nil, errorAt(tok, CodeUnknownFunction, MsgUnknownFunction, tok.Text)
+				}
+				stack = append(stack, tok.Text)
+			default:
+				return nil, errorAt(tok, CodeInvalidCharacter, MsgInvalidCharacter, tok.Text)
+			}
+		case TokenOperator:
+			if !operandEnded() {
+				return nil, errorAt(tok, CodeInvalidOperator, MsgInvalidOperator, tok.Text)
+			}
+			if err := pushBinary(tok.Text); err != nil {
+				return nil, err
+			}
+		case TokenBang:
+			if !operandEnded() || last.Kind == TokenIdent && !variables[last.Text] {
+				return nil, errorAt(tok, CodeInvalidOperator, MsgInvalidOperator, tok.Text)
+			}
+			postfix = append(postfix, "fact")
+		case TokenQuestion:
+			if !operandEnded() {
+				return nil, errorAt(tok, CodeInvalidOperator, MsgInvalidOperator, tok.Text)
+			}
+			if err := pushBinary("?"); err != nil {
+				return nil, err
+			}
+			questions = append(questions, tok)
+		case TokenColon:
+			if !operandEnded() {
+				return nil, errorAt(tok, CodeInvalidOperator, MsgInvalidOperator, tok.Text)
+			}
+			if err := popWhile(func(top string) bool { return top != "?" && top != "(" && top != "[" }); err != nil {
+				return nil, err
 			}
-			stack = append(stack, ch)
-			lastChar = ch
-		case ch == '(':
-			stack = append(stack, ch)
+			if len(stack) == 0 || stack[len(stack)-1] != "?" {
+				return nil, errorAt(tok, CodeUnmatchedConditional, MsgColonWithoutQuestion)
+			}
+			stack[len(stack)-1] = "?:"
+			questions = questions[:len(questions)-1]
+		case TokenLParen:
+			frame := callFrame{pos: i}
+			if len(stack) > 0 && IsFunction(stack[len(stack)-1]) && last != nil && last.Kind == TokenIdent {
+				frame = callFrame{function: stack[len(stack)-1], args: 1, pos: i - 1}
+			}
+			frames = append(frames, frame)
+			stack = append(stack, "(")
 			openParentheses++
-			lastChar = ch
-		case ch == ')':
-			if openParentheses == 0 {
-				return nil, errors.New("некорректное выражение: несогласованные скобки")
+		case TokenRange:
+			if openParentheses == 0 || !aggregates[frames[len(frames)-1].function] || frames[len(frames)-1].args != 1 || frames[len(frames)-1].ranged {
+				return nil, errorAt(tok, CodeInvalidRange, MsgMisplacedRange)
 			}
-			for len(stack) > 0 && stack[len(stack)-1] != '(' {
-				postfix = append(postfix, string(stack[len(stack)-1]))
-				stack = stack[:len(stack)-1]
+			if !operandEnded() {
+				return nil, errorAt(tok, CodeMissingOperand, MsgMissingOperand)
+			}
+			if err := popWhile(func(top string) bool { return top != "(" }); err != nil {
+				return nil, err
+			}
+			stack = append(stack, "..")
+			frames[len(frames)-1].ranged = true
+		case TokenArrow:
+			if last == nil || last.Kind != TokenIdent || len(frames) == 0 || frames[len(frames)-1].variable != last.Text {
+				return nil, errorAt(tok, CodeInvalidRange, MsgMisplacedArrow)
+			}
+		case TokenLBracket:
+			frames = append(frames, callFrame{function: "[", args: 1, pos: i})
+			stack = append(stack, "[")
+			openParentheses++
+		case TokenComma:
+			if openParentheses == 0 || frames[len(frames)-1].function == "" {
+				return nil, errorAt(tok, CodeMisplacedComma, MsgMisplacedComma)
+			}
+			if !operandEnded() {
+				return nil, errorAt(tok, CodeMissingOperand, MsgMissingOperand)
+			}
+			if err := popWhile(func(top string) bool { return top != "(" && top != "[" }); err != nil {
+				return nil, err
+			}
+			if frame := frames[len(frames)-1]; frame.ranged && frame.args == 2 {
+				return nil, errorAt(tok, CodeInvalidRange, MsgRangeArguments, frame.function)
+			}
+			frames[len(frames)-1].args++
+		case TokenRBracket:
+			if openParentheses == 0 || frames[len(frames)-1].function != "[" {
+				return nil, errorAt(tok, CodeUnbalancedParentheses, MsgUnbalancedParentheses)
+			}
+			if !operandEnded() {
+				return nil, errorAt(tok, CodeMissingOperand, MsgMissingOperand)
+			}
+			if err := popWhile(func(top string) bool { return top != "[" }); err != nil {
+				return nil, err
+			}
+			stack = stack[:len(stack)-1]
+			postfix = append(postfix, ArrayOp(frames[len(frames)-1].args))
+			frames = frames[:len(frames)-1]
+			openParentheses--
+		case TokenRParen:
+			if openParentheses == 0 || frames[len(frames)-1].function == "[" {
+				return nil, errorAt(tok, CodeUnbalancedParentheses, MsgUnbalancedParentheses)
+			}
+			if err := popWhile(func(top string) bool { return top != "(" }); err != nil {
+				return nil, err
 			}
 			stack = stack[:len(stack)-1]
+			frame := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			switch {
+			case aggregates[frame.function]:
+				if !operandEnded() {
+					return nil, errorAt(tok, CodeMissingOperand, MsgMissingOperand)
+				}
+				if frame.ranged && frame.args == 2 && frame.variable == "" {
+					return nil, errorAt(tok, CodeInvalidRange, MsgRangeArguments, frame.function)
+				}
+				switch {
+				case frame.ranged:
+					postfix = append(postfix, RangeOp(frame.function, frame.variable))
+				case frame.args > 1:
+					postfix = append(postfix, ArrayOp(frame.args), frame.function)
+				default:
+					postfix = append(postfix, frame.function)
+				}
+				if frame.variable != "" {
+					variables = frame.outer
+				}
+				stack = stack[:len(stack)-1]
+			case frame.function != "":
+				if frame.args != Arity(frame.function) {
+					call := &tokens[frame.pos]
+					return nil, newParseError(CodeArgumentCount, call.Pos, tok.Pos+tok.Len-call.Pos,
+						errorf(MsgArgumentCount, frame.function, Arity(frame.function)))
+				}
+				postfix = append(postfix, frame.function)
+				stack = stack[:len(stack)-1]
+			}
 			openParentheses--
-			lastChar = ch
-		default:
-			return nil, fmt.Errorf("некорректный символ: %c", ch)
 		}
+		last = tok
 	}
 
-	if isOperator(lastChar) {
-		return nil, errors.New("некорректное выражение: недостаточно операндов")
+	if !operandEnded() && last.Kind != TokenLParen {
+		return nil, newParseError(CodeMissingOperand, last.Pos+last.Len, 0, errorf(MsgMissingOperand))
 	}
 
 	if openParentheses > 0 {
-		return nil, errors.New("некорректное выражение: несогласованные скобки")
+		return nil, errorAt(&tokens[frames[len(frames)-1].pos], CodeUnbalancedParentheses, MsgUnbalancedParentheses)
 	}
 
 	for len(stack) > 0 {
-		if stack[len(stack)-1] == '(' {
-			return nil, errors.New("некорректное выражение: несогласованные скобки")
+		if err := emit(stack[len(stack)-1]); err != nil {
+			return nil, err
 		}
-		postfix = append(postfix, string(stack[len(stack)-1]))
 		stack = stack[:len(stack)-1]
 	}
 
 	return postfix, nil
 }
 
-func GenerateTasks(postfix []string) ([]*Task, error) {
-	var stack []float64
-	var tasks []*Task
-	taskCounter := 0
-
-	for _, token := range postfix {
-		if num, err := strconv.ParseFloat(token, 64); err == nil {
-			stack = append(stack, num)
-		} else {
-			if len(stack) < 2 {
-				return nil, errors.New("некорректное выражение: недостаточно операндов")
+// joinUnits объединяет число и следующую за ним запись единицы измерения в одну
+// лексему-величину "5 km/h". Переменные, в том числе переменные диапазонов, единицами
+// не считаются; незнакомое имя сразу после числа - неизвестная единица.
+func joinUnits(tokens []Token, variables map[string]bool) ([]Token, error) {
+	bound := maps.Clone(variables)
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].Kind == TokenIdent && tokens[i+1].Kind == TokenArrow {
+			if bound == nil {
+				bound = make(map[string]bool)
 			}
-			arg2 := stack[len(stack)-1]
-			arg1 := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
+			bound[tokens[i].Text] = true
+		}
+	}
+	// name сообщает, что tokens[i] - имя, не являющееся вызовом функции или переменной.
+	name := func(i int) bool {
+		return i < len(tokens) && tokens[i].Kind == TokenIdent && !bound[tokens[i].Text] &&
+			!(i+1 < len(tokens) && (tokens[i+1].Kind == TokenLParen || tokens[i+1].Kind == TokenArrow))
+	}
+	operator := func(i int, ops string) bool {
+		return i < len(tokens) && tokens[i].Kind == TokenOperator && strings.Contains(ops, tokens[i].Text)
+	}
 
-			taskCounter++
-			task := &Task{
-				ID:            fmt.Sprintf("task-%d", taskCounter),
-				Arg1:          arg1,
-				Arg2:          arg2,
-				Operation:     token,
-				OperationTime: operationTimes[token],
+	var joined []Token
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.Kind != TokenNumber || !name(i+1) {
+			joined = append(joined, tok)
+			continue
+		}
+		if !IsUnit(tokens[i+1].Text) {
+			if ValidVariable(tokens[i+1].Text) {
+				return nil, errorAt(&tokens[i+1], CodeUnknownUnit, MsgUnknownUnit, tokens[i+1].Text)
+			}
+			joined = append(joined, tok)
+			continue
+		}
+		unit := tokens[i+1].Text
+		j := i + 2
+		for {
+			if operator(j, "^") {
+				k, sign := j+1, ""
+				if operator(k, "-") {
+					k, sign = k+1, "-"
+				}
+				if k < len(tokens) && tokens[k].Kind == TokenNumber && strings.Trim(tokens[k].Text, "0123456789") == "" {
+					unit += "^" + sign + tokens[k].Text
+					j = k + 1
+				}
+			}
+			if !operator(j, "*/") || !name(j+1) || !IsUnit(tokens[j+1].Text) {
+				break
 			}
-			tasks = append(tasks, task)
-			stack = append(stack, 0)
+			unit += tokens[j].Text + tokens[j+1].Text
+			j += 2
 		}
+		last := tokens[j-1]
+		joined = append(joined, Token{Kind: TokenNumber, Text: tok.Text + " " + unit, Pos: tok.Pos, Len: last.Pos + last.Len - tok.Pos})
+		i = j - 1
+	}
+	return joined, nil
+}
+
+func GenerateTasks(postfix []string) ([]*Task, error) {
+	return GenerateTasksMode(postfix, ModeFloat)
+}
+
+// operand - операнд задачи: либо литерал, либо ссылка на задачу, результат которой
+// станет операндом. tasks - задачи, из которых вычисляется операнд.
+type operand struct {
+	value  Value
+	taskID string
+	tasks  []string
+}
+
+// GenerateTasksMode создаёт задачи для выражения в постфиксной записи. Одинаковые
+// подвыражения вычисляются одной задачей, результат которой получают все потребители.
+// Подвыражения из разных ветвей условий не объединяются: задачи невыбранной ветви
+// отменяются целиком.
+func GenerateTasksMode(postfix []string, mode Mode) ([]*Task, error) {
+	tree, err := BuildTree(postfix)
+	if err != nil {
+		return nil, err
+	}
+	g := newTaskGraph(mode)
+	if _, err := g.generate(tree, ""); err != nil {
+		return nil, err
+	}
+	return g.tasks, nil
+}
+
+func newTaskGraph(mode Mode) *taskGraph {
+	return &taskGraph{mode: mode, byID: make(map[string]*Task), shared: make(map[string]operand)}
+}
+
+type taskGraph struct {
+	mode   Mode
+	tasks  []*Task
+	byID   map[string]*Task
+	shared map[string]operand
+	scopes int
+}
+
+// generate создаёт задачи для поддерева. scope - путь из ветвей условий, внутри
+// которого находится поддерево.
+func (g *taskGraph) generate(n *Node, scope string) (operand, error) {
+	if n.IsLiteral() {
+		value, err := n.Value(g.mode)
+		return operand{value: value}, err
+	}
+	key := scope + n.String()
+	if shared, ok := g.shared[key]; ok {
+		return shared, nil
 	}
 
-	if len(stack) != 1 {
-		return nil, errors.New("некорректное выражение: лишние операнды")
+	args := make([]operand, len(n.operands()))
+	ifScope := 0
+	if n.Op == "if" {
+		g.scopes++
+		ifScope = g.scopes
+	}
+	for i, arg := range n.operands() {
+		argScope := scope
+		if n.Op == "if" && i > 0 {
+			argScope = fmt.Sprintf("%s%d.%d/", scope, ifScope, i)
+		}
+		var err error
+		if args[i], err = g.generate(arg, argScope); err != nil {
+			return operand{}, err
+		}
 	}
 
-	return tasks, nil
+	task := &Task{
+		ID:            fmt.Sprintf("task-%d", len(g.tasks)+1),
+		Arg1:          args[0].value.Approx(),
+		Operation:     n.Op,
+		OperationTime: operationTimes[n.Op],
+		Mode:          g.mode,
+		Local:         n.Op == "if" || IsArrayOp(n.Op),
+	}
+	if name, variable, ok := ParseRangeOp(n.Op); ok {
+		task.OperationTime = operationTimes[name]
+		if variable != "" {
+			task.Body = n.Args[2].String()
+		}
+	}
+	if len(args) > 1 {
+		task.Arg2 = args[1].value.Approx()
+	}
+	result := operand{taskID: task.ID}
+	for i, arg := range args {
+		task.Args = append(task.Args, arg.value)
+		task.DependsOn = append(task.DependsOn, arg.taskID)
+		result.tasks = append(result.tasks, arg.tasks...)
+		if n.Op == "if" && i > 0 {
+			guard := Guard{TaskID: task.ID, Branch: i}
+			for _, id := range arg.tasks {
+				guards := g.byID[id].Guards
+				if len(guards) > 0 && guards[len(guards)-1] == guard {
+					continue
+				}
+				g.byID[id].Guards = append(guards, guard)
+			}
+		}
+	}
+	result.tasks = append(result.tasks, task.ID)
+	g.tasks = append(g.tasks, task)
+	g.byID[task.ID] = task
+	g.shared[key] = result
+	return result, nil
+}
+
+// LiteralValue возвращает значение выражения, состоящего из одного числа.
+func LiteralValue(postfix []string, mode Mode) (Value, error) {
+	if len(postfix) != 1 {
+		return Value{}, errorf(MsgExtraOperand)
+	}
+	return ParseValue(mode, postfix[0])
 }
@@ -1,8 +1,10 @@
 package calculation_test
 
 import (
+	"math/big"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
@@ -33,6 +35,36 @@ func TestInfixToPostfix(t *testing.T) {
 		{"double operator", "2+2**2", nil, "некорректный оператор: *"},
 		{"unmatched parentheses", "((2+2)", nil, "некорректное выражение: несогласованные скобки"},
 		{"invalid symbol", "2+a", nil, "некорректный символ: a"},
+		{"complex literal", "3+4i", []string{"3", "4i", "+"}, ""},
+		{"imaginary unit", "2*i", []string{"2", "i", "*"}, ""},
+		{"function call", "sqrt(0-4)*2", []string{"0", "4", "-", "sqrt", "2", "*"}, ""},
+		{"nested functions", "abs(conj(3+4i))", []string{"3", "4i", "+", "conj", "abs"}, ""},
+		{"unknown function", "foo(1)", nil, "неизвестная функция: foo"},
+		{"right associative power", "2^3^2", []string{"2", "3", "2", "^", "^"}, ""},
+		{"power priority", "2*3^2", []string{"2", "3", "2", "^", "*"}, ""},
+		{"factorial", "5!+1", []string{"5", "fact", "1", "+"}, ""},
+		{"factorial of parentheses", "(2+3)!", []string{"2", "3", "+", "fact"}, ""},
+		{"factorial without operand", "!5", nil, "некорректный оператор: !"},
+		{"scientific notation", "1.5e-3*2E+2", []string{"1.5e-3", "2E+2", "*"}, ""},
+		{"radix literals", "0xFF+0o17-0b1010", []string{"255", "15", "+", "10", "-"}, ""},
+		{"digit separators", "1_000_000/2", []string{"1000000", "2", "/"}, ""},
+		{"spaces between tokens", " 2 * ( 3 + 4 ) ", []string{"2", "3", "4", "+", "*"}, ""},
+		{"repeated decimal point", "1.2.3", nil, `некорректное число "1.2.3" в позиции 4: лишняя десятичная точка`},
+		{"missing exponent digits", "2+1e", nil, `некорректное число "1e" в позиции 5: пропущены цифры экспоненты`},
+		{"empty radix literal", "0x+1", nil, `некорректное число "0x" в позиции 3: нет цифр после префикса 0x`},
+		{"invalid binary digit", "0b102", nil, `некорректное число "0b102" в позиции 5: недопустимый символ '2'`},
+		{"doubled separator", "1__000", nil, `некорректное число "1__000" в позиции 2: разделитель _ допустим только между цифрами`},
+		{"trailing separator", "10_+1", nil, `некорректное число "10_" в позиции 3: разделитель _ допустим только между цифрами`},
+		{"comparison and logic", "1<2 and 3>=2 or 0", []string{"1", "2", "<", "3", "2", ">=", "and", "0", "or"}, ""},
+		{"negation", "not 1==2", []string{"1", "2", "==", "not"}, ""},
+		{"conditional function", "if(1>2, 3, 4*5)", []string{"1", "2", ">", "3", "4", "5", "*", "if"}, ""},
+		{"ternary", "1!=2 ? 3 : 4", []string{"1", "2", "!=", "3", "4", "if"}, ""},
+		{"nested ternary", "1 ? 2 : 0 ? 4 : 5", []string{"1", "2", "0", "4", "5", "if", "if"}, ""},
+		{"conditional arity", "if(1, 2)", nil, "функция if ожидает аргументов: 3"},
+		{"question without colon", "1 ? 2", nil, "некорректное выражение: '?' без ':'"},
+		{"colon without question", "1 : 2", nil, "некорректное выражение: ':' без '?'"},
+		{"comma outside call", "1, 2", nil, "некорректное выражение: запятая вне вызова функции"},
+		{"single equals", "2=3", nil, "некорректный символ: ="},
 	}
 
 	for _, tt := range tests {
@@ -105,3 +137,1085 @@ func TestGenerateTasks(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyRational(t *testing.T) {
+	postfix, err := calculation.InfixToPostfix("1/3+1/6")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tasks, err := calculation.GenerateTasksMode(postfix, calculation.ModeRational)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tasks) != 3 {
+		t.Fatalf("expected 3 tasks, got %d", len(tasks))
+	}
+	if !tasks[0].Ready() || !tasks[1].Ready() || tasks[2].Ready() {
+		t.Fatalf("expected only the divisions to be ready")
+	}
+
+	third, _ := calculation.Apply(calculation.ModeRational, "/", tasks[0].Args[0], tasks[0].Args[1])
+	sixth, _ := calculation.Apply(calculation.ModeRational, "/", tasks[1].Args[0], tasks[1].Args[1])
+	tasks[2].SetArg(0, third)
+	tasks[2].SetArg(1, sixth)
+	sum, err := calculation.Apply(calculation.ModeRational, "+", tasks[2].Args[0], tasks[2].Args[1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum.String() != "1/2" {
+		t.Errorf("expected 1/2, got %s", sum)
+	}
+	if sum.Approx() != 0.5 {
+		t.Errorf("expected approximation 0.5, got %f", sum.Approx())
+	}
+
+	zero, _ := calculation.ParseValue(calculation.ModeRational, "0")
+	if _, err := calculation.Apply(calculation.ModeRational, "/", third, zero); err != calculation.ErrDivisionByZero {
+		t.Errorf("expected division by zero error, got %v", err)
+	}
+}
+
+func TestApplyComplex(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		expected   string
+	}{
+		{"sqrt of negative", "sqrt(0-4)", "2i"},
+		{"sqrt of positive", "sqrt(9)", "3"},
+		{"multiplication", "(1+2i)*(3-i)", "5+5i"},
+		{"modulus", "abs(3+4i)", "5"},
+		{"conjugate", "conj(3+4i)", "3-4i"},
+		{"real part", "re(3+4i)", "3"},
+		{"imaginary part", "im(3+4i)", "4"},
+		{"i squared", "i*i", "-1"},
+		{"i to the power", "i^2", "-1"},
+		{"integer power", "(1+i)^4", "-4"},
+		{"negative power", "i^(0-1)", "-1i"},
+		{"fractional power", "4i^0.5", "1.4142135623730951+1.414213562373095i"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postfix, err := calculation.InfixToPostfix(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tasks, err := calculation.GenerateTasks(postfix)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			results := map[string]calculation.Value{}
+			var last calculation.Value
+			for _, task := range tasks {
+				for i, dep := range task.DependsOn {
+					if dep != "" {
+						task.SetArg(i, results[dep])
+					}
+				}
+				last, err = calculation.Apply(task.Mode, task.Operation, task.Args...)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				results[task.ID] = last
+			}
+			if last.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, last)
+			}
+		})
+	}
+}
+
+func TestApplyInterval(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		expected   string
+		err        string
+	}{
+		{"uncertain literal", "2.5±0.1", "[2.4, 2.6]", ""},
+		{"spaces around sign", "2 ± 0.5", "[1.5, 2.5]", ""},
+		{"inexact literal", "0.1", "[0.09999999999999999, 0.1]", ""},
+		{"directed addition", "0.1+0.2", "[0.29999999999999993, 0.30000000000000004]", ""},
+		{"product of signs", "(0-1±2)*(3±1)", "[-12, 4]", ""},
+		{"division", "1/(3±1)", "[0.25, 0.5]", ""},
+		{"even power", "(0-1±2)^2", "[0, 9]", ""},
+		{"odd power", "(0-1±2)^3", "[-27, 1]", ""},
+		{"negative power", "(2±0)^(0-2)", "[0.25, 0.25]", ""},
+		{"square root", "sqrt(4±0)", "[2, 2]", ""},
+		{"modulus", "abs(0-1±2)", "[0, 3]", ""},
+		{"certain comparison", "(1±1) < 3", "[1, 1]", ""},
+		{"division by zero", "1/(0±1)", "", "деление на ноль"},
+		{"overlapping comparison", "(1±1) < 1.5", "", "результат сравнения отрезков [0, 2] и [1.5, 1.5] не определён: они пересекаются"},
+		{"fractional exponent", "2^0.5", "", "в режиме interval показатель степени должен быть целым"},
+		{"negative root", "sqrt(0±1)", "", "корень из отрезка [-1, 1] с отрицательной границей не определён"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			v, err := calculation.Evaluate(tree, calculation.ModeInterval)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, v)
+			}
+		})
+	}
+
+	interval, _ := calculation.ParseValue(calculation.ModeInterval, "2.5±0.1")
+	if interval.Approx() != 2.5 {
+		t.Errorf("expected midpoint 2.5, got %v", interval.Approx())
+	}
+	if _, err := calculation.ParseValue(calculation.ModeFloat, "2.5±0.1"); err == nil || err.Error() != "числа с погрешностью недоступны в режиме float" {
+		t.Errorf("expected uncertainty to be rejected in float mode, got %v", err)
+	}
+	tree, _ := calculation.Parse("2*(2.5±0.1)")
+	if tree.String() != "2 * (2.5±0.1)" || tree.LaTeX() != `2 \cdot \left(2.5 \pm 0.1\right)` {
+		t.Errorf("unexpected formatting %q, %q", tree.String(), tree.LaTeX())
+	}
+}
+
+func TestUnits(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		mode       calculation.Mode
+		expected   string
+		err        string
+	}{
+		{"speed", "5 km / 2 h + 3 m/s", calculation.ModeRational, "133/36 m/s", ""},
+		{"area", "2 m * 3 m", calculation.ModeFloat, "6 m^2", ""},
+		{"power of quantity", "(2 m)^2", calculation.ModeFloat, "4 m^2", ""},
+		{"square root", "sqrt(9 m^2)", calculation.ModeFloat, "3 m", ""},
+		{"reciprocal", "1 / (2 s)", calculation.ModeFloat, "0.5 s^-1", ""},
+		{"derived unit", "10 N / 2 kg", calculation.ModeFloat, "5 m/s^2", ""},
+		{"non-SI unit", "1 kWh", calculation.ModeInteger, "3600000 kg*m^2/s^2", ""},
+		{"comparison", "2 m > 150 cm", calculation.ModeFloat, "1", ""},
+		{"aggregate", "sum(1 m, 20 cm)", calculation.ModeRational, "6/5 m", ""},
+		{"interval", "2±0.5 m * 2", calculation.ModeInterval, "[3, 5] m", ""},
+		{"metres plus seconds", "3 m + 2 s", calculation.ModeFloat, "", "несовместимые размерности: m и s"},
+		{"metres below seconds", "2 m < 3 s", calculation.ModeFloat, "", "несовместимые размерности: m и s"},
+		{"dimensioned exponent", "2^(1 m)", calculation.ModeFloat, "", "показатель степени должен быть безразмерным, а не m"},
+		{"function argument", "fact(3 s)", calculation.ModeFloat, "", "аргумент функции fact должен быть безразмерным, а не s"},
+		{"odd root", "sqrt(2 m)", calculation.ModeFloat, "", "корень из величины размерности m не определён: показатели степеней должны быть чётными"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expression)
+			if err == nil {
+				var v calculation.Value
+				if v, err = calculation.Evaluate(tree, tt.mode); err == nil {
+					if v.String() != tt.expected {
+						t.Errorf("expected %s, got %s", tt.expected, v)
+					}
+					return
+				}
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	v, _ := calculation.ParseValue(calculation.ModeRational, "5 km/h")
+	converted, err := calculation.Convert(calculation.ModeRational, v, "m/s")
+	if err != nil || converted.String() != "25/18" {
+		t.Errorf("expected 25/18 m/s, got %v, %v", converted, err)
+	}
+	if _, err := calculation.Convert(calculation.ModeFloat, v, "s"); err == nil || err.Error() != "величину размерности m/s нельзя перевести в s" {
+		t.Errorf("expected conversion to be rejected, got %v", err)
+	}
+	if _, err := calculation.ParseUnit("m^x"); err == nil || err.Error() != "некорректная запись единицы измерения: m^x" {
+		t.Errorf("expected invalid unit, got %v", err)
+	}
+	errs := calculation.Check("2 parsec + 1", calculation.ModeFloat)
+	if len(errs) != 1 || errs[0].Code != calculation.CodeUnknownUnit || errs[0].Pos != 2 || errs[0].Len != 6 {
+		t.Errorf("expected unknown unit error, got %v", errs)
+	}
+	tree, _ := calculation.Parse("2 * 9.81 m/s^2")
+	if tree.String() != "2 * (9.81 m/s^2)" || tree.LaTeX() != `2 \cdot \left(9.81\,\mathrm{m/s^{2}}\right)` {
+		t.Errorf("unexpected formatting %q, %q", tree.String(), tree.LaTeX())
+	}
+	if tree, err := calculation.ParseWith("2 * m", "m"); err != nil || tree.String() != "2 * m" {
+		t.Errorf("expected variable m to take precedence over the unit, got %v, %v", tree, err)
+	}
+}
+
+func TestApplyInteger(t *testing.T) {
+	tests := []struct {
+		name        string
+		expression  string
+		expected    string
+		remainders  int
+		expectedErr string
+	}{
+		{"large power", "2^200", "1606938044258990275541962092341162602522202993782792835301376", 0, ""},
+		{"factorial", "25!", "15511210043330985984000000", 0, ""},
+		{"factorial function", "fact(3)+1", "7", 0, ""},
+		{"exact division", "12/4", "3", 0, ""},
+		{"division with remainder", "7/2*2", "6", 1, ""},
+		{"perfect square root", "sqrt(144)", "12", 0, ""},
+		{"non-integer literal", "2.5+1", "", 0, "в режиме integer допустимы только целые числа: 2.5"},
+		{"negative exponent", "2^(0-1)", "", 0, "в режиме integer показатель степени не может быть отрицательным"},
+		{"huge power", "(2^100000)^100000", "", 0, "степень слишком велика: около 10000100000 бит, допустимо не больше 1048576"},
+		{"huge factorial power", "fact(10000)^100000", "", 0, "степень слишком велика: около 11845900000 бит, допустимо не больше 1048576"},
+		{"irrational root", "sqrt(2)", "", 0, "корень из 2 не является целым числом"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postfix, err := calculation.InfixToPostfix(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tasks, err := calculation.GenerateTasksMode(postfix, calculation.ModeInteger)
+			if err == nil {
+				results := map[string]calculation.Value{}
+				var last calculation.Value
+				remainders := 0
+				for _, task := range tasks {
+					for i, dep := range task.DependsOn {
+						if dep != "" {
+							task.SetArg(i, results[dep])
+						}
+					}
+					last, err = calculation.Apply(task.Mode, task.Operation, task.Args...)
+					if err != nil {
+						break
+					}
+					if last.Rem != nil {
+						remainders++
+					}
+					results[task.ID] = last
+				}
+				if err == nil {
+					if last.String() != tt.expected {
+						t.Errorf("expected %s, got %s", tt.expected, last)
+					}
+					if remainders != tt.remainders {
+						t.Errorf("expected %d remainders, got %d", tt.remainders, remainders)
+					}
+				}
+			}
+			if tt.expectedErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.expectedErr != "" && (err == nil || err.Error() != tt.expectedErr) {
+				t.Errorf("expected error %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestApplyLogic(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     calculation.Mode
+		op       string
+		args     []string
+		expected string
+	}{
+		{"less", calculation.ModeFloat, "<", []string{"1", "2"}, "1"},
+		{"greater or equal", calculation.ModeFloat, ">=", []string{"1", "2"}, "0"},
+		{"rational equality", calculation.ModeRational, "==", []string{"0.5", "1/2"}, "1"},
+		{"integer inequality", calculation.ModeInteger, "!=", []string{"3", "3"}, "0"},
+		{"complex equality", calculation.ModeFloat, "==", []string{"2i", "2i"}, "1"},
+		{"and", calculation.ModeFloat, "and", []string{"1", "0"}, "0"},
+		{"or", calculation.ModeFloat, "or", []string{"1", "0"}, "1"},
+		{"not", calculation.ModeInteger, "not", []string{"0"}, "1"},
+		{"if true", calculation.ModeFloat, "if", []string{"1", "2", "3"}, "2"},
+		{"if false", calculation.ModeFloat, "if", []string{"0", "2", "3"}, "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []calculation.Value
+			for _, token := range tt.args {
+				value, err := calculation.ParseValue(tt.mode, token)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				args = append(args, value)
+			}
+			got, err := calculation.Apply(tt.mode, tt.op, args...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	a, _ := calculation.ParseValue(calculation.ModeFloat, "2i")
+	if _, err := calculation.Apply(calculation.ModeFloat, "<", a, a); err == nil {
+		t.Errorf("expected error comparing complex numbers")
+	}
+}
+
+func TestGenerateTasksConditional(t *testing.T) {
+	postfix, err := calculation.InfixToPostfix("if(2>1, 3*4, 5*6)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tasks, err := calculation.GenerateTasks(postfix)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tasks) != 4 {
+		t.Fatalf("expected 4 tasks, got %d", len(tasks))
+	}
+	cond, then, otherwise, choice := tasks[0], tasks[1], tasks[2], tasks[3]
+	if !cond.Ready() {
+		t.Errorf("expected condition to be ready")
+	}
+	if then.Ready() || otherwise.Ready() {
+		t.Errorf("expected branches to wait for the condition")
+	}
+	if !choice.Local || choice.Operation != "if" {
+		t.Errorf("expected a local if task, got %+v", choice)
+	}
+	if then.Guards[0] != (calculation.Guard{TaskID: choice.ID, Branch: 1}) {
+		t.Errorf("unexpected guard %+v", then.Guards[0])
+	}
+	if otherwise.Guards[0] != (calculation.Guard{TaskID: choice.ID, Branch: 2}) {
+		t.Errorf("unexpected guard %+v", otherwise.Guards[0])
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		expression string
+		mode       calculation.Mode
+		precompute bool
+		expected   string
+	}{
+		{"(2+3)*4", calculation.ModeFloat, false, "(2 + 3) * 4"},
+		{"2-(3-4)", calculation.ModeFloat, false, "2 - (3 - 4)"},
+		{"(2^3)^2", calculation.ModeFloat, false, "(2 ^ 3) ^ 2"},
+		{"(2+3)*1", calculation.ModeFloat, false, "2 + 3"},
+		{"0+(2*3)-0", calculation.ModeFloat, false, "2 * 3"},
+		{"(2+3)^0", calculation.ModeFloat, false, "1"},
+		{"(1/0)*0", calculation.ModeFloat, false, "1 / 0 * 0"},
+		{"if(1, 2+3, 4/0)", calculation.ModeFloat, false, "2 + 3"},
+		{"0 ? 2 : 3*4", calculation.ModeFloat, false, "3 * 4"},
+		{"(2+3)*x", calculation.ModeFloat, false, ""},
+		{"(2+3)*4 - sqrt(16)", calculation.ModeFloat, true, "16"},
+		{"1/3+1/6", calculation.ModeRational, true, "1/2"},
+		{"1/3*3", calculation.ModeRational, false, "1 / 3 * 3"},
+		{"7/2", calculation.ModeInteger, true, "7 / 2"},
+		{"2-5", calculation.ModeFloat, true, "2 - 5"},
+		{"5 m * 0 + 3 s", calculation.ModeFloat, false, "5 m * 0 + 3 s"},
+		{"(5 m)^0 + 0 + 2 s*1", calculation.ModeFloat, false, "(5 m) ^ 0 + 0 + 2 s"},
+		{"(2*3) - 2*3", calculation.ModeFloat, false, "0"},
+		{"2 m - 2 m", calculation.ModeFloat, false, "2 m - 2 m"},
+		{"0/5 + 5/5", calculation.ModeFloat, false, "1"},
+		{"0/0 + (2-1-1)/(2-1-1)", calculation.ModeFloat, false, "0 / 0 + (2 - 1 - 1) / (2 - 1 - 1)"},
+		{"0/(2 m) + (2 m)/(2 m)", calculation.ModeFloat, false, "0 / (2 m) + 2 m / (2 m)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expression)
+			if tt.expected == "" {
+				if err == nil {
+					t.Errorf("expected error, got %s", tree)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := calculation.Simplify(tree, tt.mode, tt.precompute).String()
+			if got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestGenerateTasksSharesSubexpressions(t *testing.T) {
+	postfix, err := calculation.InfixToPostfix("(2+3)*(2+3)+(2+3)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tasks, err := calculation.GenerateTasks(postfix)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tasks) != 3 {
+		t.Fatalf("expected 3 tasks, got %d", len(tasks))
+	}
+	sum, product, total := tasks[0], tasks[1], tasks[2]
+	if product.DependsOn[0] != sum.ID || product.DependsOn[1] != sum.ID {
+		t.Errorf("expected product to depend on %s twice, got %v", sum.ID, product.DependsOn)
+	}
+	if total.DependsOn[0] != product.ID || total.DependsOn[1] != sum.ID {
+		t.Errorf("unexpected dependencies %v", total.DependsOn)
+	}
+
+	// Одинаковые подвыражения из разных ветвей условия не объединяются.
+	postfix, err = calculation.InfixToPostfix("if(2>1, (2+3)*2, 2+3) + (2+3)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tasks, err = calculation.GenerateTasks(postfix)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sums := 0
+	for _, task := range tasks {
+		if task.Operation == "+" && task.DependsOn[0] == "" {
+			sums++
+		}
+	}
+	if sums != 3 {
+		t.Errorf("expected 3 separate 2+3 tasks, got %d", sums)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		mode       calculation.Mode
+		expected   []calculation.ParseError
+	}{
+		{"valid", "2*(3+4)", calculation.ModeFloat, nil},
+		{"empty", " ", calculation.ModeFloat, []calculation.ParseError{
+			{Code: calculation.CodeEmptyExpression, Pos: 0, Len: 0, Message: "пустое выражение"},
+		}},
+		{"all invalid characters", "2@3#", calculation.ModeFloat, []calculation.ParseError{
+			{Code: calculation.CodeInvalidCharacter, Pos: 1, Len: 1, Message: "некорректный символ: @"},
+			{Code: calculation.CodeInvalidCharacter, Pos: 3, Len: 1, Message: "некорректный символ: #"},
+		}},
+		{"character and number", "1.2.3+$", calculation.ModeFloat, []calculation.ParseError{
+			{Code: calculation.CodeInvalidNumber, Pos: 3, Len: 1, Message: `некорректное число "1.2.3" в позиции 4: лишняя десятичная точка`},
+			{Code: calculation.CodeInvalidCharacter, Pos: 6, Len: 1, Message: "некорректный символ: $"},
+		}},
+		{"double operator", "2+2**2", calculation.ModeFloat, []calculation.ParseError{
+			{Code: calculation.CodeInvalidOperator, Pos: 4, Len: 1, Message: "некорректный оператор: *"},
+		}},
+		{"missing operand", "1+", calculation.ModeFloat, []calculation.ParseError{
+			{Code: calculation.CodeMissingOperand, Pos: 2, Len: 0, Message: "некорректное выражение: недостаточно операндов"},
+		}},
+		{"unclosed parenthesis", "(1+(2)", calculation.ModeFloat, []calculation.ParseError{
+			{Code: calculation.CodeUnbalancedParentheses, Pos: 0, Len: 1, Message: "некорректное выражение: несогласованные скобки"},
+		}},
+		{"extra operand", "2 3", calculation.ModeFloat, []calculation.ParseError{
+			{Code: calculation.CodeExtraOperand, Pos: 2, Len: 1, Message: "некорректное выражение: лишние операнды"},
+		}},
+		{"argument count", "1+if(1, 2)", calculation.ModeFloat, []calculation.ParseError{
+			{Code: calculation.CodeArgumentCount, Pos: 2, Len: 8, Message: "функция if ожидает аргументов: 3"},
+		}},
+		{"question without colon", "1 ? 2", calculation.ModeFloat, []calculation.ParseError{
+			{Code: calculation.CodeUnmatchedConditional, Pos: 2, Len: 1, Message: "некорректное выражение: '?' без ':'"},
+		}},
+		{"fraction in integer mode", "1.5+2*0.5", calculation.ModeInteger, []calculation.ParseError{
+			{Code: calculation.CodeInvalidValue, Pos: 0, Len: 3, Message: "в режиме integer допустимы только целые числа: 1.5"},
+			{Code: calculation.CodeInvalidValue, Pos: 6, Len: 3, Message: "в режиме integer допустимы только целые числа: 0.5"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := calculation.Check(tt.expression, tt.mode)
+			if len(errs) != len(tt.expected) {
+				t.Fatalf("expected %d errors, got %v", len(tt.expected), errs)
+			}
+			for i, err := range errs {
+				got := calculation.ParseError{Code: err.Code, Pos: err.Pos, Len: err.Len, Message: err.Message}
+				if got != tt.expected[i] {
+					t.Errorf("expected %+v, got %+v", tt.expected[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrorSnippet(t *testing.T) {
+	// Столбец указателя считается в символах, а не в байтах.
+	err := &calculation.ParseError{Pos: 8, Len: 2}
+	if got := err.Snippet("2 + √ * 3"); got != "2 + √ * 3\n      ^^" {
+		t.Errorf("unexpected snippet %q", got)
+	}
+
+	errs := calculation.Check("1+\n2*(3", calculation.ModeFloat)
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if got := errs[0].Snippet("1+\n2*(3"); got != "2*(3\n  ^" {
+		t.Errorf("unexpected snippet %q", got)
+	}
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected calculation.Lang
+	}{
+		{"", ""},
+		{"en", calculation.LangEnglish},
+		{"en-US,en;q=0.9", calculation.LangEnglish},
+		{"de-DE, ru;q=0.5, en;q=0.8", calculation.LangEnglish},
+		{"ru-RU;q=0.9, en;q=0.1", calculation.LangRussian},
+		{"fr, de", ""},
+		{"en;q=0, ru", calculation.LangRussian},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := calculation.AcceptLanguage(tt.header); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	one, _ := calculation.ParseValue(calculation.ModeFloat, "1")
+	zero, _ := calculation.ParseValue(calculation.ModeInteger, "0")
+	_, err := calculation.Apply(calculation.ModeInteger, "/", zero, zero)
+	if err != calculation.ErrDivisionByZero {
+		t.Fatalf("expected division by zero, got %v", err)
+	}
+	if got := calculation.Translate(err, calculation.LangEnglish); got != "division by zero" {
+		t.Errorf("unexpected message %q", got)
+	}
+
+	_, err = calculation.Apply(calculation.ModeFloat, "^", one)
+	if got := calculation.Translate(err, calculation.LangEnglish); got != "operation ^ expects 2 arguments" {
+		t.Errorf("unexpected message %q", got)
+	}
+	if got := calculation.Translate(err, ""); got != "операция ^ ожидает аргументов: 2" {
+		t.Errorf("unexpected message %q", got)
+	}
+
+	_, err = calculation.InfixToPostfix("0b102")
+	if got := calculation.Translate(err, calculation.LangEnglish); got != `invalid number "0b102" at position 5: invalid character '2'` {
+		t.Errorf("unexpected message %q", got)
+	}
+	errs := calculation.Check("2@", calculation.ModeFloat)
+	if got := errs[0].Localize(calculation.LangEnglish); got.Message != "invalid character: @" || got.Pos != 1 {
+		t.Errorf("unexpected error %+v", got)
+	}
+	if errs[0].Message != "некорректный символ: @" {
+		t.Errorf("expected the original error to stay in Russian, got %q", errs[0].Message)
+	}
+
+	err = calculation.NewError("no_such_message")
+	if got := err.Error(); got != "no_such_message" {
+		t.Errorf("expected message id as fallback, got %q", got)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expression  string
+		mode        calculation.Mode
+		expected    string
+		expectedErr string
+	}{
+		{"2+2*2", calculation.ModeFloat, "6", ""},
+		{"(3+5)*(2-1)/4", calculation.ModeFloat, "2", ""},
+		{"sqrt(0-4)", calculation.ModeFloat, "2i", ""},
+		{"1/3+1/6", calculation.ModeRational, "1/2", ""},
+		{"2^70+7/2", calculation.ModeInteger, "1180591620717411303427", ""},
+		{"5!", calculation.ModeInteger, "120", ""},
+		{"if(1<2, 10, 1/0)", calculation.ModeRational, "10", ""},
+		{"0 ? 1/0 : 3", calculation.ModeRational, "3", ""},
+		{"1/(2-2)", calculation.ModeRational, "", "деление на ноль"},
+		{"1.5", calculation.ModeInteger, "", "в режиме integer допустимы только целые числа: 1.5"},
+		{"ln(exp(2))", calculation.ModeFloat, "2", ""},
+		{"im(ln(0-1))", calculation.ModeFloat, "3.141592653589793", ""},
+		{"ln(0)", calculation.ModeFloat, "", "логарифм нуля не определён"},
+		{"ln(1)", calculation.ModeRational, "", "функция ln недоступна в режиме rational"},
+		{"ln(0±1)", calculation.ModeInterval, "", "логарифм отрезка [-1, 1] с неположительной границей не определён"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := calculation.Evaluate(tree, tt.mode)
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Errorf("expected error %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEstimateDuration(t *testing.T) {
+	tests := []struct {
+		expression string
+		workers    int
+		expected   int
+	}{
+		{"(2+3)*(4+5)", 1, 4000},
+		{"(2+3)*(4+5)", 2, 3000},
+		{"(2+3)*(4+5)", 0, 4000},
+		{"1+2+3+4", 4, 3000},
+		{"(2+3)*(2+3)", 2, 3000},
+		{"2", 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			plan, err := calculation.NewPlan(tt.expression, calculation.ModeFloat, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := calculation.EstimateDuration(plan.Tasks, tt.workers); got != tt.expected {
+				t.Errorf("expected %d ms, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		expression string
+		canonical  string
+		latex      string
+		mathML     string
+	}{
+		{"2+3*4", "2 + 3 * 4", `2 + 3 \cdot 4`, "<mrow><mn>2</mn><mo>+</mo><mrow><mn>3</mn><mo>&#x22C5;</mo><mn>4</mn></mrow></mrow>"},
+		{"((2+3))*4", "(2 + 3) * 4", `\left(2 + 3\right) \cdot 4`, "<mrow><mrow><mo>(</mo><mrow><mn>2</mn><mo>+</mo><mn>3</mn></mrow><mo>)</mo></mrow><mo>&#x22C5;</mo><mn>4</mn></mrow>"},
+		{"(1+2)/(3*4)", "(1 + 2) / (3 * 4)", `\frac{1 + 2}{3 \cdot 4}`, "<mfrac><mrow><mn>1</mn><mo>+</mo><mn>2</mn></mrow><mrow><mn>3</mn><mo>&#x22C5;</mo><mn>4</mn></mrow></mfrac>"},
+		{"2*(6/3)", "2 * (6 / 3)", `2 \cdot \frac{6}{3}`, "<mrow><mn>2</mn><mo>&#x22C5;</mo><mfrac><mn>6</mn><mn>3</mn></mfrac></mrow>"},
+		{"(2^3)^(1+1)", "(2 ^ 3) ^ (1 + 1)", `{\left({2}^{3}\right)}^{1 + 1}`, "<msup><mrow><mo>(</mo><msup><mn>2</mn><mn>3</mn></msup><mo>)</mo></mrow><mrow><mn>1</mn><mo>+</mo><mn>1</mn></mrow></msup>"},
+		{"sqrt(abs(3-4i))", "sqrt(abs(3 - 4i))", `\sqrt{\left|3 - 4i\right|}`, "<msqrt><mrow><mo>|</mo><mrow><mn>3</mn><mo>-</mo><mrow><mn>4</mn><mo>&#x2062;</mo><mi>i</mi></mrow></mrow><mo>|</mo></mrow></msqrt>"},
+		{"(2+1)!", "(2 + 1)!", `\left(2 + 1\right)!`, "<mrow><mrow><mo>(</mo><mrow><mn>2</mn><mo>+</mo><mn>1</mn></mrow><mo>)</mo></mrow><mo>!</mo></mrow>"},
+		{"1<=2 and not 3!=4", "1 <= 2 and not 3 != 4", `1 \le 2 \land \lnot 3 \ne 4`, "<mrow><mrow><mn>1</mn><mo>&#x2264;</mo><mn>2</mn></mrow><mo>&#x2227;</mo><mrow><mo>&#xAC;</mo><mrow><mn>3</mn><mo>&#x2260;</mo><mn>4</mn></mrow></mrow></mrow>"},
+		{"x>0 ? 1 : 2", "", "", ""},
+		{"exp(ln(2))", "exp(ln(2))", `\exp\left(\ln\left(2\right)\right)`, "<mrow><mi>exp</mi><mo>&#x2061;</mo><mrow><mo>(</mo><mrow><mi>ln</mi><mo>&#x2061;</mo><mrow><mo>(</mo><mn>2</mn><mo>)</mo></mrow></mrow><mo>)</mo></mrow></mrow>"},
+		{"1>0 ? re(2i) : 2", "if(1 > 0, re(2i), 2)", `\begin{cases}\operatorname{Re}\left(2i\right) & \text{if } 1 > 0 \\ 2 & \text{otherwise}\end{cases}`, "<mrow><mo>{</mo><mtable><mtr><mtd><mrow><mi>Re</mi><mo>&#x2061;</mo><mrow><mo>(</mo><mrow><mn>2</mn><mo>&#x2062;</mo><mi>i</mi></mrow><mo>)</mo></mrow></mrow></mtd><mtd><mtext>if&#xA0;</mtext><mrow><mn>1</mn><mo>&gt;</mo><mn>0</mn></mrow></mtd></mtr><mtr><mtd><mn>2</mn></mtd><mtd><mtext>otherwise</mtext></mtd></mtr></mtable></mrow>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expression)
+			if tt.canonical == "" {
+				if err == nil {
+					t.Errorf("expected error, got %s", tree)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := tree.String(); got != tt.canonical {
+				t.Errorf("expected canonical %s, got %s", tt.canonical, got)
+			}
+			if got := tree.LaTeX(); got != tt.latex {
+				t.Errorf("expected LaTeX %s, got %s", tt.latex, got)
+			}
+			mathML := `<math xmlns="http://www.w3.org/1998/Math/MathML">` + tt.mathML + `</math>`
+			if got := tree.MathML(); got != mathML {
+				t.Errorf("expected MathML %s, got %s", mathML, got)
+			}
+		})
+	}
+}
+
+func TestDerivative(t *testing.T) {
+	tests := []struct {
+		expression  string
+		expected    string
+		expectedErr string
+	}{
+		{"x^3 + 2*x", "3 * x ^ 2 + 2", ""},
+		{"5", "0", ""},
+		{"x*x", "x + x", ""},
+		{"(x+1)/x", "(x - (x + 1)) / x ^ 2", ""},
+		{"sqrt(x)", "1 / (2 * sqrt(x))", ""},
+		{"x > 0 ? x^2 : 3", "if(x > 0, 2 * x, 0)", ""},
+		{"abs(x) + (x < 2)", "x / abs(x)", ""},
+		{"x!", "", "производная операции fact не выражается операциями калькулятора"},
+		{"2^x", "2 ^ x * 0.6931471805599453", ""},
+		{"x^x", "x ^ x * (ln(x) + 1)", ""},
+		{"ln(x^2) + exp(3*x)", "2 * x / x ^ 2 + exp(3 * x) * 3", ""},
+		{"x/x", "0", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			tree, err := calculation.ParseWith(tt.expression, "x")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := calculation.Derivative(tree, "x", calculation.ModeFloat)
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Errorf("expected error %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestVariables(t *testing.T) {
+	if _, err := calculation.Parse("2*x"); err == nil {
+		t.Error("expected error for variable without declaration")
+	}
+	for _, name := range []string{"i", "sqrt", "and", "x1", ""} {
+		if calculation.ValidVariable(name) {
+			t.Errorf("expected %q to be an invalid variable name", name)
+		}
+	}
+
+	plan, err := calculation.NewPlanWith("x^2 + x*y", calculation.ModeFloat, false, map[string]string{"x": "3", "y": "-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := plan.Tree.String(); got != "3 ^ 2 + 3 * (-1)" {
+		t.Errorf("expected substituted tree, got %s", got)
+	}
+	got, err := calculation.Evaluate(plan.Tree, calculation.ModeFloat)
+	if err != nil || got.String() != "6" {
+		t.Errorf("expected 6, got %v (%v)", got, err)
+	}
+	if _, err := calculation.NewPlanWith("x+1", calculation.ModeInteger, false, map[string]string{"x": "0.5"}); err == nil {
+		t.Error("expected error for non-integer value in integer mode")
+	}
+
+	// ссылки на ячейки таблицы подставляются как переменные
+	if cells := calculation.Cells("A1*2 + AB12 - A1 + x"); !reflect.DeepEqual(cells, []string{"A1", "AB12"}) {
+		t.Errorf("expected references A1 and AB12, got %v", cells)
+	}
+	for name, expected := range map[string]bool{"A1": true, "ZZ99": true, "A0": false, "a1": false, "A": false, "A1B": false} {
+		if calculation.IsCell(name) != expected {
+			t.Errorf("IsCell(%q) = %v", name, !expected)
+		}
+	}
+	plan, err = calculation.NewPlanWith("A1*2 + B1", calculation.ModeRational, false, map[string]string{"A1": "1/3", "B1": "1"})
+	if err != nil || plan.Tree.String() != "1/3 * 2 + 1" {
+		t.Errorf("expected substituted cells, got %v (%v)", plan.Tree, err)
+	}
+
+	// ссылки на результаты других выражений
+	if refs := calculation.References("{expr-1} * 2 + ans - {expr-1}"); !reflect.DeepEqual(refs, []string{"{expr-1}", calculation.Answer}) {
+		t.Errorf("expected references {expr-1} and ans, got %v", refs)
+	}
+	for name, expected := range map[string]bool{"{expr-1}": true, "{a_B9}": true, "{}": false, "{a b}": false, "{x": false, "x}": false} {
+		if calculation.IsReference(name) != expected {
+			t.Errorf("IsReference(%q) = %v", name, !expected)
+		}
+	}
+	if errs := calculation.CheckScript("{expr-1} * 2", calculation.ModeFloat); len(errs) == 0 {
+		t.Error("expected error for unknown reference")
+	}
+	if errs := calculation.CheckScript("{expr-1", calculation.ModeFloat, "{expr-1}"); len(errs) == 0 {
+		t.Error("expected error for unclosed reference")
+	}
+	plan, err = calculation.NewPlanWith("{expr-1} * 2 + ans", calculation.ModeRational, false, map[string]string{"{expr-1}": "1/3", calculation.Answer: "1"})
+	if err != nil || plan.Tree.String() != "1/3 * 2 + 1" {
+		t.Errorf("expected substituted references, got %v (%v)", plan.Tree, err)
+	}
+	if tree, err := calculation.ParseWith("{expr_1} * 2", "{expr_1}"); err != nil || tree.LaTeX() != `\{\text{expr\_1}\} \cdot 2` {
+		t.Errorf("unexpected LaTeX for reference: %v (%v)", tree, err)
+	}
+}
+
+func TestScript(t *testing.T) {
+	for text, expected := range map[string]bool{"a = 2; a": true, "x = 5": true, "1 + 2;": false, "2 <= 3": false} {
+		if calculation.IsScript(text) != expected {
+			t.Errorf("IsScript(%q) = %v", text, !expected)
+		}
+	}
+
+	plan, err := calculation.NewPlan("a = 2; b = a * 3; c = (a + b)^2; c", calculation.ModeInteger, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// подвыражения b и c вычисляются одними задачами для всех инструкций
+	if len(plan.Tasks) != 3 || plan.Shared() != 4 {
+		t.Errorf("expected 3 tasks with 4 shared, got %d and %d", len(plan.Tasks), plan.Shared())
+	}
+	var variables []string
+	for _, output := range plan.Outputs {
+		variables = append(variables, output.Variable)
+	}
+	if strings.Join(variables, ",") != "a,b,c," || plan.Outputs[0].Value.String() != "2" || plan.Outputs[1].TaskID == "" {
+		t.Errorf("unexpected outputs %+v", plan.Outputs)
+	}
+	if last := plan.Tasks[len(plan.Tasks)-1]; last.ID != plan.Outputs[3].TaskID || last.Operation != "^" {
+		t.Errorf("expected the final statement to be the last task, got %+v", last)
+	}
+
+	// задача последней инструкции - последняя, даже если она создана раньше других
+	plan, _ = calculation.NewPlan("x = 2*3; y = 4*5; x", calculation.ModeFloat, false)
+	if last := plan.Tasks[len(plan.Tasks)-1]; last.Args[0].String() != "2" {
+		t.Errorf("expected 2 * 3 to be the last task, got %+v", last)
+	}
+
+	script, err := calculation.ParseScript("r = 2; area = r^2 * 3")
+	if err != nil || script.String() != "r = 2; area = r ^ 2 * 3" || script.LaTeX() != `r = 2;\quad area = {r}^{2} \cdot 3` {
+		t.Errorf("unexpected script %q, %q (%v)", script.String(), script.LaTeX(), err)
+	}
+
+	errs := calculation.CheckScript("a = 2; 3x = 1; b = a +", calculation.ModeFloat)
+	if len(errs) != 2 || errs[0].Code != calculation.CodeInvalidAssignment || errs[0].Pos != 7 ||
+		errs[1].Code != calculation.CodeMissingOperand || errs[1].Pos != 22 {
+		t.Errorf("unexpected errors %+v", errs)
+	}
+	if errs := calculation.CheckScript("a = 2; b + 1", calculation.ModeFloat); len(errs) != 1 || errs[0].Message != "некорректный символ: b" {
+		t.Errorf("expected undefined variable b, got %v", errs)
+	}
+}
+
+func TestEquation(t *testing.T) {
+	for _, text := range []string{"2*x", "x == 1", "x = 1 = 2"} {
+		if _, _, pos := calculation.SplitEquation(text); pos >= 0 {
+			t.Errorf("expected %q not to be an equation", text)
+		}
+	}
+	if lhs, rhs, pos := calculation.SplitEquation("x <= 2 = 1"); lhs != "x <= 2 " || rhs != " 1" || pos != 7 {
+		t.Errorf("unexpected split %q %q %d", lhs, rhs, pos)
+	}
+	errs := calculation.CheckEquation("2*x = 3+", calculation.ModeFloat, "x")
+	if len(errs) != 1 || errs[0].Code != calculation.CodeMissingOperand || errs[0].Pos != 8 {
+		t.Errorf("expected missing operand at 8, got %+v", errs)
+	}
+
+	tests := []struct {
+		equation    string
+		mode        calculation.Mode
+		expected    string
+		expectedErr string
+	}{
+		{"2*x + 3 = 11", calculation.ModeFloat, "4", ""},
+		{"x/3 = 1/2", calculation.ModeRational, "3/2", ""},
+		{"3*(x - 1) = x", calculation.ModeInteger, "", "уравнение не имеет целых решений"},
+		{"x = x + 1", calculation.ModeFloat, "", "уравнение не имеет решений"},
+		{"2*x = x + x", calculation.ModeFloat, "", "решением уравнения является любое число"},
+		{"x^2 = 4", calculation.ModeFloat, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.equation, func(t *testing.T) {
+			f, err := calculation.ParseEquation(tt.equation, "x")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			x, linear, err := calculation.SolveLinear(f, "x", tt.mode)
+			switch {
+			case tt.expected == "" && tt.expectedErr == "":
+				if linear {
+					t.Errorf("expected a nonlinear equation, got %v", x)
+				}
+			case tt.expectedErr != "":
+				if !linear || err == nil || err.Error() != tt.expectedErr {
+					t.Errorf("expected error %q, got %v", tt.expectedErr, err)
+				}
+			case !linear || err != nil || x.String() != tt.expected:
+				t.Errorf("expected %s, got %v (%v)", tt.expected, x, err)
+			}
+		})
+	}
+}
+
+func TestArrays(t *testing.T) {
+	tests := []struct {
+		expression  string
+		mode        calculation.Mode
+		expected    string
+		expectedErr string
+	}{
+		{"[1, 2] + [3, 4]", calculation.ModeFloat, "[4, 6]", ""},
+		{"2*[[1, 2], [3, 4]]", calculation.ModeInteger, "[[2, 4], [6, 8]]", ""},
+		{"[1, 2]/2", calculation.ModeRational, "[1/2, 1]", ""},
+		{"dot([1, 2, 3], [4, 5, 6])", calculation.ModeInteger, "32", ""},
+		{"matmul([[1, 2], [3, 4]], [[5, 6], [7, 8]])", calculation.ModeInteger, "[[19, 22], [43, 50]]", ""},
+		{"matmul([[1, 2], [3, 4]], [1, 1])", calculation.ModeFloat, "[3, 7]", ""},
+		{"transpose([[1, 2, 3], [4, 5, 6]])", calculation.ModeFloat, "[[1, 4], [2, 5], [3, 6]]", ""},
+		{"det([[0, 1, 2], [3, 4, 5], [6, 7, 9]])", calculation.ModeInteger, "-3", ""},
+		{"inv([[1, 2], [3, 4]])", calculation.ModeRational, "[[-2, 1], [3/2, -1/2]]", ""},
+		{"inv([[2, 1], [1, 1]])", calculation.ModeInteger, "[[1, -1], [-1, 2]]", ""},
+		{"[1, 2] == [1, 2]", calculation.ModeFloat, "1", ""},
+		{"inv([[1, 2], [3, 4]])", calculation.ModeInteger, "", "обратная матрица не целочисленная"},
+		{"inv([[1, 2], [2, 4]])", calculation.ModeRational, "", "матрица вырождена"},
+		{"det([1, 2])", calculation.ModeFloat, "", "det: матрица 2 не квадратная"},
+		{"[1, 2] + [1, 2, 3]", calculation.ModeFloat, "", "несовместимые размеры операндов +: 2 и 3"},
+		{"[1, [2, 3]]", calculation.ModeFloat, "", "элементы вектора или матрицы должны быть одного вида и размера"},
+		{"[1, 2] < [3, 4]", calculation.ModeFloat, "", "векторы и матрицы нельзя сравнивать на больше/меньше"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := calculation.Evaluate(tree, tt.mode)
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Errorf("expected error %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	tree, err := calculation.Parse("det([[1, 2], [3, 4]]) + transpose([1, 2])")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := tree.String(); got != "det([[1, 2], [3, 4]]) + transpose([1, 2])" {
+		t.Errorf("unexpected canonical form %s", got)
+	}
+	expected := `\det\left(\begin{pmatrix}1 & 2 \\ 3 & 4\end{pmatrix}\right) + {\begin{pmatrix}1 & 2\end{pmatrix}}^{T}`
+	if got := tree.LaTeX(); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if _, err := calculation.Parse("[1, 2)"); err == nil {
+		t.Error("expected an error for mismatched brackets")
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	tests := []struct {
+		expression  string
+		mode        calculation.Mode
+		expected    string
+		expectedErr string
+	}{
+		{"sum(1, 2, 3)", calculation.ModeInteger, "6", ""},
+		{"sum(1..100)", calculation.ModeInteger, "5050", ""},
+		{"sum(1..1000, k -> k^2)", calculation.ModeInteger, "333833500", ""},
+		{"avg(1, 2, 3, 4)", calculation.ModeRational, "5/2", ""},
+		{"median(5, 1, 3, 2)", calculation.ModeRational, "5/2", ""},
+		{"median(1..5, k -> (k - 3)^2)", calculation.ModeFloat, "1", ""},
+		{"stddev(2, 4, 4, 4, 5, 5, 7, 9)", calculation.ModeFloat, "2", ""},
+		{"stddev(2, 4, 4, 4, 5, 5, 7, 9)", calculation.ModeInteger, "2", ""},
+		{"stddev(1..10000, k -> k + 1000000000)", calculation.ModeFloat, "2886.751331514372", ""},
+		{"stddev(1..4, k -> k + 100000000000)", calculation.ModeFloat, "1.118033988749895", ""},
+		{"stddev(1 m, 3 m)", calculation.ModeFloat, "1 m", ""},
+		{"product(1..10)", calculation.ModeInteger, "3628800", ""},
+		{"sum([[1, 2], [3, 4]])", calculation.ModeInteger, "10", ""},
+		{"sum(1..3, k -> sum(1..k, j -> j))", calculation.ModeInteger, "10", ""},
+		{"sum(1..0)", calculation.ModeInteger, "0", ""},
+		{"avg(1..0)", calculation.ModeFloat, "", "функция avg не определена для пустого набора значений"},
+		{"sum(1.5..3)", calculation.ModeFloat, "", "границы диапазона должны быть целыми числами: 1.5..3"},
+		{"sum(1..100000000)", calculation.ModeFloat, "", "диапазон 1..100000000 содержит больше 10000000 значений"},
+		{"sum(1..100, k -> k*3/3)", calculation.ModeInteger, "5050", ""},
+		{"avg(2, 4, 6)", calculation.ModeInteger, "4", ""},
+		{"sum(1..100, k -> k/3)", calculation.ModeInteger, "", "в функции sum деление 1 / 3 даёт остаток 1; в режиме integer деление здесь должно быть точным"},
+		{"avg(1, 2)", calculation.ModeInteger, "", "в функции avg деление 3 / 2 даёт остаток 1; в режиме integer деление здесь должно быть точным"},
+		{"median(1..4)", calculation.ModeInteger, "", "в функции median деление 5 / 2 даёт остаток 1; в режиме integer деление здесь должно быть точным"},
+		{"stddev(1, 2)", calculation.ModeInteger, "", "в функции stddev деление 1 / 4 даёт остаток 1; в режиме integer деление здесь должно быть точным"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			tree, err := calculation.Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := calculation.Evaluate(tree, tt.mode)
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Errorf("expected error %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	for _, text := range []string{"sum(1, 2..3)", "2..3", "sum(1..3, 5)", "k -> k", "sum(1..3, i -> i)"} {
+		errs := calculation.Check(text, calculation.ModeFloat)
+		if len(errs) != 1 || errs[0].Code != calculation.CodeInvalidRange {
+			t.Errorf("expected an invalid range error for %q, got %+v", text, errs)
+		}
+	}
+
+	partial, err := calculation.PartialRange(calculation.ModeInteger, calculation.RangeOp("avg", "k"), "2 * k", calculation.IntValue(big.NewInt(1)), calculation.IntValue(big.NewInt(4)))
+	if err != nil || partial.String() != "[4, 20]" {
+		t.Errorf("expected partial [4, 20], got %v (%v)", partial, err)
+	}
+	// части stddev объединяются без потери точности при большом смещении значений
+	op := calculation.RangeOp("stddev", "k")
+	first, _ := calculation.PartialRange(calculation.ModeFloat, op, "k + 1000000000", calculation.FloatValue(1), calculation.FloatValue(3))
+	second, _ := calculation.PartialRange(calculation.ModeFloat, op, "k + 1000000000", calculation.FloatValue(4), calculation.FloatValue(6))
+	merged, err := calculation.Apply(calculation.ModeFloat, calculation.MergeOp("stddev"), first, second)
+	if err != nil || merged.String() != "[6, 1.0000000035e+09, 17.5]" {
+		t.Errorf("expected merged partial [6, 1.0000000035e+09, 17.5], got %v (%v)", merged, err)
+	}
+	if stddev, err := calculation.Apply(calculation.ModeFloat, calculation.FinishOp("stddev"), merged); err != nil || stddev.String() != "1.707825127659933" {
+		t.Errorf("expected stddev 1.707825127659933, got %v (%v)", stddev, err)
+	}
+	tree, err := calculation.Parse("sum(1..n, k -> k^2)")
+	if err == nil {
+		t.Errorf("expected n to be unknown, got %s", tree)
+	}
+	if tree, err = calculation.ParseWith("sum(1..n, k -> k^2)", "n"); err != nil || tree.LaTeX() != `\sum_{k=1}^{n} {k}^{2}` {
+		t.Errorf("unexpected LaTeX for %v (%v)", tree, err)
+	}
+
+	for text, cost := range map[string]int64{
+		"1 + 2 * 3":                         2,
+		"sum(1..100)":                       101,
+		"sum(1..3, k -> sum(1..k, j -> j))": 16,
+		"sum(1..n, k -> k)":                 1 + 10_000_000,
+		"sum(1..10000000, k -> sum(1..10000000, j -> j))": 1 + 10_000_000*(2+10_000_000),
+	} {
+		tree, err := calculation.ParseWith(text, "n")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := tree.Cost(); got != cost {
+			t.Errorf("expected cost %d for %s, got %d", cost, text, got)
+		}
+	}
+
+	if _, err := calculation.NewPlan("sum(1..100, k -> sum(1..k, j -> j))", calculation.ModeInteger, false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, text := range []string{"sum(1..10000000, k -> sum(1..10000000, j -> j))", "n = 10000000; sum(1..n, k -> sum(1..k, j -> j))"} {
+		_, err := calculation.NewPlan(text, calculation.ModeInteger, false)
+		if err == nil || !strings.HasPrefix(err.Error(), "выражение требует около") {
+			t.Errorf("expected %s to be rejected, got %v", text, err)
+		}
+	}
+}
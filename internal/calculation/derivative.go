@@ -0,0 +1,99 @@
+package calculation
+
+import "slices"
+
+// Derivative возвращает упрощённую производную выражения по переменной variable.
+// Переменные считаются вещественными, сравнения и логические операции - кусочно
+// постоянными. Степень с зависящим от переменной показателем дифференцируется как
+// exp(g*ln(f)). Операции, производная которых не выражается операциями калькулятора
+// (факториал), дают ошибку.
+func Derivative(n *Node, variable string, mode Mode) (*Node, error) {
+	d, err := derive(n, variable)
+	if err != nil {
+		return nil, err
+	}
+	return Simplify(d, mode, true), nil
+}
+
+func op(name string, args ...*Node) *Node {
+	return &Node{Op: name, Args: args}
+}
+
+func derive(n *Node, x string) (*Node, error) {
+	switch {
+	case !n.Depends(x):
+		return Literal("0"), nil
+	case n.IsLiteral():
+		return Literal("1"), nil
+	case n.Op == "if":
+		then, err := derive(n.Args[1], x)
+		if err != nil {
+			return nil, err
+		}
+		otherwise, err := derive(n.Args[2], x)
+		if err != nil {
+			return nil, err
+		}
+		return op("if", n.Args[0], then, otherwise), nil
+	case keywords[n.Op] || slices.Contains(comparisons, n.Op):
+		return Literal("0"), nil
+	case IsRangeOp(n.Op):
+		// сумма и среднее линейны, если от переменной зависят только значения
+		name, variable, _ := ParseRangeOp(n.Op)
+		if variable == "" || name != "sum" && name != "avg" || n.Args[0].Depends(x) || n.Args[1].Depends(x) {
+			return nil, errorf(MsgNotDifferentiable, name)
+		}
+		d, err := derive(n.Args[2], x)
+		if err != nil {
+			return nil, err
+		}
+		return op(n.Op, n.Args[0], n.Args[1], d), nil
+	}
+
+	ds := make([]*Node, len(n.Args))
+	for i, arg := range n.Args {
+		d, err := derive(arg, x)
+		if err != nil {
+			return nil, err
+		}
+		ds[i] = d
+	}
+	if IsArrayOp(n.Op) {
+		return op(n.Op, ds...), nil
+	}
+	a, da := n.Args[0], ds[0]
+	switch n.Op {
+	case "+", "-":
+		return op(n.Op, da, ds[1]), nil
+	case "*":
+		return op("+", op("*", da, n.Args[1]), op("*", a, ds[1])), nil
+	case "/":
+		b := n.Args[1]
+		return op("/", op("-", op("*", da, b), op("*", a, ds[1])), op("^", b, Literal("2"))), nil
+	case "^":
+		b, db := n.Args[1], ds[1]
+		switch {
+		case !b.Depends(x):
+			return op("*", op("*", b, op("^", a, op("-", b, Literal("1")))), da), nil
+		case !a.Depends(x):
+			return op("*", op("*", n, op("ln", a)), db), nil
+		}
+		// (f^g)' = (exp(g*ln(f)))' = f^g * (g'*ln(f) + g*f'/f)
+		return op("*", n, op("+", op("*", db, op("ln", a)), op("/", op("*", b, da), a))), nil
+	case "ln":
+		return op("/", da, a), nil
+	case "exp":
+		return op("*", n, da), nil
+	case "sqrt":
+		return op("/", da, op("*", Literal("2"), n)), nil
+	case "abs":
+		return op("/", op("*", a, da), n), nil
+	case "dot", "matmul":
+		return op("+", op(n.Op, da, n.Args[1]), op(n.Op, a, ds[1])), nil
+	case "re", "im", "conj", "transpose", "sum", "avg":
+		return op(n.Op, da), nil
+	case "arg":
+		return op("im", op("/", da, a)), nil
+	}
+	return nil, errorf(MsgNotDifferentiable, n.Op)
+}
@@ -0,0 +1,93 @@
+package calculation
+
+import "strings"
+
+// Уравнения с одним неизвестным: "левая часть = правая часть". Уравнение сводится
+// к поиску корня функции f = левая часть - (правая часть).
+
+// SplitEquation делит уравнение по единственному знаку "=", не входящему в ==, <=,
+// >= и !=. pos - смещение знака или -1, если такой знак не один.
+func SplitEquation(text string) (lhs, rhs string, pos int) {
+	pos = -1
+	for i := 0; i < len(text); i++ {
+		if text[i] != '=' || i > 0 && strings.IndexByte("<>=!", text[i-1]) >= 0 || i+1 < len(text) && text[i+1] == '=' {
+			continue
+		}
+		if pos >= 0 {
+			return "", "", -1
+		}
+		pos = i
+	}
+	if pos < 0 {
+		return "", "", -1
+	}
+	return text[:pos], text[pos+1:], pos
+}
+
+// CheckEquation - Check для обеих частей уравнения с неизвестным variable. Позиции
+// ошибок отсчитываются от начала уравнения.
+func CheckEquation(text string, mode Mode, variable string) ParseErrors {
+	lhs, rhs, pos := SplitEquation(text)
+	if pos < 0 {
+		return ParseErrors{newParseError(CodeNotEquation, 0, len(text), errorf(MsgNotEquation))}
+	}
+	errs := CheckWith(lhs, mode, variable)
+	for _, err := range CheckWith(rhs, mode, variable) {
+		shifted := *err
+		shifted.Pos += pos + 1
+		errs = append(errs, &shifted)
+	}
+	return errs
+}
+
+// ParseEquation возвращает функцию f, корни которой - решения уравнения.
+func ParseEquation(text, variable string) (*Node, error) {
+	lhs, rhs, pos := SplitEquation(text)
+	if pos < 0 {
+		return nil, newParseError(CodeNotEquation, 0, len(text), errorf(MsgNotEquation))
+	}
+	left, err := ParseWith(lhs, variable)
+	if err != nil {
+		return nil, err
+	}
+	right, err := ParseWith(rhs, variable)
+	if err != nil {
+		return nil, err
+	}
+	return op("-", left, right), nil
+}
+
+// SolveLinear решает уравнение f = 0, если f линейна по variable: f = a*x + b,
+// x = -b / a. linear = false, если f не линейна. Если a = 0, возвращается ошибка:
+// решений нет или подходит любое число. В режиме integer ошибка возвращается и тогда,
+// когда решение не целое.
+func SolveLinear(f *Node, variable string, mode Mode) (x Value, linear bool, err error) {
+	slope, err := Derivative(f, variable, mode)
+	if err != nil || slope.Depends(variable) {
+		return Value{}, false, nil
+	}
+	a, err := Evaluate(slope, mode)
+	if err != nil {
+		return Value{}, true, err
+	}
+	b, err := Evaluate(f.Substitute(map[string]string{variable: "0"}), mode)
+	if err != nil {
+		return Value{}, true, err
+	}
+	if !Truthy(a) {
+		if Truthy(b) {
+			return Value{}, true, errorf(MsgNoSolution)
+		}
+		return Value{}, true, errorf(MsgInfiniteSolutions)
+	}
+	zero, _ := ParseValue(mode, "0")
+	minusB, err := Apply(mode, "-", zero, b)
+	if err != nil {
+		return Value{}, true, err
+	}
+	x, err = Apply(mode, "/", minusB, a)
+	if x.Rem != nil {
+		return Value{}, true, errorf(MsgNoIntegerSolution)
+	}
+	return x, true, err
+}
@@ -0,0 +1,113 @@
+package calculation
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrorCode - машиночитаемый код ошибки разбора.
+type ErrorCode string
+
+const (
+	CodeEmptyExpression       ErrorCode = "empty_expression"
+	CodeInvalidCharacter      ErrorCode = "invalid_character"
+	CodeInvalidNumber         ErrorCode = "invalid_number"
+	CodeInvalidOperator       ErrorCode = "invalid_operator"
+	CodeUnbalancedParentheses ErrorCode = "unbalanced_parentheses"
+	CodeMissingOperand        ErrorCode = "missing_operand"
+	CodeExtraOperand          ErrorCode = "extra_operand"
+	CodeUnknownFunction       ErrorCode = "unknown_function"
+	CodeArgumentCount         ErrorCode = "argument_count"
+	CodeUnmatchedConditional  ErrorCode = "unmatched_conditional"
+	CodeMisplacedComma        ErrorCode = "misplaced_comma"
+	CodeInvalidValue          ErrorCode = "invalid_value"
+	CodeNotEquation           ErrorCode = "not_equation"
+	CodeInvalidRange          ErrorCode = "invalid_range"
+	CodeUnknownUnit           ErrorCode = "unknown_unit"
+	CodeInvalidAssignment     ErrorCode = "invalid_assignment"
+)
+
+// ParseError - ошибка разбора выражения. Pos - смещение в байтах от начала выражения,
+// Len - длина ошибочного фрагмента в байтах (0 - позиция между символами).
+type ParseError struct {
+	Code    ErrorCode `json:"code"`
+	Pos     int       `json:"pos"`
+	Len     int       `json:"len"`
+	Message string    `json:"message"`
+
+	err *Error
+}
+
+func newParseError(code ErrorCode, pos, length int, err *Error) *ParseError {
+	return &ParseError{Code: code, Pos: pos, Len: length, Message: err.Error(), err: err}
+}
+
+func (e *ParseError) Error() string {
+	return e.Message
+}
+
+// Localize возвращает копию ошибки с сообщением на языке lang.
+func (e *ParseError) Localize(lang Lang) *ParseError {
+	localized := *e
+	if e.err != nil {
+		localized.Message = e.err.Localize(lang)
+	}
+	return &localized
+}
+
+// Snippet возвращает строку выражения, содержащую ошибку, и под ней указатель
+// на ошибочный фрагмент:
+//
+//	2+2**2
+//	   ^
+func (e *ParseError) Snippet(expression string) string {
+	pos := min(max(e.Pos, 0), len(expression))
+	start := strings.LastIndexByte(expression[:pos], '\n') + 1
+	end := len(expression)
+	if i := strings.IndexByte(expression[pos:], '\n'); i >= 0 {
+		end = pos + i
+	}
+	length := utf8.RuneCountInString(expression[pos:min(pos+e.Len, end)])
+	return expression[start:end] + "\n" +
+		strings.Repeat(" ", utf8.RuneCountInString(expression[start:pos])) +
+		strings.Repeat("^", max(length, 1))
+}
+
+// ParseErrors - все ошибки, найденные в выражении.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Check проверяет выражение и возвращает все найденные ошибки: лексические ошибки
+// собираются по всему выражению, синтаксическая - первая найденная, затем проверяются
+// числа в заданном режиме.
+func Check(expression string, mode Mode) ParseErrors {
+	return CheckWith(expression, mode)
+}
+
+// CheckWith - Check для выражения, в котором могут встречаться переменные variables.
+func CheckWith(expression string, mode Mode, variables ...string) ParseErrors {
+	tokens, errs := tokenize(expression)
+	if len(errs) > 0 {
+		return errs
+	}
+	if _, err := infixToPostfix(expression, variableSet(variables)); err != nil {
+		return ParseErrors{err.(*ParseError)}
+	}
+	tokens, _ = joinUnits(tokens, variableSet(variables))
+	for _, tok := range tokens {
+		if tok.Kind != TokenNumber && !(tok.Kind == TokenIdent && tok.Text == ImaginaryUnit) {
+			continue
+		}
+		if _, err := ParseValue(mode, tok.Text); err != nil {
+			errs = append(errs, newParseError(CodeInvalidValue, tok.Pos, tok.Len, err.(*Error)))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,81 @@
+package calculation
+
+// Evaluate вычисляет дерево выражения в текущем процессе, без агентов и задержек
+// OperationTime. Ветви условий вычисляются лениво, как и при распределённом
+// вычислении: ошибка в невыбранной ветви не влияет на результат. Остатки
+// целочисленного деления возвращает EvaluateRemainders.
+func Evaluate(n *Node, mode Mode) (Value, error) {
+	return evaluate(n, mode, nil, nil)
+}
+
+// Remainder - ненулевой остаток целочисленного деления в режиме integer.
+type Remainder struct {
+	Dividend  string `json:"dividend"`
+	Divisor   string `json:"divisor"`
+	Quotient  string `json:"quotient"`
+	Remainder string `json:"remainder"`
+}
+
+// EvaluateRemainders вычисляет дерево, как Evaluate, и возвращает остатки
+// целочисленного деления в порядке вычисления. Деление с остатком внутри агрегатной
+// функции - ошибка, см. aggregate.go.
+func EvaluateRemainders(n *Node, mode Mode) (Value, []Remainder, error) {
+	var remainders []Remainder
+	v, err := evaluate(n, mode, nil, &remainders)
+	return v, remainders, err
+}
+
+// evaluate вычисляет дерево; env - значения переменных диапазонов агрегатных функций.
+// Остатки целочисленного деления добавляются в remainders, если он не nil.
+func evaluate(n *Node, mode Mode, env map[string]Value, remainders *[]Remainder) (Value, error) {
+	if n.IsLiteral() {
+		if v, ok := env[n.Token]; ok {
+			return v, nil
+		}
+		return n.Value(mode)
+	}
+	if n.Op == "if" {
+		cond, err := evaluate(n.Args[0], mode, env, remainders)
+		if err != nil {
+			return Value{}, err
+		}
+		if Truthy(cond) {
+			return evaluate(n.Args[1], mode, env, remainders)
+		}
+		return evaluate(n.Args[2], mode, env, remainders)
+	}
+	args := make([]Value, len(n.operands()))
+	for i, arg := range n.operands() {
+		v, err := evaluate(arg, mode, env, remainders)
+		if err != nil {
+			return Value{}, err
+		}
+		args[i] = v
+	}
+	if name, variable, ok := ParseRangeOp(n.Op); ok {
+		a, b, err := RangeBounds(args[0], args[1])
+		if err != nil {
+			return Value{}, err
+		}
+		var body *Node
+		if variable != "" {
+			body = n.Args[2]
+		}
+		partial, err := partialRange(mode, name, variable, body, a, b, env)
+		if err != nil {
+			return Value{}, err
+		}
+		return finishPartial(mode, name, partial)
+	}
+	v, err := Apply(mode, n.Op, args...)
+	if v.Rem != nil && remainders != nil {
+		*remainders = append(*remainders, Remainder{
+			Dividend:  args[0].String(),
+			Divisor:   args[1].String(),
+			Quotient:  v.Int.String(),
+			Remainder: v.Rem.String(),
+		})
+	}
+	v.Rem = nil
+	return v, err
+}
@@ -0,0 +1,402 @@
+package calculation
+
+import (
+	"slices"
+	"strings"
+)
+
+// Запись выражения в LaTeX и MathML. Деление записывается дробью, степень - верхним
+// индексом, поэтому числитель, знаменатель, показатель и аргументы функций в скобках
+// не нуждаются.
+
+var latexOperators = map[string]string{
+	"+": "+", "-": "-", "*": `\cdot`,
+	"<": "<", "<=": `\le`, "==": "=", "!=": `\ne`, ">=": `\ge`, ">": ">",
+	"and": `\land`, "or": `\lor`,
+}
+
+var mathMLOperators = map[string]string{
+	"+": "+", "-": "-", "*": "&#x22C5;",
+	"<": "&lt;", "<=": "&#x2264;", "==": "=", "!=": "&#x2260;", ">=": "&#x2265;", ">": "&gt;",
+	"and": "&#x2227;", "or": "&#x2228;", "not": "&#xAC;",
+}
+
+var functionNames = map[string]string{"re": "Re", "im": "Im", "arg": "arg", "dot": "dot", "matmul": "matmul",
+	"sum": "sum", "avg": "avg", "median": "median", "stddev": "stddev", "product": "product"}
+
+// bigOperators - знаки сумм и произведений по диапазону в LaTeX и MathML.
+var bigOperators = map[string][2]string{"sum": {`\sum`, "&#x2211;"}, "product": {`\prod`, "&#x220F;"}}
+
+// isFraction сообщает, записывается ли узел дробью.
+func (n *Node) isFraction() bool {
+	if n.IsLiteral() {
+		return strings.Contains(n.Token, "/") && !IsQuantity(n.Token)
+	}
+	return n.Op == "/"
+}
+
+// isPlain сообщает, можно ли использовать узел как основание степени или аргумент
+// факториала без скобок.
+func (n *Node) isPlain() bool {
+	if n.IsVariable() {
+		return true
+	}
+	if n.IsLiteral() {
+		return !n.isFraction() && !IsUncertain(n.Token) && !IsQuantity(n.Token) && (n.Token == ImaginaryUnit || !strings.HasSuffix(n.Token, ImaginaryUnit))
+	}
+	return IsFunction(n.Op) && n.Op != "fact" && n.Op != "transpose" && n.Op != "inv" || IsArrayOp(n.Op)
+}
+
+// unitFactor - множитель записи единицы измерения: обозначение, показатель степени
+// и знак * или /, отделяющий его от предыдущего множителя.
+type unitFactor struct {
+	sep, name, exp string
+}
+
+func unitFactors(unit string) []unitFactor {
+	var factors []unitFactor
+	sep := ""
+	for {
+		end := strings.IndexAny(unit, "*/")
+		part := unit
+		if end >= 0 {
+			part = unit[:end]
+		}
+		name, exp, _ := strings.Cut(part, "^")
+		factors = append(factors, unitFactor{sep: sep, name: name, exp: exp})
+		if end < 0 {
+			return factors
+		}
+		sep, unit = unit[end:end+1], unit[end+1:]
+	}
+}
+
+// rows возвращает строки записи вектора или матрицы: у вектора одна строка,
+// у матрицы, записанной векторами, - по строке на вектор.
+func (n *Node) rows() [][]*Node {
+	if !slices.ContainsFunc(n.Args, func(arg *Node) bool { return IsArrayOp(arg.Op) }) {
+		return [][]*Node{n.Args}
+	}
+	var rows [][]*Node
+	for _, arg := range n.Args {
+		if IsArrayOp(arg.Op) {
+			rows = append(rows, arg.rows()...)
+		} else {
+			rows = append(rows, []*Node{arg})
+		}
+	}
+	return rows
+}
+
+// wrapped сообщает, нужны ли скобки вокруг аргумента i инфиксной операции n.
+func (n *Node) wrapped(i int) bool {
+	arg := n.Args[i]
+	if n.Op == "not" {
+		return arg.precedence() < precedence["not"] && !arg.isFraction()
+	}
+	if arg.isFraction() {
+		return false
+	}
+	own := precedence[n.Op]
+	p := arg.precedence()
+	return p < own || p == own && (i == 0) == rightAssoc[n.Op]
+}
+
+// LaTeX возвращает запись выражения в LaTeX.
+func (n *Node) LaTeX() string {
+	var b strings.Builder
+	n.latex(&b)
+	return b.String()
+}
+
+func (n *Node) latex(b *strings.Builder) {
+	arg := func(i int, parens bool) {
+		if parens {
+			b.WriteString(`\left(`)
+		}
+		n.Args[i].latex(b)
+		if parens {
+			b.WriteString(`\right)`)
+		}
+	}
+	switch {
+	case n.IsLiteral() && IsQuantity(n.Token):
+		number, unit, _ := strings.Cut(n.Token, " ")
+		Literal(number).latex(b)
+		b.WriteString(`\,\mathrm{`)
+		for _, f := range unitFactors(unit) {
+			if f.sep == "*" {
+				f.sep = `\cdot `
+			}
+			b.WriteString(f.sep + f.name)
+			if f.exp != "" {
+				b.WriteString("^{" + f.exp + "}")
+			}
+		}
+		b.WriteString("}")
+	case n.IsLiteral():
+		if num, den, ok := strings.Cut(n.Token, "/"); ok {
+			b.WriteString(`\frac{` + num + `}{` + den + `}`)
+		} else if center, radius, ok := strings.Cut(n.Token, PlusMinus); ok {
+			b.WriteString(center + ` \pm ` + radius)
+		} else if IsReference(n.Token) {
+			b.WriteString(`\{\text{` + strings.ReplaceAll(n.Token[1:len(n.Token)-1], "_", `\_`) + `}\}`)
+		} else {
+			b.WriteString(n.Token)
+		}
+	case n.Op == "/":
+		b.WriteString(`\frac{`)
+		arg(0, false)
+		b.WriteString(`}{`)
+		arg(1, false)
+		b.WriteString(`}`)
+	case n.Op == "^":
+		b.WriteString("{")
+		arg(0, !n.Args[0].isPlain())
+		b.WriteString("}^{")
+		arg(1, false)
+		b.WriteString("}")
+	case n.Op == "fact":
+		arg(0, !n.Args[0].isPlain())
+		b.WriteString("!")
+	case n.Op == "not":
+		b.WriteString(`\lnot `)
+		arg(0, n.wrapped(0))
+	case n.Op == "sqrt":
+		b.WriteString(`\sqrt{`)
+		arg(0, false)
+		b.WriteString(`}`)
+	case n.Op == "abs":
+		b.WriteString(`\left|`)
+		arg(0, false)
+		b.WriteString(`\right|`)
+	case n.Op == "conj":
+		b.WriteString(`\overline{`)
+		arg(0, false)
+		b.WriteString(`}`)
+	case IsArrayOp(n.Op):
+		b.WriteString(`\begin{pmatrix}`)
+		for i, row := range n.rows() {
+			if i > 0 {
+				b.WriteString(` \\ `)
+			}
+			for j, elem := range row {
+				if j > 0 {
+					b.WriteString(" & ")
+				}
+				elem.latex(b)
+			}
+		}
+		b.WriteString(`\end{pmatrix}`)
+	case n.Op == "transpose", n.Op == "inv":
+		b.WriteString("{")
+		arg(0, !n.Args[0].isPlain())
+		if n.Op == "inv" {
+			b.WriteString("}^{-1}")
+		} else {
+			b.WriteString("}^{T}")
+		}
+	case n.Op == "det", n.Op == "ln", n.Op == "exp":
+		b.WriteString(`\` + n.Op)
+		arg(0, true)
+	case IsRangeOp(n.Op):
+		name, variable, _ := ParseRangeOp(n.Op)
+		if variable == "" {
+			b.WriteString(`\operatorname{` + name + `}\left(`)
+			arg(0, false)
+			b.WriteString(`, \ldots, `)
+			arg(1, false)
+			b.WriteString(`\right)`)
+			break
+		}
+		if op, ok := bigOperators[name]; ok {
+			b.WriteString(op[0])
+		} else {
+			b.WriteString(`\operatorname*{` + name + `}`)
+		}
+		b.WriteString(`_{` + variable + `=`)
+		arg(0, false)
+		b.WriteString(`}^{`)
+		arg(1, false)
+		b.WriteString(`} `)
+		arg(2, n.Args[2].precedence() < precedence["*"])
+	case n.Op == "if":
+		b.WriteString(`\begin{cases}`)
+		arg(1, false)
+		b.WriteString(` & \text{if } `)
+		arg(0, false)
+		b.WriteString(` \\ `)
+		arg(2, false)
+		b.WriteString(` & \text{otherwise}\end{cases}`)
+	case IsFunction(n.Op):
+		b.WriteString(`\operatorname{` + functionNames[n.Op] + `}\left(`)
+		for i, a := range n.callArgs() {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			a.latex(b)
+		}
+		b.WriteString(`\right)`)
+	default:
+		arg(0, n.wrapped(0))
+		b.WriteString(" " + latexOperators[n.Op] + " ")
+		arg(1, n.wrapped(1))
+	}
+}
+
+// MathML возвращает запись выражения в Presentation MathML.
+func (n *Node) MathML() string {
+	var b strings.Builder
+	b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML">`)
+	n.mathML(&b)
+	b.WriteString(`</math>`)
+	return b.String()
+}
+
+func (n *Node) mathML(b *strings.Builder) {
+	arg := func(i int, parens bool) {
+		if parens {
+			b.WriteString("<mrow><mo>(</mo>")
+		}
+		n.Args[i].mathML(b)
+		if parens {
+			b.WriteString("<mo>)</mo></mrow>")
+		}
+	}
+	switch {
+	case n.IsLiteral() && IsQuantity(n.Token):
+		number, unit, _ := strings.Cut(n.Token, " ")
+		b.WriteString("<mrow>")
+		Literal(number).mathML(b)
+		b.WriteString("<mo>&#x2062;</mo>")
+		for _, f := range unitFactors(unit) {
+			switch f.sep {
+			case "*":
+				b.WriteString("<mo>&#x22C5;</mo>")
+			case "/":
+				b.WriteString("<mo>/</mo>")
+			}
+			name := `<mi mathvariant="normal">` + f.name + "</mi>"
+			if f.exp != "" {
+				name = "<msup>" + name + "<mn>" + f.exp + "</mn></msup>"
+			}
+			b.WriteString(name)
+		}
+		b.WriteString("</mrow>")
+	case n.IsLiteral():
+		num, den, fraction := strings.Cut(n.Token, "/")
+		switch {
+		case fraction:
+			b.WriteString("<mfrac><mn>" + num + "</mn><mn>" + den + "</mn></mfrac>")
+		case IsUncertain(n.Token):
+			center, radius, _ := strings.Cut(n.Token, PlusMinus)
+			b.WriteString("<mrow><mn>" + center + "</mn><mo>&#xB1;</mo><mn>" + radius + "</mn></mrow>")
+		case n.Token == ImaginaryUnit || n.IsVariable():
+			b.WriteString("<mi>" + n.Token + "</mi>")
+		case strings.HasSuffix(n.Token, ImaginaryUnit):
+			b.WriteString("<mrow><mn>" + strings.TrimSuffix(n.Token, ImaginaryUnit) + "</mn><mo>&#x2062;</mo><mi>" + ImaginaryUnit + "</mi></mrow>")
+		default:
+			b.WriteString("<mn>" + n.Token + "</mn>")
+		}
+	case n.Op == "/":
+		b.WriteString("<mfrac>")
+		arg(0, false)
+		arg(1, false)
+		b.WriteString("</mfrac>")
+	case n.Op == "^":
+		b.WriteString("<msup>")
+		arg(0, !n.Args[0].isPlain())
+		arg(1, false)
+		b.WriteString("</msup>")
+	case n.Op == "fact":
+		b.WriteString("<mrow>")
+		arg(0, !n.Args[0].isPlain())
+		b.WriteString("<mo>!</mo></mrow>")
+	case n.Op == "not":
+		b.WriteString("<mrow><mo>" + mathMLOperators["not"] + "</mo>")
+		arg(0, n.wrapped(0))
+		b.WriteString("</mrow>")
+	case n.Op == "sqrt":
+		b.WriteString("<msqrt>")
+		arg(0, false)
+		b.WriteString("</msqrt>")
+	case n.Op == "abs":
+		b.WriteString("<mrow><mo>|</mo>")
+		arg(0, false)
+		b.WriteString("<mo>|</mo></mrow>")
+	case n.Op == "conj":
+		b.WriteString("<mover>")
+		arg(0, false)
+		b.WriteString("<mo>&#xAF;</mo></mover>")
+	case IsArrayOp(n.Op):
+		b.WriteString("<mrow><mo>(</mo><mtable>")
+		for _, row := range n.rows() {
+			b.WriteString("<mtr>")
+			for _, elem := range row {
+				b.WriteString("<mtd>")
+				elem.mathML(b)
+				b.WriteString("</mtd>")
+			}
+			b.WriteString("</mtr>")
+		}
+		b.WriteString("</mtable><mo>)</mo></mrow>")
+	case n.Op == "transpose", n.Op == "inv":
+		b.WriteString("<msup>")
+		arg(0, !n.Args[0].isPlain())
+		if n.Op == "inv" {
+			b.WriteString("<mrow><mo>-</mo><mn>1</mn></mrow></msup>")
+		} else {
+			b.WriteString("<mi>T</mi></msup>")
+		}
+	case n.Op == "det", n.Op == "ln", n.Op == "exp":
+		b.WriteString("<mrow><mi>" + n.Op + "</mi><mo>&#x2061;</mo>")
+		arg(0, true)
+		b.WriteString("</mrow>")
+	case IsRangeOp(n.Op):
+		name, variable, _ := ParseRangeOp(n.Op)
+		if variable == "" {
+			b.WriteString("<mrow><mi>" + name + "</mi><mo>&#x2061;</mo><mrow><mo>(</mo>")
+			arg(0, false)
+			b.WriteString("<mo>,</mo><mo>&#x2026;</mo><mo>,</mo>")
+			arg(1, false)
+			b.WriteString("<mo>)</mo></mrow></mrow>")
+			break
+		}
+		b.WriteString("<mrow><munderover>")
+		if op, ok := bigOperators[name]; ok {
+			b.WriteString("<mo>" + op[1] + "</mo>")
+		} else {
+			b.WriteString("<mi>" + name + "</mi>")
+		}
+		b.WriteString("<mrow><mi>" + variable + "</mi><mo>=</mo>")
+		arg(0, false)
+		b.WriteString("</mrow>")
+		arg(1, false)
+		b.WriteString("</munderover>")
+		arg(2, n.Args[2].precedence() < precedence["*"])
+		b.WriteString("</mrow>")
+	case n.Op == "if":
+		b.WriteString("<mrow><mo>{</mo><mtable><mtr><mtd>")
+		arg(1, false)
+		b.WriteString("</mtd><mtd><mtext>if&#xA0;</mtext>")
+		arg(0, false)
+		b.WriteString("</mtd></mtr><mtr><mtd>")
+		arg(2, false)
+		b.WriteString("</mtd><mtd><mtext>otherwise</mtext></mtd></mtr></mtable></mrow>")
+	case IsFunction(n.Op):
+		b.WriteString("<mrow><mi>" + functionNames[n.Op] + "</mi><mo>&#x2061;</mo><mrow><mo>(</mo>")
+		for i, a := range n.callArgs() {
+			if i > 0 {
+				b.WriteString("<mo>,</mo>")
+			}
+			a.mathML(b)
+		}
+		b.WriteString("<mo>)</mo></mrow></mrow>")
+	default:
+		b.WriteString("<mrow>")
+		arg(0, n.wrapped(0))
+		b.WriteString("<mo>" + mathMLOperators[n.Op] + "</mo>")
+		arg(1, n.wrapped(1))
+		b.WriteString("</mrow>")
+	}
+}
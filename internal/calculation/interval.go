@@ -0,0 +1,303 @@
+package calculation
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Интервальная арифметика. В режиме interval каждое значение - отрезок [Lo, Hi],
+// гарантированно содержащий точный результат. Литерал "2.5±0.1" задаёт отрезок
+// [2.4, 2.6], обычное число - наименьший отрезок из чисел float64, содержащий его
+// точное десятичное значение. Границы результатов операций вычисляются с
+// направленным округлением: нижняя - вниз, верхняя - вверх.
+
+// PlusMinus разделяет значение и погрешность в литерале интервала.
+const PlusMinus = "±"
+
+// Interval - отрезок [Lo, Hi].
+type Interval struct {
+	Lo float64 `json:"lo"`
+	Hi float64 `json:"hi"`
+}
+
+func IntervalValue(lo, hi float64) Value {
+	return Value{Interval: &Interval{Lo: lo, Hi: hi}}
+}
+
+// Midpoint возвращает середину отрезка.
+func (x Interval) Midpoint() float64 {
+	if x.Lo == x.Hi {
+		return x.Lo
+	}
+	return x.Lo/2 + x.Hi/2
+}
+
+func (x Interval) String() string {
+	return "[" + strconv.FormatFloat(x.Lo, 'g', -1, 64) + ", " + strconv.FormatFloat(x.Hi, 'g', -1, 64) + "]"
+}
+
+// IsUncertain сообщает, что запись числа содержит погрешность: "2.5±0.1".
+func IsUncertain(token string) bool {
+	return strings.Contains(token, PlusMinus)
+}
+
+// parseInterval разбирает литерал в режиме interval: число или число с погрешностью.
+func parseInterval(token string) (Value, error) {
+	center, radius, uncertain := strings.Cut(token, PlusMinus)
+	c, ok := new(big.Rat).SetString(center)
+	if !ok {
+		return Value{}, errorf(MsgInvalidNumber, token)
+	}
+	if !uncertain {
+		return IntervalValue(ratBound(c, big.ToNegativeInf), ratBound(c, big.ToPositiveInf)), nil
+	}
+	r, ok := new(big.Rat).SetString(radius)
+	if !ok || r.Sign() < 0 {
+		return Value{}, errorf(MsgInvalidNumber, token)
+	}
+	lo := new(big.Rat).Sub(c, r)
+	hi := new(big.Rat).Add(c, r)
+	return IntervalValue(ratBound(lo, big.ToNegativeInf), ratBound(hi, big.ToPositiveInf)), nil
+}
+
+// ratBound округляет рациональное число до float64 в направлении mode.
+func ratBound(r *big.Rat, mode big.RoundingMode) float64 {
+	return bound(new(big.Float).SetPrec(53).SetMode(mode).SetRat(r), mode)
+}
+
+// bound переводит результат, уже округлённый до 53 бит в направлении mode, в float64.
+// Перевод округляет к ближайшему только денормализованные числа и переполнение;
+// в этих случаях граница сдвигается на одно число float64 наружу.
+func bound(x *big.Float, mode big.RoundingMode) float64 {
+	f, acc := x.Float64()
+	switch {
+	case mode == big.ToNegativeInf && acc == big.Above:
+		return math.Nextafter(f, math.Inf(-1))
+	case mode == big.ToPositiveInf && acc == big.Below:
+		return math.Nextafter(f, math.Inf(1))
+	}
+	return f
+}
+
+// directed выполняет арифметическую операцию над числами float64 с округлением
+// в направлении mode.
+func directed(op string, a, b float64, mode big.RoundingMode) float64 {
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		// бесконечная граница остаётся бесконечной; неопределённость ∞-∞ или 0·∞
+		// расширяет отрезок до бесконечности в направлении округления
+		var f float64
+		switch op {
+		case "+":
+			f = a + b
+		case "-":
+			f = a - b
+		case "*":
+			f = a * b
+		case "/":
+			f = a / b
+		}
+		if math.IsNaN(f) {
+			if mode == big.ToNegativeInf {
+				return math.Inf(-1)
+			}
+			return math.Inf(1)
+		}
+		return f
+	}
+	x, y := big.NewFloat(a), big.NewFloat(b)
+	z := new(big.Float).SetPrec(53).SetMode(mode)
+	switch op {
+	case "+":
+		z.Add(x, y)
+	case "-":
+		z.Sub(x, y)
+	case "*":
+		z.Mul(x, y)
+	case "/":
+		z.Quo(x, y)
+	}
+	return bound(z, mode)
+}
+
+// hull возвращает наименьший отрезок, содержащий результаты op над всеми парами
+// границ a и b; так вычисляются произведение и частное.
+func hull(op string, a, b Interval) Interval {
+	result := Interval{Lo: math.Inf(1), Hi: math.Inf(-1)}
+	for _, x := range []float64{a.Lo, a.Hi} {
+		for _, y := range []float64{b.Lo, b.Hi} {
+			result.Lo = math.Min(result.Lo, directed(op, x, y, big.ToNegativeInf))
+			result.Hi = math.Max(result.Hi, directed(op, x, y, big.ToPositiveInf))
+		}
+	}
+	return result
+}
+
+// power возводит неотрицательное число x в степень n с округлением в направлении mode.
+func power(x float64, n int64, mode big.RoundingMode) float64 {
+	if math.IsInf(x, 0) {
+		return x
+	}
+	result := new(big.Float).SetPrec(53).SetMode(mode).SetInt64(1)
+	base := new(big.Float).SetPrec(53).SetMode(mode).SetFloat64(x)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result.Mul(result, base)
+		}
+		base.Mul(base, base)
+	}
+	return bound(result, mode)
+}
+
+// intervalPower возводит отрезок в целую неотрицательную степень n. Чётная степень
+// отрезка, содержащего ноль, начинается с нуля, а не с отрицательного числа.
+func intervalPower(a Interval, n int64) Interval {
+	if n == 0 {
+		return Interval{Lo: 1, Hi: 1}
+	}
+	// x^n для x < 0: |x|^n при чётном n и -|x|^n при нечётном
+	signed := func(x float64, mode big.RoundingMode) float64 {
+		if x >= 0 {
+			return power(x, n, mode)
+		}
+		if n%2 == 0 {
+			return power(-x, n, mode)
+		}
+		opposite := big.ToPositiveInf
+		if mode == big.ToPositiveInf {
+			opposite = big.ToNegativeInf
+		}
+		return -power(-x, n, opposite)
+	}
+	switch {
+	case n%2 == 1 || a.Lo >= 0:
+		return Interval{Lo: signed(a.Lo, big.ToNegativeInf), Hi: signed(a.Hi, big.ToPositiveInf)}
+	case a.Hi <= 0:
+		return Interval{Lo: signed(a.Hi, big.ToNegativeInf), Hi: signed(a.Lo, big.ToPositiveInf)}
+	}
+	return Interval{Lo: 0, Hi: power(math.Max(-a.Lo, a.Hi), n, big.ToPositiveInf)}
+}
+
+func intervalSqrt(a Interval) (Interval, error) {
+	if a.Lo < 0 {
+		return Interval{}, errorf(MsgIntervalSqrt, a)
+	}
+	root := func(x float64, mode big.RoundingMode) float64 {
+		if math.IsInf(x, 1) {
+			return x
+		}
+		return bound(new(big.Float).SetPrec(53).SetMode(mode).Sqrt(big.NewFloat(x)), mode)
+	}
+	return Interval{Lo: root(a.Lo, big.ToNegativeInf), Hi: root(a.Hi, big.ToPositiveInf)}, nil
+}
+
+// applyInterval выполняет операцию или функцию над отрезками.
+func applyInterval(op string, args []Value) (Value, error) {
+	for _, arg := range args {
+		if arg.Interval == nil {
+			return Value{}, errorf(MsgExpectedInterval)
+		}
+	}
+	a := *args[0].Interval
+	if len(args) == 1 {
+		switch op {
+		case "re", "conj":
+			return IntervalValue(a.Lo, a.Hi), nil
+		case "im":
+			return IntervalValue(0, 0), nil
+		case "abs":
+			switch {
+			case a.Lo >= 0:
+				return IntervalValue(a.Lo, a.Hi), nil
+			case a.Hi <= 0:
+				return IntervalValue(-a.Hi, -a.Lo), nil
+			}
+			return IntervalValue(0, math.Max(-a.Lo, a.Hi)), nil
+		case "sqrt":
+			root, err := intervalSqrt(a)
+			if err != nil {
+				return Value{}, err
+			}
+			return IntervalValue(root.Lo, root.Hi), nil
+		case "ln":
+			if a.Lo <= 0 {
+				return Value{}, errorf(MsgIntervalLog, a)
+			}
+			// math.Log и math.Exp не округляются направленно: границы расширяются на
+			// одно число float64
+			return IntervalValue(math.Nextafter(math.Log(a.Lo), math.Inf(-1)), math.Nextafter(math.Log(a.Hi), math.Inf(1))), nil
+		case "exp":
+			return IntervalValue(math.Max(math.Nextafter(math.Exp(a.Lo), math.Inf(-1)), 0), math.Nextafter(math.Exp(a.Hi), math.Inf(1))), nil
+		}
+		return Value{}, errorf(MsgFunctionUnavailable, op, ModeInterval)
+	}
+	b := *args[1].Interval
+	var result Interval
+	switch op {
+	case "+":
+		result = Interval{Lo: directed("+", a.Lo, b.Lo, big.ToNegativeInf), Hi: directed("+", a.Hi, b.Hi, big.ToPositiveInf)}
+	case "-":
+		result = Interval{Lo: directed("-", a.Lo, b.Hi, big.ToNegativeInf), Hi: directed("-", a.Hi, b.Lo, big.ToPositiveInf)}
+	case "*":
+		result = hull("*", a, b)
+	case "/":
+		if b.Lo <= 0 && b.Hi >= 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		result = hull("/", a, b)
+	case "^":
+		if b.Lo != b.Hi || b.Lo != math.Trunc(b.Lo) || math.IsInf(b.Lo, 0) {
+			return Value{}, errorf(MsgIntervalExponent)
+		}
+		if math.Abs(b.Lo) > maxExponent {
+			return Value{}, errorf(MsgExponentTooLarge, b.Lo)
+		}
+		n := int64(b.Lo)
+		if n < 0 {
+			p := intervalPower(a, -n)
+			if p.Lo <= 0 && p.Hi >= 0 {
+				return Value{}, ErrDivisionByZero
+			}
+			result = hull("/", Interval{Lo: 1, Hi: 1}, p)
+		} else {
+			result = intervalPower(a, n)
+		}
+	default:
+		return Value{}, errorf(MsgUnknownOperation, op)
+	}
+	return IntervalValue(result.Lo, result.Hi), nil
+}
+
+// compareIntervals сравнивает отрезки. Результат определён, только если он одинаков
+// для любых чисел из отрезков; иначе возвращается ошибка.
+func compareIntervals(op string, a, b Interval) (bool, error) {
+	switch op {
+	case "<":
+		if a.Hi < b.Lo {
+			return true, nil
+		}
+		if a.Lo >= b.Hi {
+			return false, nil
+		}
+	case "<=":
+		if a.Hi <= b.Lo {
+			return true, nil
+		}
+		if a.Lo > b.Hi {
+			return false, nil
+		}
+	case ">":
+		return compareIntervals("<", b, a)
+	case ">=":
+		return compareIntervals("<=", b, a)
+	case "==", "!=":
+		if a.Hi < b.Lo || b.Hi < a.Lo {
+			return op == "!=", nil
+		}
+		if a.Lo == a.Hi && a == b {
+			return op == "==", nil
+		}
+	}
+	return false, errorf(MsgIntervalComparison, a, b)
+}
@@ -0,0 +1,534 @@
+package calculation
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Lang - язык сообщений. Пустое значение означает язык каталога по умолчанию.
+type Lang string
+
+const (
+	LangRussian Lang = "ru"
+	LangEnglish Lang = "en"
+)
+
+var languages = []Lang{LangRussian, LangEnglish}
+
+// ParseLang проверяет код языка ("ru", "en-US" и т.п.).
+func ParseLang(s string) (Lang, bool) {
+	base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "-")
+	for _, lang := range languages {
+		if string(lang) == base {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// AcceptLanguage выбирает поддерживаемый язык из заголовка Accept-Language с учётом
+// весов q. Возвращает пустой язык, если подходящего нет.
+func AcceptLanguage(header string) Lang {
+	type candidate struct {
+		lang Lang
+		q    float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		lang, ok := ParseLang(tag)
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{lang, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].lang
+}
+
+// MessageID - идентификатор сообщения в каталоге.
+type MessageID string
+
+// Catalogue - переводы сообщений. Шаблоны используют только %s.
+type Catalogue struct {
+	Default  Lang
+	Messages map[MessageID]map[Lang]string
+}
+
+// Localize форматирует сообщение на языке lang; если перевода нет, используется язык
+// каталога по умолчанию, а если нет и его - сам идентификатор.
+func (c *Catalogue) Localize(lang Lang, id MessageID, args ...string) string {
+	translations := c.Messages[id]
+	format, ok := translations[lang]
+	if !ok {
+		format, ok = translations[c.Default]
+	}
+	if !ok {
+		return string(id)
+	}
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	return fmt.Sprintf(format, values...)
+}
+
+// Error создаёт ошибку с сообщением из каталога.
+func (c *Catalogue) Error(id MessageID, args ...any) *Error {
+	e := &Error{ID: id, catalogue: c}
+	for _, arg := range args {
+		e.Args = append(e.Args, fmt.Sprint(arg))
+	}
+	return e
+}
+
+// Error - ошибка, сообщение которой можно получить на любом языке каталога.
+// Error() возвращает сообщение на языке по умолчанию.
+type Error struct {
+	ID   MessageID `json:"id"`
+	Args []string  `json:"args,omitempty"`
+
+	catalogue *Catalogue
+}
+
+// NewError создаёт ошибку с сообщением из каталога вычислений, например по
+// идентификатору и аргументам, полученным от агента.
+func NewError(id MessageID, args ...string) *Error {
+	return &Error{ID: id, Args: args, catalogue: messages}
+}
+
+func (e *Error) Error() string {
+	return e.Localize("")
+}
+
+func (e *Error) Localize(lang Lang) string {
+	catalogue := e.catalogue
+	if catalogue == nil {
+		catalogue = messages
+	}
+	return catalogue.Localize(lang, e.ID, e.Args...)
+}
+
+// Translate возвращает текст ошибки на языке lang. Ошибки не из каталога
+// возвращаются как есть.
+func Translate(err error, lang Lang) string {
+	switch e := err.(type) {
+	case *Error:
+		return e.Localize(lang)
+	case *ParseError:
+		return e.Localize(lang).Message
+	}
+	return err.Error()
+}
+
+const (
+	MsgEmptyExpression       MessageID = "empty_expression"
+	MsgInvalidCharacter      MessageID = "invalid_character"
+	MsgInvalidOperator       MessageID = "invalid_operator"
+	MsgUnbalancedParentheses MessageID = "unbalanced_parentheses"
+	MsgMissingOperand        MessageID = "missing_operand"
+	MsgExtraOperand          MessageID = "extra_operand"
+	MsgUnknownFunction       MessageID = "unknown_function"
+	MsgArgumentCount         MessageID = "argument_count"
+	MsgQuestionWithoutColon  MessageID = "question_without_colon"
+	MsgColonWithoutQuestion  MessageID = "colon_without_question"
+	MsgMisplacedComma        MessageID = "misplaced_comma"
+
+	MsgNumberNoPrefixDigits MessageID = "number_no_prefix_digits"
+	MsgNumberMalformed      MessageID = "number_malformed"
+	MsgNumberNoExponent     MessageID = "number_no_exponent"
+	MsgNumberExtraDot       MessageID = "number_extra_dot"
+	MsgNumberNoDigits       MessageID = "number_no_digits"
+	MsgNumberSeparator      MessageID = "number_separator"
+	MsgNumberInvalidDigit   MessageID = "number_invalid_digit"
+
+	MsgUnknownMode         MessageID = "unknown_mode"
+	MsgComplexUnavailable  MessageID = "complex_unavailable"
+	MsgInvalidNumber       MessageID = "invalid_number"
+	MsgIntegerOnly         MessageID = "integer_only"
+	MsgDivisionByZero      MessageID = "division_by_zero"
+	MsgUnknownOperation    MessageID = "unknown_operation"
+	MsgOperationArity      MessageID = "operation_arity"
+	MsgComplexOrdering     MessageID = "complex_ordering"
+	MsgExpectedRational    MessageID = "expected_rational"
+	MsgExpectedInteger     MessageID = "expected_integer"
+	MsgRationalExponent    MessageID = "rational_exponent"
+	MsgNegativeExponent    MessageID = "negative_exponent"
+	MsgExponentTooLarge    MessageID = "exponent_too_large"
+	MsgPowerTooLarge       MessageID = "power_too_large"
+	MsgFactorialDomain     MessageID = "factorial_domain"
+	MsgFactorialTooLarge   MessageID = "factorial_too_large"
+	MsgFunctionUnavailable MessageID = "function_unavailable"
+	MsgNegativeIntegerSqrt MessageID = "negative_integer_sqrt"
+	MsgInexactIntegerSqrt  MessageID = "inexact_integer_sqrt"
+	MsgLogZero             MessageID = "log_zero"
+	MsgResultOutOfRange    MessageID = "result_out_of_range"
+	MsgNotDifferentiable   MessageID = "not_differentiable"
+	MsgNotEquation         MessageID = "not_equation"
+	MsgNoSolution          MessageID = "no_solution"
+	MsgInfiniteSolutions   MessageID = "infinite_solutions"
+	MsgNoIntegerSolution   MessageID = "no_integer_solution"
+	MsgRaggedArray         MessageID = "ragged_array"
+	MsgArrayShape          MessageID = "array_shape"
+	MsgArrayOrdering       MessageID = "array_ordering"
+	MsgNotSquare           MessageID = "not_square"
+	MsgSingularMatrix      MessageID = "singular_matrix"
+	MsgInexactInverse      MessageID = "inexact_inverse"
+	MsgMisplacedRange      MessageID = "misplaced_range"
+	MsgMisplacedArrow      MessageID = "misplaced_arrow"
+	MsgRangeVariable       MessageID = "range_variable"
+	MsgRangeArguments      MessageID = "range_arguments"
+	MsgRangeBounds         MessageID = "range_bounds"
+	MsgRangeTooLarge       MessageID = "range_too_large"
+	MsgTooManyIterations   MessageID = "too_many_iterations"
+	MsgAggregateValue      MessageID = "aggregate_value"
+	MsgEmptyAggregate      MessageID = "empty_aggregate"
+	MsgAggregateRemainder  MessageID = "aggregate_remainder"
+	MsgIntervalUnavailable MessageID = "interval_unavailable"
+	MsgExpectedInterval    MessageID = "expected_interval"
+	MsgIntervalExponent    MessageID = "interval_exponent"
+	MsgIntervalSqrt        MessageID = "interval_sqrt"
+	MsgIntervalLog         MessageID = "interval_log"
+	MsgIntervalComparison  MessageID = "interval_comparison"
+	MsgUnknownUnit         MessageID = "unknown_unit"
+	MsgInvalidUnit         MessageID = "invalid_unit"
+	MsgDimensionMismatch   MessageID = "dimension_mismatch"
+	MsgDimensionedExponent MessageID = "dimensioned_exponent"
+	MsgUnitExponent        MessageID = "unit_exponent"
+	MsgUnitSqrt            MessageID = "unit_sqrt"
+	MsgDimensionedArgument MessageID = "dimensioned_argument"
+	MsgUnitMismatch        MessageID = "unit_mismatch"
+	MsgInexactConversion   MessageID = "inexact_conversion"
+	MsgInvalidAssignment   MessageID = "invalid_assignment"
+)
+
+// messages - каталог сообщений пакета calculation.
+var messages = &Catalogue{
+	Default: LangRussian,
+	Messages: map[MessageID]map[Lang]string{
+		MsgEmptyExpression: {
+			LangRussian: "пустое выражение",
+			LangEnglish: "empty expression",
+		},
+		MsgInvalidCharacter: {
+			LangRussian: "некорректный символ: %s",
+			LangEnglish: "invalid character: %s",
+		},
+		MsgInvalidOperator: {
+			LangRussian: "некорректный оператор: %s",
+			LangEnglish: "invalid operator: %s",
+		},
+		MsgUnbalancedParentheses: {
+			LangRussian: "некорректное выражение: несогласованные скобки",
+			LangEnglish: "invalid expression: unbalanced parentheses",
+		},
+		MsgMissingOperand: {
+			LangRussian: "некорректное выражение: недостаточно операндов",
+			LangEnglish: "invalid expression: missing operand",
+		},
+		MsgExtraOperand: {
+			LangRussian: "некорректное выражение: лишние операнды",
+			LangEnglish: "invalid expression: extra operands",
+		},
+		MsgUnknownFunction: {
+			LangRussian: "неизвестная функция: %s",
+			LangEnglish: "unknown function: %s",
+		},
+		MsgArgumentCount: {
+			LangRussian: "функция %s ожидает аргументов: %s",
+			LangEnglish: "function %s expects %s arguments",
+		},
+		MsgQuestionWithoutColon: {
+			LangRussian: "некорректное выражение: '?' без ':'",
+			LangEnglish: "invalid expression: '?' without ':'",
+		},
+		MsgColonWithoutQuestion: {
+			LangRussian: "некорректное выражение: ':' без '?'",
+			LangEnglish: "invalid expression: ':' without '?'",
+		},
+		MsgMisplacedComma: {
+			LangRussian: "некорректное выражение: запятая вне вызова функции",
+			LangEnglish: "invalid expression: comma outside a function call",
+		},
+
+		MsgNumberNoPrefixDigits: {
+			LangRussian: `некорректное число "%s" в позиции %s: нет цифр после префикса %s`,
+			LangEnglish: `invalid number "%s" at position %s: no digits after prefix %s`,
+		},
+		MsgNumberMalformed: {
+			LangRussian: `некорректное число "%s" в позиции %s: некорректная запись числа`,
+			LangEnglish: `invalid number "%s" at position %s: malformed number`,
+		},
+		MsgNumberNoExponent: {
+			LangRussian: `некорректное число "%s" в позиции %s: пропущены цифры экспоненты`,
+			LangEnglish: `invalid number "%s" at position %s: missing exponent digits`,
+		},
+		MsgNumberExtraDot: {
+			LangRussian: `некорректное число "%s" в позиции %s: лишняя десятичная точка`,
+			LangEnglish: `invalid number "%s" at position %s: extra decimal point`,
+		},
+		MsgNumberNoDigits: {
+			LangRussian: `некорректное число "%s" в позиции %s: нет цифр`,
+			LangEnglish: `invalid number "%s" at position %s: no digits`,
+		},
+		MsgNumberSeparator: {
+			LangRussian: `некорректное число "%s" в позиции %s: разделитель _ допустим только между цифрами`,
+			LangEnglish: `invalid number "%s" at position %s: separator _ is only allowed between digits`,
+		},
+		MsgNumberInvalidDigit: {
+			LangRussian: `некорректное число "%s" в позиции %s: недопустимый символ '%s'`,
+			LangEnglish: `invalid number "%s" at position %s: invalid character '%s'`,
+		},
+
+		MsgUnknownMode: {
+			LangRussian: "неизвестный режим вычислений: %s",
+			LangEnglish: "unknown calculation mode: %s",
+		},
+		MsgComplexUnavailable: {
+			LangRussian: "комплексные числа недоступны в режиме %s",
+			LangEnglish: "complex numbers are not available in %s mode",
+		},
+		MsgInvalidNumber: {
+			LangRussian: "некорректное число: %s",
+			LangEnglish: "invalid number: %s",
+		},
+		MsgIntegerOnly: {
+			LangRussian: "в режиме integer допустимы только целые числа: %s",
+			LangEnglish: "only integers are allowed in integer mode: %s",
+		},
+		MsgDivisionByZero: {
+			LangRussian: "деление на ноль",
+			LangEnglish: "division by zero",
+		},
+		MsgUnknownOperation: {
+			LangRussian: "неизвестная операция: %s",
+			LangEnglish: "unknown operation: %s",
+		},
+		MsgOperationArity: {
+			LangRussian: "операция %s ожидает аргументов: %s",
+			LangEnglish: "operation %s expects %s arguments",
+		},
+		MsgComplexOrdering: {
+			LangRussian: "комплексные числа нельзя сравнивать на больше/меньше",
+			LangEnglish: "complex numbers cannot be ordered",
+		},
+		MsgExpectedRational: {
+			LangRussian: "ожидалось рациональное значение",
+			LangEnglish: "a rational value was expected",
+		},
+		MsgExpectedInteger: {
+			LangRussian: "ожидалось целое значение",
+			LangEnglish: "an integer value was expected",
+		},
+		MsgRationalExponent: {
+			LangRussian: "в режиме rational показатель степени должен быть целым",
+			LangEnglish: "the exponent must be an integer in rational mode",
+		},
+		MsgNegativeExponent: {
+			LangRussian: "в режиме integer показатель степени не может быть отрицательным",
+			LangEnglish: "the exponent cannot be negative in integer mode",
+		},
+		MsgExponentTooLarge: {
+			LangRussian: "показатель степени слишком велик: %s",
+			LangEnglish: "the exponent is too large: %s",
+		},
+		MsgPowerTooLarge: {
+			LangRussian: "степень слишком велика: около %s бит, допустимо не больше %s",
+			LangEnglish: "the power is too large: about %s bits, at most %s allowed",
+		},
+		MsgFactorialDomain: {
+			LangRussian: "факториал определён только для неотрицательных целых чисел",
+			LangEnglish: "factorial is only defined for non-negative integers",
+		},
+		MsgFactorialTooLarge: {
+			LangRussian: "аргумент факториала слишком велик: %s",
+			LangEnglish: "the factorial argument is too large: %s",
+		},
+		MsgFunctionUnavailable: {
+			LangRussian: "функция %s недоступна в режиме %s",
+			LangEnglish: "function %s is not available in %s mode",
+		},
+		MsgNegativeIntegerSqrt: {
+			LangRussian: "в режиме integer корень из отрицательного числа не определён",
+			LangEnglish: "the square root of a negative number is undefined in integer mode",
+		},
+		MsgInexactIntegerSqrt: {
+			LangRussian: "корень из %s не является целым числом",
+			LangEnglish: "the square root of %s is not an integer",
+		},
+		MsgLogZero: {
+			LangRussian: "логарифм нуля не определён",
+			LangEnglish: "the logarithm of zero is undefined",
+		},
+		MsgResultOutOfRange: {
+			LangRussian: "результат выходит за пределы диапазона float64",
+			LangEnglish: "the result is out of the float64 range",
+		},
+		MsgNotDifferentiable: {
+			LangRussian: "производная операции %s не выражается операциями калькулятора",
+			LangEnglish: "the derivative of %s cannot be expressed with the calculator operations",
+		},
+		MsgNotEquation: {
+			LangRussian: "ожидается уравнение с одним знаком =: левая часть = правая часть",
+			LangEnglish: "expected an equation with a single = sign: left side = right side",
+		},
+		MsgNoSolution: {
+			LangRussian: "уравнение не имеет решений",
+			LangEnglish: "the equation has no solutions",
+		},
+		MsgInfiniteSolutions: {
+			LangRussian: "решением уравнения является любое число",
+			LangEnglish: "any number is a solution of the equation",
+		},
+		MsgNoIntegerSolution: {
+			LangRussian: "уравнение не имеет целых решений",
+			LangEnglish: "the equation has no integer solutions",
+		},
+		MsgRaggedArray: {
+			LangRussian: "элементы вектора или матрицы должны быть одного вида и размера",
+			LangEnglish: "elements of a vector or matrix must have the same kind and size",
+		},
+		MsgArrayShape: {
+			LangRussian: "несовместимые размеры операндов %s: %s и %s",
+			LangEnglish: "incompatible operand sizes for %s: %s and %s",
+		},
+		MsgArrayOrdering: {
+			LangRussian: "векторы и матрицы нельзя сравнивать на больше/меньше",
+			LangEnglish: "vectors and matrices cannot be ordered",
+		},
+		MsgNotSquare: {
+			LangRussian: "%s: матрица %s не квадратная",
+			LangEnglish: "%s: matrix %s is not square",
+		},
+		MsgSingularMatrix: {
+			LangRussian: "матрица вырождена",
+			LangEnglish: "the matrix is singular",
+		},
+		MsgInexactInverse: {
+			LangRussian: "обратная матрица не целочисленная",
+			LangEnglish: "the inverse matrix is not an integer matrix",
+		},
+		MsgMisplacedRange: {
+			LangRussian: "диапазон a..b допустим только первым аргументом sum, avg, median, stddev и product",
+			LangEnglish: "a range a..b is only allowed as the first argument of sum, avg, median, stddev and product",
+		},
+		MsgMisplacedArrow: {
+			LangRussian: "выражение вида k -> ... допустимо только вторым аргументом после диапазона",
+			LangEnglish: "an expression k -> ... is only allowed as the second argument after a range",
+		},
+		MsgRangeVariable: {
+			LangRussian: "некорректное имя переменной диапазона: %s",
+			LangEnglish: "invalid range variable name: %s",
+		},
+		MsgRangeArguments: {
+			LangRussian: "%s: после диапазона ожидается выражение вида k -> ...",
+			LangEnglish: "%s: an expression k -> ... is expected after the range",
+		},
+		MsgRangeBounds: {
+			LangRussian: "границы диапазона должны быть целыми числами: %s..%s",
+			LangEnglish: "range bounds must be integers: %s..%s",
+		},
+		MsgRangeTooLarge: {
+			LangRussian: "диапазон %s..%s содержит больше %s значений",
+			LangEnglish: "the range %s..%s has more than %s values",
+		},
+		MsgTooManyIterations: {
+			LangRussian: "выражение требует около %s операций с учётом вложенных диапазонов, допустимо не больше %s",
+			LangEnglish: "the expression needs about %s operations including nested ranges, at most %s are allowed",
+		},
+		MsgAggregateValue: {
+			LangRussian: "значения функции %s должны быть числами",
+			LangEnglish: "values of %s must be numbers",
+		},
+		MsgEmptyAggregate: {
+			LangRussian: "функция %s не определена для пустого набора значений",
+			LangEnglish: "%s is undefined for an empty set of values",
+		},
+		MsgAggregateRemainder: {
+			LangRussian: "в функции %s деление %s / %s даёт остаток %s; в режиме integer деление здесь должно быть точным",
+			LangEnglish: "in %s, %s / %s leaves a remainder of %s; division there must be exact in integer mode",
+		},
+		MsgIntervalUnavailable: {
+			LangRussian: "числа с погрешностью недоступны в режиме %s",
+			LangEnglish: "numbers with uncertainty are not available in %s mode",
+		},
+		MsgExpectedInterval: {
+			LangRussian: "ожидался отрезок",
+			LangEnglish: "an interval was expected",
+		},
+		MsgIntervalExponent: {
+			LangRussian: "в режиме interval показатель степени должен быть целым",
+			LangEnglish: "the exponent must be an integer in interval mode",
+		},
+		MsgIntervalSqrt: {
+			LangRussian: "корень из отрезка %s с отрицательной границей не определён",
+			LangEnglish: "the square root of the interval %s with a negative bound is undefined",
+		},
+		MsgIntervalLog: {
+			LangRussian: "логарифм отрезка %s с неположительной границей не определён",
+			LangEnglish: "the logarithm of the interval %s with a non-positive bound is undefined",
+		},
+		MsgIntervalComparison: {
+			LangRussian: "результат сравнения отрезков %s и %s не определён: они пересекаются",
+			LangEnglish: "the comparison of intervals %s and %s is undetermined: they overlap",
+		},
+		MsgUnknownUnit: {
+			LangRussian: "неизвестная единица измерения: %s",
+			LangEnglish: "unknown unit: %s",
+		},
+		MsgInvalidUnit: {
+			LangRussian: "некорректная запись единицы измерения: %s",
+			LangEnglish: "invalid unit: %s",
+		},
+		MsgDimensionMismatch: {
+			LangRussian: "несовместимые размерности: %s и %s",
+			LangEnglish: "incompatible dimensions: %s and %s",
+		},
+		MsgDimensionedExponent: {
+			LangRussian: "показатель степени должен быть безразмерным, а не %s",
+			LangEnglish: "the exponent must be dimensionless, not %s",
+		},
+		MsgUnitExponent: {
+			LangRussian: "величину размерности %s можно возвести только в целую степень",
+			LangEnglish: "a quantity of dimension %s can only be raised to an integer power",
+		},
+		MsgUnitSqrt: {
+			LangRussian: "корень из величины размерности %s не определён: показатели степеней должны быть чётными",
+			LangEnglish: "the square root of a quantity of dimension %s is undefined: the exponents must be even",
+		},
+		MsgDimensionedArgument: {
+			LangRussian: "аргумент функции %s должен быть безразмерным, а не %s",
+			LangEnglish: "the argument of %s must be dimensionless, not %s",
+		},
+		MsgUnitMismatch: {
+			LangRussian: "величину размерности %s нельзя перевести в %s",
+			LangEnglish: "a quantity of dimension %s cannot be converted to %s",
+		},
+		MsgInexactConversion: {
+			LangRussian: "%s нельзя точно перевести в %s в режиме integer",
+			LangEnglish: "%s cannot be converted to %s exactly in integer mode",
+		},
+		MsgInvalidAssignment: {
+			LangRussian: "недопустимое имя переменной в присваивании: %s",
+			LangEnglish: "invalid variable name in assignment: %s",
+		},
+	},
+}
+
+// errorf создаёт ошибку из каталога пакета calculation.
+func errorf(id MessageID, args ...any) *Error {
+	return messages.Error(id, args...)
+}
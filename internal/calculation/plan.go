@@ -0,0 +1,191 @@
+package calculation
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// Plan - выражение, подготовленное к распределённому вычислению.
+type Plan struct {
+	Tree       *Node
+	Simplified *Node
+	Postfix    []string
+	Tasks      []*Task
+	// Outputs - значения инструкций сценария (см. script.go); у выражения пуст.
+	// Tree и Simplified сценария - деревья последней инструкции.
+	Outputs []Output
+
+	// operations и simplified - число операций до и после упрощения.
+	operations, simplified int
+}
+
+// Output - значение инструкции сценария: результат задачи TaskID или, если задачи
+// нет, число Value.
+type Output struct {
+	Variable string
+	TaskID   string
+	Value    Value
+}
+
+// NewPlan разбирает и упрощает выражение и создаёт задачи для агентов. Для выражения
+// из одного числа задач нет, его значение - LiteralValue(plan.Postfix, mode).
+func NewPlan(expression string, mode Mode, precompute bool) (*Plan, error) {
+	return NewPlanWith(expression, mode, precompute, nil)
+}
+
+// NewPlanWith - NewPlan для выражения с переменными: каждая переменная заменяется
+// числом из variables.
+func NewPlanWith(expression string, mode Mode, precompute bool, variables map[string]string) (*Plan, error) {
+	return NewPlanValues(expression, mode, precompute, variables, nil)
+}
+
+// NewPlanValues - NewPlanWith, в котором переменные из values заменяются значениями,
+// например результатами других выражений (см. Constant). Переменная из values
+// заменяет одноимённую из variables.
+func NewPlanValues(expression string, mode Mode, precompute bool, variables map[string]string, values map[string]Value) (*Plan, error) {
+	bindings := make(map[string]*Node, len(variables)+len(values))
+	for name, value := range variables {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		if _, err := ParseValue(mode, value); err != nil {
+			return nil, err
+		}
+		bindings[name] = Literal(value)
+	}
+	for name, value := range values {
+		n, err := Constant(value, mode)
+		if err != nil {
+			return nil, err
+		}
+		bindings[name] = n
+	}
+	names := slices.Collect(maps.Keys(bindings))
+	if IsScript(expression) {
+		return newScriptPlan(expression, mode, precompute, bindings, names)
+	}
+	tree, err := ParseWith(expression, names...)
+	if err != nil {
+		return nil, err
+	}
+	tree = tree.Bind(bindings)
+	p := &Plan{Tree: tree, Simplified: Simplify(tree, mode, precompute)}
+	if err := checkCost(p.Simplified); err != nil {
+		return nil, err
+	}
+	p.Postfix = p.Simplified.Postfix()
+	p.operations, p.simplified = p.Tree.TaskCount(), p.Simplified.TaskCount()
+	if p.Tasks, err = GenerateTasksMode(p.Postfix, mode); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// newScriptPlan создаёт задачи всех инструкций сценария в одном графе: инструкция,
+// использующая присвоенную выше переменную, зависит от задач её выражения. Задача
+// последней инструкции - последняя в плане.
+func newScriptPlan(text string, mode Mode, precompute bool, bindings map[string]*Node, names []string) (*Plan, error) {
+	script, err := ParseScript(text, names...)
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{}
+	g := newTaskGraph(mode)
+	for i, tree := range script.Bound(bindings) {
+		simplified := Simplify(tree, mode, precompute)
+		if err := checkCost(simplified); err != nil {
+			return nil, err
+		}
+		result, err := g.generate(simplified, "")
+		if err != nil {
+			return nil, err
+		}
+		p.Tree, p.Simplified = tree, simplified
+		p.operations += tree.TaskCount()
+		p.simplified += simplified.TaskCount()
+		p.Outputs = append(p.Outputs, Output{Variable: script[i].Variable, TaskID: result.taskID, Value: result.value})
+	}
+	p.Postfix = p.Simplified.Postfix()
+	p.Tasks = g.tasks
+	if final := p.Outputs[len(p.Outputs)-1].TaskID; final != "" {
+		i := slices.IndexFunc(p.Tasks, func(task *Task) bool { return task.ID == final })
+		p.Tasks = append(slices.Delete(p.Tasks, i, i+1), g.byID[final])
+	}
+	return p, nil
+}
+
+// checkCost отклоняет выражение, вычисление которого займёт больше maxCost операций.
+// Диапазон верхнего уровня делится между агентами по частям, но вложенный диапазон
+// вычисляется целиком в задаче внешнего, и без этого ограничения одна задача агента
+// могла бы перебирать 10^14 значений.
+func checkCost(n *Node) error {
+	if cost := n.Cost(); cost > maxCost {
+		return errorf(MsgTooManyIterations, strconv.FormatInt(cost, 10), strconv.Itoa(maxCost))
+	}
+	return nil
+}
+
+// Eliminated возвращает число операций, убранных упрощением.
+func (p *Plan) Eliminated() int {
+	return p.operations - p.simplified
+}
+
+// Shared возвращает число операций, сэкономленных объединением одинаковых подвыражений.
+func (p *Plan) Shared() int {
+	return p.simplified - len(p.Tasks)
+}
+
+// EstimateDuration оценивает время выполнения задач в миллисекундах, если их
+// выполняют workers агентов и ни одна ветвь условий не отброшена. Готовые задачи
+// достаются первому освободившемуся агенту; задачи оркестратора выполняются мгновенно.
+func EstimateDuration(tasks []*Task, workers int) int {
+	workers = max(workers, 1)
+	free := make([]int, workers)
+	finish := make(map[string]int, len(tasks))
+	scheduled := make([]bool, len(tasks))
+	total := 0
+	for range tasks {
+		next, nextReady := -1, 0
+		for i, task := range tasks {
+			if scheduled[i] {
+				continue
+			}
+			readyAt, ready := 0, true
+			for _, dep := range task.DependsOn {
+				if dep == "" {
+					continue
+				}
+				done, ok := finish[dep]
+				if !ok {
+					ready = false
+					break
+				}
+				readyAt = max(readyAt, done)
+			}
+			if ready && (next < 0 || readyAt < nextReady) {
+				next, nextReady = i, readyAt
+			}
+		}
+		if next < 0 {
+			break
+		}
+		scheduled[next] = true
+		task := tasks[next]
+		if task.Local {
+			finish[task.ID] = nextReady
+			continue
+		}
+		worker := 0
+		for w := range free {
+			if free[w] < free[worker] {
+				worker = w
+			}
+		}
+		start := max(nextReady, free[worker])
+		free[worker] = start + task.OperationTime
+		finish[task.ID] = free[worker]
+		total = max(total, free[worker])
+	}
+	return total
+}
@@ -0,0 +1,187 @@
+package calculation
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Сценарии - несколько инструкций, разделённых ";": "a = 2; b = a * 3; c = (a + b)^2; c". Инструкция - присваивание "имя = выражение"
+// или выражение; значение сценария - значение последней инструкции. Переменные,
+// присвоенные выше, заменяются деревьями их выражений, поэтому все инструкции
+// вычисляются одним графом задач, а общие подвыражения - один раз.
+
+// Statement - инструкция сценария. Pos - смещение выражения инструкции в байтах от
+// начала сценария.
+type Statement struct {
+	Variable string `json:"variable,omitempty"`
+	Tree     *Node  `json:"tree"`
+	Pos      int    `json:"pos"`
+}
+
+type Script []Statement
+
+// statementSource - текст инструкции сценария до разбора.
+type statementSource struct {
+	variable string
+	expr     string
+	pos      int
+	// namePos и nameLen - положение имени переменной присваивания.
+	namePos, nameLen int
+}
+
+// IsScript сообщает, что текст - сценарий: в нём несколько инструкций или присваивание.
+func IsScript(text string) bool {
+	if strings.Contains(strings.TrimRight(text, "; \t\r\n"), ";") {
+		return true
+	}
+	_, _, pos := SplitEquation(text)
+	return pos >= 0
+}
+
+// splitScript делит сценарий на инструкции; пустые инструкции пропускаются.
+func splitScript(text string) []statementSource {
+	var sources []statementSource
+	start := 0
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) && text[i] != ';' {
+			continue
+		}
+		statement := text[start:i]
+		if strings.TrimSpace(statement) != "" {
+			source := statementSource{expr: statement, pos: start}
+			if lhs, rhs, pos := SplitEquation(statement); pos >= 0 {
+				name := strings.TrimSpace(lhs)
+				source.variable, source.expr, source.pos = name, rhs, start+pos+1
+				source.namePos = start + strings.Index(lhs, name)
+				source.nameLen = len(name)
+			}
+			sources = append(sources, source)
+		}
+		start = i + 1
+	}
+	return sources
+}
+
+// check проверяет имя переменной присваивания.
+func (s statementSource) check() *ParseError {
+	if s.variable != "" && !ValidVariable(s.variable) {
+		return newParseError(CodeInvalidAssignment, s.namePos, max(s.nameLen, 1), errorf(MsgInvalidAssignment, s.variable))
+	}
+	return nil
+}
+
+// CheckScript - Check для сценария: проверяет каждую инструкцию, в которой могут
+// встречаться переменные variables и переменные, присвоенные выше. Позиции ошибок
+// отсчитываются от начала сценария. Выражение без присваиваний - сценарий из одной
+// инструкции.
+func CheckScript(text string, mode Mode, variables ...string) ParseErrors {
+	sources := splitScript(text)
+	if len(sources) == 0 {
+		return ParseErrors{newParseError(CodeEmptyExpression, 0, 0, errorf(MsgEmptyExpression))}
+	}
+	known := variableSet(variables)
+	var errs ParseErrors
+	for _, source := range sources {
+		if err := source.check(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, err := range CheckWith(source.expr, mode, slices.Collect(maps.Keys(known))...) {
+			shifted := *err
+			shifted.Pos += source.pos
+			errs = append(errs, &shifted)
+		}
+		if source.variable != "" {
+			known[source.variable] = true
+		}
+	}
+	return errs
+}
+
+// ParseScript разбирает сценарий, в котором могут встречаться переменные variables.
+func ParseScript(text string, variables ...string) (Script, error) {
+	sources := splitScript(text)
+	if len(sources) == 0 {
+		return nil, newParseError(CodeEmptyExpression, 0, 0, errorf(MsgEmptyExpression))
+	}
+	known := variableSet(variables)
+	script := make(Script, 0, len(sources))
+	for _, source := range sources {
+		if err := source.check(); err != nil {
+			return nil, err
+		}
+		tree, err := ParseWith(source.expr, slices.Collect(maps.Keys(known))...)
+		if err != nil {
+			if parseErr, ok := err.(*ParseError); ok {
+				shifted := *parseErr
+				shifted.Pos += source.pos
+				return nil, &shifted
+			}
+			return nil, err
+		}
+		script = append(script, Statement{Variable: source.variable, Tree: tree, Pos: source.pos})
+		if source.variable != "" {
+			known[source.variable] = true
+		}
+	}
+	return script, nil
+}
+
+// Bound возвращает деревья инструкций, в которых присвоенные выше переменные
+// заменены деревьями их выражений, а переменные из values - деревьями values.
+func (s Script) Bound(values map[string]*Node) []*Node {
+	bindings := maps.Clone(values)
+	if bindings == nil {
+		bindings = make(map[string]*Node, len(s))
+	}
+	trees := make([]*Node, len(s))
+	for i, statement := range s {
+		trees[i] = statement.Tree.Bind(bindings)
+		if statement.Variable != "" {
+			bindings[statement.Variable] = trees[i]
+		}
+	}
+	return trees
+}
+
+// String возвращает запись сценария, в которой инструкции разделены "; ".
+func (s Script) String() string {
+	parts := make([]string, len(s))
+	for i, statement := range s {
+		parts[i] = statement.Tree.String()
+		if statement.Variable != "" {
+			parts[i] = statement.Variable + " = " + parts[i]
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// LaTeX возвращает запись сценария в LaTeX.
+func (s Script) LaTeX() string {
+	parts := make([]string, len(s))
+	for i, statement := range s {
+		parts[i] = statement.Tree.LaTeX()
+		if statement.Variable != "" {
+			parts[i] = statement.Variable + " = " + parts[i]
+		}
+	}
+	return strings.Join(parts, `;\quad `)
+}
+
+// MathML возвращает запись сценария в Presentation MathML.
+func (s Script) MathML() string {
+	var b strings.Builder
+	b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow>`)
+	for i, statement := range s {
+		if i > 0 {
+			b.WriteString("<mo>;</mo>")
+		}
+		if statement.Variable != "" {
+			b.WriteString("<mi>" + statement.Variable + "</mi><mo>=</mo>")
+		}
+		statement.Tree.mathML(&b)
+	}
+	b.WriteString(`</mrow></math>`)
+	return b.String()
+}
@@ -0,0 +1,199 @@
+package calculation
+
+import (
+	"math"
+	"strconv"
+)
+
+// safeOperations - операции, которые не могут завершиться ошибкой или дать
+// бесконечность/NaN на конечных безразмерных числах; только такие подвыражения можно
+// отбросить при упрощении x*0 и x^0.
+var safeOperations = map[string]bool{
+	"+": true, "-": true, "*": true,
+	"<": true, "<=": true, "==": true, "!=": true, ">=": true, ">": true,
+	"and": true, "or": true, "not": true,
+	"re": true, "im": true, "conj": true, "abs": true,
+}
+
+// Simplify упрощает дерево перед генерацией задач: убирает тождественные операции
+// (x*1, x/1, x^1, x+0 и x-0 для безразмерных x, x*0, x^0 и x-x для безопасных x,
+// 0/x и x/x для ненулевых x, см. nonZero) и условия с известным условием. Если precompute установлен, операции над одними
+// литералами вычисляются сразу. Исходное дерево не изменяется.
+//
+// Переменные при упрощении - переменные диапазонов и неизвестные производной и
+// уравнения, то есть вещественные числа: значения переменных выражения, которые
+// могут быть величинами или массивами, подставляются до упрощения.
+func Simplify(n *Node, mode Mode, precompute bool) *Node {
+	if n.IsLiteral() {
+		return n
+	}
+	args := make([]*Node, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = Simplify(arg, mode, precompute)
+	}
+	node := &Node{Op: n.Op, Args: args}
+
+	if node.Op == "if" {
+		if cond, err := args[0].Value(mode); args[0].IsLiteral() && err == nil {
+			if Truthy(cond) {
+				return args[1]
+			}
+			return args[2]
+		}
+		return node
+	}
+
+	if len(args) == 2 {
+		a, b := args[0], args[1]
+		switch node.Op {
+		case "+":
+			if isConstant(b, mode, 0) && dimensionless(a) {
+				return a
+			}
+			if isConstant(a, mode, 0) && dimensionless(b) {
+				return b
+			}
+		case "-":
+			if isConstant(b, mode, 0) && dimensionless(a) {
+				return a
+			}
+			if a.String() == b.String() && isSafe(a, mode) {
+				return Literal("0")
+			}
+		case "*":
+			if isConstant(b, mode, 1) {
+				return a
+			}
+			if isConstant(a, mode, 1) {
+				return b
+			}
+			if isConstant(b, mode, 0) && isSafe(a, mode) {
+				return b
+			}
+			if isConstant(a, mode, 0) && isSafe(b, mode) {
+				return a
+			}
+		case "/":
+			if isConstant(b, mode, 1) {
+				return a
+			}
+			if isConstant(a, mode, 0) && nonZero(b, mode) {
+				return a
+			}
+			if a.String() == b.String() && nonZero(a, mode) {
+				return Literal("1")
+			}
+		case "^":
+			if isConstant(b, mode, 1) {
+				return a
+			}
+			if isConstant(b, mode, 0) && isSafe(a, mode) {
+				return Literal("1")
+			}
+		}
+	}
+
+	if precompute {
+		if folded := fold(node, mode); folded != nil {
+			return folded
+		}
+	}
+	return node
+}
+
+func isConstant(n *Node, mode Mode, k int64) bool {
+	if !n.IsLiteral() {
+		return false
+	}
+	v, err := n.Value(mode)
+	if err != nil || v.IsComplex() {
+		return false
+	}
+	constant, err := ParseValue(mode, strconv.FormatInt(k, 10))
+	if err != nil {
+		return false
+	}
+	equal, err := compare("==", v, constant)
+	return err == nil && equal
+}
+
+func isSafe(n *Node, mode Mode) bool {
+	if n.IsVariable() {
+		return true
+	}
+	if n.IsLiteral() {
+		v, err := n.Value(mode)
+		return err == nil && v.Dim == nil && !v.IsArray() && !math.IsInf(v.Approx(), 0) && !math.IsNaN(v.Approx())
+	}
+	if !safeOperations[n.Op] {
+		return false
+	}
+	for _, arg := range n.Args {
+		if !isSafe(arg, mode) {
+			return false
+		}
+	}
+	return true
+}
+
+// nonZero сообщает, что делитель можно считать ненулевым безразмерным числом: это
+// литерал, отличный от нуля, или безразмерное выражение от переменных. Как и при
+// упрощении x^0, переменные считаются принимающими значения, при которых выражение
+// определено; выражение из одних литералов может оказаться нулём и не отбрасывается.
+func nonZero(n *Node, mode Mode) bool {
+	if n.IsLiteral() && !n.IsVariable() {
+		return isSafe(n, mode) && !isConstant(n, mode, 0)
+	}
+	return dimensionless(n) && hasVariable(n)
+}
+
+func hasVariable(n *Node) bool {
+	if n.IsLiteral() {
+		return n.IsVariable()
+	}
+	for _, arg := range n.Args {
+		if hasVariable(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// dimensionless сообщает, что в дереве нет величин с единицами измерения: сложение
+// такого дерева с нулём не может завершиться ошибкой размерности.
+func dimensionless(n *Node) bool {
+	if n.IsLiteral() {
+		return !IsQuantity(n.Token)
+	}
+	for _, arg := range n.Args {
+		if !dimensionless(arg) {
+			return false
+		}
+	}
+	return true
+}
+
+// fold вычисляет операцию над литералами. Возвращает nil, если результат нельзя
+// записать литералом (ошибка, комплексное или отрицательное число, остаток деления,
+// отрезок, величина с единицей измерения).
+func fold(n *Node, mode Mode) *Node {
+	args := make([]Value, len(n.Args))
+	for i, arg := range n.Args {
+		if !arg.IsLiteral() {
+			return nil
+		}
+		v, err := arg.Value(mode)
+		if err != nil {
+			return nil
+		}
+		args[i] = v
+	}
+	v, err := Apply(mode, n.Op, args...)
+	if err != nil || v.IsComplex() || v.Rem != nil || v.IsArray() || v.Interval != nil || v.Dim != nil {
+		return nil
+	}
+	if approx := v.Approx(); approx < 0 || math.IsInf(approx, 0) || math.IsNaN(approx) {
+		return nil
+	}
+	return Literal(v.String())
+}
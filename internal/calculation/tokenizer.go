@@ -0,0 +1,280 @@
+package calculation
+
+import (
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type TokenKind int
+
+const (
+	TokenNumber TokenKind = iota
+	TokenIdent
+	TokenOperator
+	TokenBang
+	TokenLParen
+	TokenRParen
+	TokenComma
+	TokenQuestion
+	TokenColon
+	TokenLBracket
+	TokenRBracket
+	TokenRange
+	TokenArrow
+)
+
+var tokenKindNames = []string{"number", "ident", "operator", "bang", "lparen", "rparen", "comma", "question", "colon", "lbracket", "rbracket", "range", "arrow"}
+
+func (k TokenKind) String() string {
+	if int(k) < len(tokenKindNames) {
+		return tokenKindNames[k]
+	}
+	return "unknown"
+}
+
+// MarshalText записывает вид лексемы в JSON именем, а не числом.
+func (k TokenKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Token - лексема выражения. Pos - смещение в байтах от начала исходной строки,
+// Text - нормализованный текст (для чисел - десятичная запись без разделителей).
+type Token struct {
+	Kind TokenKind `json:"kind"`
+	Text string    `json:"text"`
+	Pos  int       `json:"pos"`
+	Len  int       `json:"len"`
+}
+
+// Tokenize разбивает выражение на лексемы. Возвращает первую найденную ошибку.
+func Tokenize(expression string) ([]Token, error) {
+	tokens, errs := tokenize(expression)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return tokens, nil
+}
+
+// tokenize разбивает выражение на лексемы, пропуская ошибочные фрагменты, чтобы
+// найти все лексические ошибки.
+func tokenize(expression string) ([]Token, ParseErrors) {
+	var tokens []Token
+	var errs ParseErrors
+	invalid := func(pos, size int, text string) {
+		errs = append(errs, newParseError(CodeInvalidCharacter, pos, size, errorf(MsgInvalidCharacter, text)))
+	}
+	for i := 0; i < len(expression); {
+		ch, size := utf8.DecodeRuneInString(expression[i:])
+		switch {
+		case unicode.IsSpace(ch):
+			i += size
+		case strings.HasPrefix(expression[i:], ".."):
+			tokens = append(tokens, Token{Kind: TokenRange, Text: "..", Pos: i, Len: 2})
+			i += 2
+		case strings.HasPrefix(expression[i:], "->"):
+			tokens = append(tokens, Token{Kind: TokenArrow, Text: "->", Pos: i, Len: 2})
+			i += 2
+		case ch >= '0' && ch <= '9' || ch == '.':
+			literal := scanNumber(expression[i:])
+			text, err := normalizeNumber(literal, i)
+			end := i + len(literal)
+			if j, ok := uncertaintyStart(expression, end); ok {
+				// число с погрешностью 2.5±0.1 - одна лексема
+				radius := scanNumber(expression[j:])
+				radiusText, radiusErr := normalizeNumber(radius, j)
+				if err == nil {
+					err = radiusErr
+				}
+				text += PlusMinus + radiusText
+				end = j + len(radius)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				tokens = append(tokens, Token{Kind: TokenNumber, Text: text, Pos: i, Len: end - i})
+			}
+			i = end
+		case ch == '{':
+			j := referenceEnd(expression, i)
+			if j < 0 {
+				invalid(i, size, string(ch))
+				i += size
+				continue
+			}
+			// ссылка на результат {expr-1} - одна лексема
+			tokens = append(tokens, Token{Kind: TokenIdent, Text: expression[i:j], Pos: i, Len: j - i})
+			i = j
+		case unicode.IsLetter(ch):
+			j := i
+			for j < len(expression) {
+				r, n := utf8.DecodeRuneInString(expression[j:])
+				if !unicode.IsLetter(r) {
+					break
+				}
+				j += n
+			}
+			if end := cellEnd(expression, i, j); end > j {
+				// ссылка на ячейку A1 - одна лексема
+				j = end
+			}
+			tokens = append(tokens, Token{Kind: TokenIdent, Text: expression[i:j], Pos: i, Len: j - i})
+			i = j
+		case strings.ContainsRune("+-*/^", ch):
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: string(ch), Pos: i, Len: size})
+			i += size
+		case strings.ContainsRune("<>=!", ch):
+			op := string(ch)
+			if i+1 < len(expression) && expression[i+1] == '=' {
+				op += "="
+			}
+			switch op {
+			case "!":
+				tokens = append(tokens, Token{Kind: TokenBang, Text: op, Pos: i, Len: 1})
+			case "=":
+				invalid(i, 1, op)
+			default:
+				tokens = append(tokens, Token{Kind: TokenOperator, Text: op, Pos: i, Len: len(op)})
+			}
+			i += len(op)
+		case ch == ',':
+			tokens = append(tokens, Token{Kind: TokenComma, Text: ",", Pos: i, Len: size})
+			i += size
+		case ch == '?':
+			tokens = append(tokens, Token{Kind: TokenQuestion, Text: "?", Pos: i, Len: size})
+			i += size
+		case ch == ':':
+			tokens = append(tokens, Token{Kind: TokenColon, Text: ":", Pos: i, Len: size})
+			i += size
+		case ch == '(':
+			tokens = append(tokens, Token{Kind: TokenLParen, Text: "(", Pos: i, Len: size})
+			i += size
+		case ch == ')':
+			tokens = append(tokens, Token{Kind: TokenRParen, Text: ")", Pos: i, Len: size})
+			i += size
+		case ch == '[':
+			tokens = append(tokens, Token{Kind: TokenLBracket, Text: "[", Pos: i, Len: size})
+			i += size
+		case ch == ']':
+			tokens = append(tokens, Token{Kind: TokenRBracket, Text: "]", Pos: i, Len: size})
+			i += size
+		default:
+			invalid(i, size, string(ch))
+			i += size
+		}
+	}
+	return tokens, errs
+}
+
+// scanNumber выделяет максимальную последовательность символов, которая может
+// относиться к числовому литералу, включая знак экспоненты десятичных чисел.
+func scanNumber(s string) string {
+	hex := len(s) > 1 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+	i := 0
+	for i < len(s) {
+		ch := s[i]
+		switch {
+		case ch == '.' && i+1 < len(s) && s[i+1] == '.':
+			// начало диапазона 1..n
+			return s[:i]
+		case ch >= '0' && ch <= '9', ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch == '_', ch == '.':
+			i++
+		case (ch == '+' || ch == '-') && !hex && i > 0 && (s[i-1] == 'e' || s[i-1] == 'E') && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			i++
+		default:
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// uncertaintyStart возвращает начало погрешности, если после числа, заканчивающегося
+// в позиции end, стоит знак ± и число.
+func uncertaintyStart(expression string, end int) (int, bool) {
+	rest := strings.TrimLeftFunc(expression[end:], unicode.IsSpace)
+	rest, ok := strings.CutPrefix(rest, PlusMinus)
+	if !ok {
+		return 0, false
+	}
+	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	if rest == "" || !(rest[0] >= '0' && rest[0] <= '9' || rest[0] == '.') {
+		return 0, false
+	}
+	return len(expression) - len(rest), true
+}
+
+// normalizeNumber проверяет литерал и приводит его к десятичной записи. start - смещение
+// литерала в выражении, используется в сообщениях об ошибках.
+func normalizeNumber(literal string, start int) (string, *ParseError) {
+	fail := func(offset int, id MessageID, args ...any) *ParseError {
+		args = append([]any{literal, start + offset + 1}, args...)
+		return newParseError(CodeInvalidNumber, start+offset, min(1, len(literal)-offset), errorf(id, args...))
+	}
+	body, suffix := literal, ""
+	if strings.HasSuffix(body, ImaginaryUnit) {
+		body, suffix = strings.TrimSuffix(body, ImaginaryUnit), ImaginaryUnit
+	}
+
+	if len(body) > 1 && body[0] == '0' && strings.ContainsRune("xXoObB", rune(body[1])) {
+		digits := map[byte]string{'x': "0123456789abcdefABCDEF", 'o': "01234567", 'b': "01"}[body[1]|0x20]
+		if len(body) == 2 {
+			return "", fail(2, MsgNumberNoPrefixDigits, body[:2])
+		}
+		if err := checkDigits(body, 2, digits, fail); err != nil {
+			return "", err
+		}
+		n, ok := new(big.Int).SetString(body, 0)
+		if !ok {
+			return "", fail(0, MsgNumberMalformed)
+		}
+		return n.String() + suffix, nil
+	}
+
+	mantissa, exp := body, ""
+	if i := strings.IndexAny(body, "eE"); i >= 0 {
+		mantissa, exp = body[:i], body[i+1:]
+		if exp == "" || exp == "+" || exp == "-" {
+			return "", fail(len(body), MsgNumberNoExponent)
+		}
+		offset := i + 1
+		if exp[0] == '+' || exp[0] == '-' {
+			offset++
+		}
+		if err := checkDigits(body, offset, "0123456789", fail); err != nil {
+			return "", err
+		}
+	}
+	if dot := strings.IndexByte(mantissa, '.'); dot >= 0 {
+		if second := strings.IndexByte(mantissa[dot+1:], '.'); second >= 0 {
+			return "", fail(dot+1+second, MsgNumberExtraDot)
+		}
+	}
+	if strings.Trim(mantissa, "._") == "" {
+		return "", fail(0, MsgNumberNoDigits)
+	}
+	if err := checkDigits(mantissa, 0, "0123456789.", fail); err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(body, "_", "") + suffix, nil
+}
+
+// checkDigits проверяет, что s[from:] состоит из допустимых цифр, а разделители "_"
+// стоят только между цифрами.
+func checkDigits(s string, from int, digits string, fail func(int, MessageID, ...any) *ParseError) *ParseError {
+	for i := from; i < len(s); i++ {
+		ch := s[i]
+		if ch == '_' {
+			prevOK := i > from && s[i-1] != '_' && s[i-1] != '.'
+			nextOK := i+1 < len(s) && s[i+1] != '_' && s[i+1] != '.'
+			if !prevOK || !nextOK {
+				return fail(i, MsgNumberSeparator)
+			}
+			continue
+		}
+		if !strings.ContainsRune(digits, rune(ch)) {
+			return fail(i, MsgNumberInvalidDigit, string(ch))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+package calculation
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Единицы измерения. Число, за которым через пробел следует единица ("5 km",
+// "3 m/s", "9.81 m/s^2"), - величина: её значение переводится в основные единицы СИ,
+// а размерность хранится в поле Dim значения. Операции над величинами проверяют
+// размерности: складывать и сравнивать можно только величины одной размерности.
+
+// Dimension - показатели степеней основных величин СИ: длины (m), массы (kg),
+// времени (s), силы тока (A), температуры (K), количества вещества (mol) и силы
+// света (cd). Нулевая размерность - безразмерное число.
+type Dimension [7]int8
+
+// baseUnits - обозначения основных единиц СИ в порядке Dimension.
+var baseUnits = [len(Dimension{})]string{"m", "kg", "s", "A", "K", "mol", "cd"}
+
+// unitOrder - порядок основных единиц в записи размерности: kg*m/s^2.
+var unitOrder = []int{1, 0, 2, 3, 4, 5, 6}
+
+// maxUnitExponent ограничивает показатель степени единицы: m^3, s^-2.
+const maxUnitExponent = 20
+
+func (d Dimension) IsZero() bool {
+	return d == Dimension{}
+}
+
+// String записывает размерность через основные единицы СИ: "kg*m/s^2". Размерность
+// только с отрицательными показателями записывается как "s^-1".
+func (d Dimension) String() string {
+	var num, den []string
+	for _, i := range unitOrder {
+		e := d[i]
+		switch {
+		case e > 0:
+			num = append(num, unitPower(baseUnits[i], int(e)))
+		case e < 0 && d.positive():
+			den = append(den, unitPower(baseUnits[i], int(-e)))
+		case e < 0:
+			num = append(num, unitPower(baseUnits[i], int(e)))
+		}
+	}
+	return strings.Join(append([]string{strings.Join(num, "*")}, den...), "/")
+}
+
+func (d Dimension) positive() bool {
+	for _, e := range d {
+		if e > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func unitPower(name string, e int) string {
+	if e == 1 {
+		return name
+	}
+	return name + "^" + strconv.Itoa(e)
+}
+
+func (d Dimension) add(o Dimension, k int) Dimension {
+	for i := range d {
+		d[i] += o[i] * int8(k)
+	}
+	return d
+}
+
+// Unit - единица измерения: во сколько раз она больше основной единицы СИ своей
+// размерности и сама размерность.
+type Unit struct {
+	Factor *big.Rat
+	Dim    Dimension
+}
+
+type unitDef struct {
+	factor string
+	dim    Dimension
+}
+
+// Размерности, через которые заданы единицы реестра.
+var (
+	lengthDim      = Dimension{1, 0, 0, 0, 0, 0, 0}
+	massDim        = Dimension{0, 1, 0, 0, 0, 0, 0}
+	durationDim    = Dimension{0, 0, 1, 0, 0, 0, 0}
+	currentDim     = Dimension{0, 0, 0, 1, 0, 0, 0}
+	temperatureDim = Dimension{0, 0, 0, 0, 1, 0, 0}
+	amountDim      = Dimension{0, 0, 0, 0, 0, 1, 0}
+	luminosityDim  = Dimension{0, 0, 0, 0, 0, 0, 1}
+	areaDim        = Dimension{2, 0, 0, 0, 0, 0, 0}
+	volumeDim      = Dimension{3, 0, 0, 0, 0, 0, 0}
+	frequencyDim   = Dimension{0, 0, -1, 0, 0, 0, 0}
+	forceDim       = Dimension{1, 1, -2, 0, 0, 0, 0}
+	pressureDim    = Dimension{-1, 1, -2, 0, 0, 0, 0}
+	energyDim      = Dimension{2, 1, -2, 0, 0, 0, 0}
+	powerDim       = Dimension{2, 1, -3, 0, 0, 0, 0}
+	chargeDim      = Dimension{0, 0, 1, 1, 0, 0, 0}
+	voltageDim     = Dimension{2, 1, -3, -1, 0, 0, 0}
+	resistanceDim  = Dimension{2, 1, -3, -2, 0, 0, 0}
+)
+
+// units - реестр единиц: основные и производные единицы СИ с распространёнными
+// приставками и общеупотребительные внесистемные единицы. Множители точные.
+var units = map[string]unitDef{
+	"m": {"1", lengthDim}, "km": {"1000", lengthDim}, "cm": {"0.01", lengthDim}, "mm": {"0.001", lengthDim},
+	"um": {"1e-6", lengthDim}, "µm": {"1e-6", lengthDim}, "nm": {"1e-9", lengthDim},
+	"in": {"0.0254", lengthDim}, "ft": {"0.3048", lengthDim}, "yd": {"0.9144", lengthDim}, "mi": {"1609.344", lengthDim},
+	"nmi": {"1852", lengthDim}, "au": {"149597870700", lengthDim}, "ly": {"9460730472580800", lengthDim},
+
+	"kg": {"1", massDim}, "g": {"0.001", massDim}, "mg": {"1e-6", massDim}, "t": {"1000", massDim},
+	"lb": {"0.45359237", massDim}, "oz": {"0.028349523125", massDim},
+
+	"s": {"1", durationDim}, "ms": {"0.001", durationDim}, "us": {"1e-6", durationDim}, "µs": {"1e-6", durationDim},
+	"ns": {"1e-9", durationDim}, "min": {"60", durationDim}, "h": {"3600", durationDim}, "d": {"86400", durationDim},
+
+	"A": {"1", currentDim}, "mA": {"0.001", currentDim},
+	"K":   {"1", temperatureDim},
+	"mol": {"1", amountDim},
+	"cd":  {"1", luminosityDim},
+
+	"ha": {"10000", areaDim},
+	"L":  {"0.001", volumeDim}, "l": {"0.001", volumeDim}, "mL": {"1e-6", volumeDim}, "ml": {"1e-6", volumeDim},
+
+	"Hz": {"1", frequencyDim}, "kHz": {"1e3", frequencyDim}, "MHz": {"1e6", frequencyDim}, "GHz": {"1e9", frequencyDim},
+	"N": {"1", forceDim}, "kN": {"1000", forceDim},
+	"Pa": {"1", pressureDim}, "kPa": {"1000", pressureDim}, "MPa": {"1e6", pressureDim},
+	"bar": {"100000", pressureDim}, "atm": {"101325", pressureDim}, "psi": {"44482216152605/6451600000", pressureDim},
+	"J": {"1", energyDim}, "kJ": {"1000", energyDim}, "MJ": {"1e6", energyDim}, "Wh": {"3600", energyDim}, "kWh": {"3600000", energyDim},
+	"cal": {"4.184", energyDim}, "kcal": {"4184", energyDim}, "eV": {"1.602176634e-19", energyDim},
+	"W": {"1", powerDim}, "kW": {"1000", powerDim}, "MW": {"1e6", powerDim},
+	"C": {"1", chargeDim}, "V": {"1", voltageDim}, "ohm": {"1", resistanceDim}, "Ω": {"1", resistanceDim},
+}
+
+// IsUnit сообщает, что name - обозначение единицы из реестра.
+func IsUnit(name string) bool {
+	_, ok := units[name]
+	return ok
+}
+
+// ParseUnit разбирает запись единицы: обозначения из реестра со степенями, соединённые
+// знаками * и /, например "km/h", "kg*m/s^2", "s^-1".
+func ParseUnit(s string) (Unit, error) {
+	u := Unit{Factor: big.NewRat(1, 1)}
+	sign := 1
+	for rest := s; ; {
+		end := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsLetter(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		name := rest[:end]
+		if name == "" {
+			return Unit{}, errorf(MsgInvalidUnit, s)
+		}
+		def, ok := units[name]
+		if !ok {
+			return Unit{}, errorf(MsgUnknownUnit, name)
+		}
+		rest = rest[end:]
+		e := 1
+		if after, ok := strings.CutPrefix(rest, "^"); ok {
+			digits := len(after) - len(strings.TrimLeft(strings.TrimPrefix(after, "-"), "0123456789"))
+			n, err := strconv.Atoi(after[:digits])
+			if err != nil || n == 0 || n > maxUnitExponent || n < -maxUnitExponent {
+				return Unit{}, errorf(MsgInvalidUnit, s)
+			}
+			e, rest = n, after[digits:]
+		}
+		factor, _ := new(big.Rat).SetString(def.factor)
+		for range max(e, -e) {
+			if e*sign > 0 {
+				u.Factor.Mul(u.Factor, factor)
+			} else {
+				u.Factor.Quo(u.Factor, factor)
+			}
+		}
+		u.Dim = u.Dim.add(def.dim, e*sign)
+		if rest == "" {
+			return u, nil
+		}
+		switch r, size := utf8.DecodeRuneInString(rest); r {
+		case '*':
+			sign = 1
+			rest = rest[size:]
+		case '/':
+			sign = -1
+			rest = rest[size:]
+		default:
+			return Unit{}, errorf(MsgInvalidUnit, s)
+		}
+	}
+}
+
+// IsQuantity сообщает, что запись числа содержит единицу измерения: "5 km".
+func IsQuantity(token string) bool {
+	return strings.Contains(token, " ")
+}
+
+// parseQuantity разбирает литерал величины и переводит значение в основные единицы СИ.
+func parseQuantity(mode Mode, token string) (Value, error) {
+	number, unit, _ := strings.Cut(token, " ")
+	u, err := ParseUnit(unit)
+	if err != nil {
+		return Value{}, err
+	}
+	v, err := ParseValue(mode, number)
+	if err != nil {
+		return Value{}, err
+	}
+	if mode == ModeInteger {
+		// множитель может быть дробным: 5 in = 0.127 m
+		r := new(big.Rat).Mul(new(big.Rat).SetInt(v.Int), u.Factor)
+		if !r.IsInt() {
+			return Value{}, errorf(MsgIntegerOnly, token)
+		}
+		v = IntValue(new(big.Int).Set(r.Num()))
+	} else if v, err = Apply(mode, "*", v, u.value(mode)); err != nil {
+		return Value{}, err
+	}
+	return withDimension(v, u.Dim), nil
+}
+
+// value возвращает множитель единицы в режиме mode (кроме integer).
+func (u Unit) value(mode Mode) Value {
+	switch mode {
+	case ModeRational:
+		return RatValue(new(big.Rat).Set(u.Factor))
+	case ModeInterval:
+		return IntervalValue(ratBound(u.Factor, big.ToNegativeInf), ratBound(u.Factor, big.ToPositiveInf))
+	}
+	f, _ := u.Factor.Float64()
+	return FloatValue(f)
+}
+
+// Dimension возвращает размерность значения.
+func (v Value) Dimension() Dimension {
+	if v.Dim == nil {
+		return Dimension{}
+	}
+	return *v.Dim
+}
+
+// withDimension задаёт размерность значения; безразмерное значение хранится без Dim.
+func withDimension(v Value, d Dimension) Value {
+	v.Dim = nil
+	if !d.IsZero() {
+		v.Dim = &d
+	}
+	return v
+}
+
+// sameDimension возвращает общую размерность операндов сложения или сравнения.
+// Безразмерный ноль совместим с любой величиной: с него начинаются суммы.
+func sameDimension(a, b Value) (Dimension, error) {
+	da, db := a.Dimension(), b.Dimension()
+	switch {
+	case da == db:
+		return da, nil
+	case da.IsZero() && !Truthy(a):
+		return db, nil
+	case db.IsZero() && !Truthy(b):
+		return da, nil
+	}
+	return Dimension{}, errorf(MsgDimensionMismatch, dimensionName(da), dimensionName(db))
+}
+
+// dimensionName записывает размерность для сообщений об ошибках.
+func dimensionName(d Dimension) string {
+	if d.IsZero() {
+		return "1"
+	}
+	return d.String()
+}
+
+// applyQuantity выполняет операцию над величинами: вычисляет размерность результата,
+// а значение - той же операцией над числами без размерности.
+func applyQuantity(mode Mode, op string, args []Value) (Value, error) {
+	a := args[0]
+	plain := make([]Value, len(args))
+	for i, arg := range args {
+		plain[i] = withDimension(arg, Dimension{})
+	}
+	var dim Dimension
+	switch op {
+	case "+", "-":
+		d, err := sameDimension(a, args[1])
+		if err != nil {
+			return Value{}, err
+		}
+		dim = d
+	case "*":
+		dim = a.Dimension().add(args[1].Dimension(), 1)
+	case "/":
+		dim = a.Dimension().add(args[1].Dimension(), -1)
+	case "^":
+		if !args[1].Dimension().IsZero() {
+			return Value{}, errorf(MsgDimensionedExponent, dimensionName(args[1].Dimension()))
+		}
+		n, ok := smallInteger(args[1])
+		if !ok {
+			return Value{}, errorf(MsgUnitExponent, dimensionName(a.Dimension()))
+		}
+		dim = Dimension{}.add(a.Dimension(), n)
+	case "re", "im", "conj", "abs":
+		dim = a.Dimension()
+	case "sqrt":
+		for i, e := range a.Dimension() {
+			if e%2 != 0 {
+				return Value{}, errorf(MsgUnitSqrt, dimensionName(a.Dimension()))
+			}
+			dim[i] = e / 2
+		}
+		if mode == ModeRational && plain[0].Rat != nil && plain[0].Rat.Sign() >= 0 {
+			root, err := ratSqrt(plain[0])
+			if err != nil {
+				return Value{}, err
+			}
+			return withDimension(root, dim), nil
+		}
+	default:
+		return Value{}, errorf(MsgDimensionedArgument, op, dimensionName(a.Dimension()))
+	}
+	v, err := Apply(mode, op, plain...)
+	if err != nil {
+		return Value{}, err
+	}
+	return withDimension(v, dim), nil
+}
+
+// smallInteger возвращает целое значение показателя степени величины.
+func smallInteger(v Value) (int, bool) {
+	if v.IsComplex() {
+		return 0, false
+	}
+	var r *big.Rat
+	switch {
+	case v.Rat != nil:
+		r = v.Rat
+	case v.Int != nil:
+		r = new(big.Rat).SetInt(v.Int)
+	case v.Interval != nil:
+		if v.Interval.Lo != v.Interval.Hi {
+			return 0, false
+		}
+		r = new(big.Rat).SetFloat64(v.Interval.Lo)
+	default:
+		r = new(big.Rat).SetFloat64(v.Float)
+	}
+	if r == nil || !r.IsInt() || !r.Num().IsInt64() || r.Num().Int64() > maxUnitExponent || r.Num().Int64() < -maxUnitExponent {
+		return 0, false
+	}
+	return int(r.Num().Int64()), true
+}
+
+// Convert переводит величину в единицу unit: результат - число единиц unit, без
+// размерности. Размерности величины и единицы должны совпадать.
+func Convert(mode Mode, v Value, unit string) (Value, error) {
+	u, err := ParseUnit(unit)
+	if err != nil {
+		return Value{}, err
+	}
+	if v.IsArray() {
+		return elementwise(v, func(e Value) (Value, error) { return Convert(mode, e, unit) })
+	}
+	if v.Dimension() != u.Dim {
+		return Value{}, errorf(MsgUnitMismatch, dimensionName(v.Dimension()), unit)
+	}
+	plain := withDimension(v, Dimension{})
+	if mode != ModeInteger {
+		return Apply(mode, "/", plain, u.value(mode))
+	}
+	r := new(big.Rat).Quo(new(big.Rat).SetInt(plain.Int), u.Factor)
+	if !r.IsInt() {
+		return Value{}, errorf(MsgInexactConversion, v, unit)
+	}
+	return IntValue(new(big.Int).Set(r.Num())), nil
+}
+
+// quantityString записывает величину: число в основных единицах СИ и размерность.
+func quantityString(number string, d Dimension) string {
+	return number + " " + d.String()
+}
@@ -0,0 +1,600 @@
+package calculation
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Mode задаёт числовую систему, в которой вычисляется выражение.
+type Mode string
+
+const (
+	ModeFloat    Mode = "float"
+	ModeRational Mode = "rational"
+	ModeInteger  Mode = "integer"
+	ModeInterval Mode = "interval"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModeFloat:
+		return ModeFloat, nil
+	case ModeRational:
+		return ModeRational, nil
+	case ModeInteger:
+		return ModeInteger, nil
+	case ModeInterval:
+		return ModeInterval, nil
+	}
+	return "", errorf(MsgUnknownMode, s)
+}
+
+// ImaginaryUnit - мнимая единица; также суффикс комплексных литералов (3+4i).
+const ImaginaryUnit = "i"
+
+// Ограничения, защищающие агентов от вычислений неограниченного размера.
+// maxPowerBits - наибольшая длина в битах целого результата возведения в степень;
+// комплексное число в целую степень не больше maxComplexExponent по модулю
+// возводится умножениями, точно для гауссовых целых (i^2 = -1).
+const (
+	maxExponent        = 100000
+	maxFactorial       = 10000
+	maxPowerBits       = 1 << 20
+	maxComplexExponent = 64
+)
+
+// Value - операнд или результат задачи. В режиме float используются поля Float и Imag
+// (значение комплексное, если Imag != 0), в режиме rational - Rat, в режиме integer - Int.
+// Rem содержит ненулевой остаток целочисленного деления. В режиме interval значение -
+// отрезок Interval (см. interval.go). Вектор или матрица хранит элементы в Elems
+// (см. array.go). Dim - размерность величины с единицей измерения, значение которой
+// хранится в основных единицах СИ (см. units.go); у безразмерных чисел Dim пуст.
+type Value struct {
+	Float    float64    `json:"float,omitempty"`
+	Imag     float64    `json:"imag,omitempty"`
+	Rat      *big.Rat   `json:"rat,omitempty"`
+	Int      *big.Int   `json:"int,omitempty"`
+	Rem      *big.Int   `json:"rem,omitempty"`
+	Interval *Interval  `json:"interval,omitempty"`
+	Elems    []Value    `json:"elems,omitempty"`
+	Rows     int        `json:"rows,omitempty"`
+	Cols     int        `json:"cols,omitempty"`
+	Dim      *Dimension `json:"dim,omitempty"`
+}
+
+func FloatValue(f float64) Value {
+	return Value{Float: f}
+}
+
+func ComplexValue(c complex128) Value {
+	return Value{Float: real(c), Imag: imag(c)}
+}
+
+func RatValue(r *big.Rat) Value {
+	return Value{Rat: r}
+}
+
+func IntValue(i *big.Int) Value {
+	return Value{Int: i}
+}
+
+func ParseValue(mode Mode, token string) (Value, error) {
+	if IsQuantity(token) {
+		return parseQuantity(mode, token)
+	}
+	if IsUncertain(token) && mode != ModeInterval {
+		return Value{}, errorf(MsgIntervalUnavailable, mode)
+	}
+	if strings.HasSuffix(token, ImaginaryUnit) {
+		if mode != ModeFloat {
+			return Value{}, errorf(MsgComplexUnavailable, mode)
+		}
+		coefficient := strings.TrimSuffix(token, ImaginaryUnit)
+		if coefficient == "" {
+			return Value{Imag: 1}, nil
+		}
+		f, err := strconv.ParseFloat(coefficient, 64)
+		if err != nil {
+			return Value{}, errorf(MsgInvalidNumber, token)
+		}
+		return Value{Imag: f}, nil
+	}
+	switch mode {
+	case ModeRational:
+		r, ok := new(big.Rat).SetString(token)
+		if !ok {
+			return Value{}, errorf(MsgInvalidNumber, token)
+		}
+		return RatValue(r), nil
+	case ModeInteger:
+		r, ok := new(big.Rat).SetString(token)
+		if !ok {
+			return Value{}, errorf(MsgInvalidNumber, token)
+		}
+		if !r.IsInt() {
+			return Value{}, errorf(MsgIntegerOnly, token)
+		}
+		return IntValue(new(big.Int).Set(r.Num())), nil
+	case ModeInterval:
+		return parseInterval(token)
+	default:
+		f, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return Value{}, errorf(MsgInvalidNumber, token)
+		}
+		return FloatValue(f), nil
+	}
+}
+
+func (v Value) IsComplex() bool {
+	return v.Imag != 0
+}
+
+func (v Value) Complex() complex128 {
+	return complex(v.Float, v.Imag)
+}
+
+// Approx возвращает десятичное приближение значения (для комплексных - действительную
+// часть, для отрезков - середину).
+func (v Value) Approx() float64 {
+	switch {
+	case v.Interval != nil:
+		return v.Interval.Midpoint()
+	case v.Rat != nil:
+		f, _ := v.Rat.Float64()
+		return f
+	case v.Int != nil:
+		f, _ := new(big.Float).SetInt(v.Int).Float64()
+		return f
+	}
+	return v.Float
+}
+
+func (v Value) String() string {
+	switch {
+	case v.Dim != nil:
+		return quantityString(withDimension(v, Dimension{}).String(), *v.Dim)
+	case v.IsArray():
+		return v.arrayString()
+	case v.Rat != nil:
+		return v.Rat.RatString()
+	case v.Int != nil:
+		return v.Int.String()
+	case v.Interval != nil:
+		return v.Interval.String()
+	}
+	if v.IsComplex() {
+		imag := strconv.FormatFloat(v.Imag, 'g', -1, 64) + ImaginaryUnit
+		if v.Float == 0 {
+			return imag
+		}
+		if v.Imag > 0 {
+			imag = "+" + imag
+		}
+		return strconv.FormatFloat(v.Float, 'g', -1, 64) + imag
+	}
+	return strconv.FormatFloat(v.Float, 'g', -1, 64)
+}
+
+var ErrDivisionByZero = errorf(MsgDivisionByZero)
+
+// Truthy сообщает, является ли значение истинным: истинно любое ненулевое значение
+// (отрезок - если он не равен [0, 0]), вектор или матрица - если истинен хотя бы
+// один элемент.
+func Truthy(v Value) bool {
+	switch {
+	case v.IsArray():
+		return slices.ContainsFunc(v.Elems, Truthy)
+	case v.Rat != nil:
+		return v.Rat.Sign() != 0
+	case v.Int != nil:
+		return v.Int.Sign() != 0
+	case v.Interval != nil:
+		return v.Interval.Lo != 0 || v.Interval.Hi != 0
+	}
+	return v.Float != 0 || v.Imag != 0
+}
+
+// BoolValue представляет логическое значение числом 1 или 0 в заданном режиме.
+func BoolValue(mode Mode, b bool) Value {
+	n := int64(0)
+	if b {
+		n = 1
+	}
+	switch mode {
+	case ModeRational:
+		return RatValue(big.NewRat(n, 1))
+	case ModeInteger:
+		return IntValue(big.NewInt(n))
+	case ModeInterval:
+		return IntervalValue(float64(n), float64(n))
+	}
+	return FloatValue(float64(n))
+}
+
+// Apply выполняет операцию или функцию над аргументами в заданном режиме.
+func Apply(mode Mode, op string, args ...Value) (Value, error) {
+	n := Arity(op)
+	if n == 0 {
+		return Value{}, errorf(MsgUnknownOperation, op)
+	}
+	if len(args) != n {
+		return Value{}, errorf(MsgOperationArity, op, n)
+	}
+	switch op {
+	case "if":
+		if Truthy(args[0]) {
+			return args[1], nil
+		}
+		return args[2], nil
+	case "not":
+		return BoolValue(mode, !Truthy(args[0])), nil
+	case "and":
+		return BoolValue(mode, Truthy(args[0]) && Truthy(args[1])), nil
+	case "or":
+		return BoolValue(mode, Truthy(args[0]) || Truthy(args[1])), nil
+	case "<", "<=", "==", "!=", ">=", ">":
+		result, err := compare(op, args[0], args[1])
+		if err != nil {
+			return Value{}, err
+		}
+		return BoolValue(mode, result), nil
+	}
+	if _, ok := reduceArity(op); ok || aggregates[op] {
+		return applyAggregate(mode, op, args)
+	}
+	if arrayFunctions[op] || IsArrayOp(op) || slices.ContainsFunc(args, Value.IsArray) {
+		return applyArray(mode, op, args)
+	}
+	if slices.ContainsFunc(args, func(v Value) bool { return v.Dim != nil }) {
+		return applyQuantity(mode, op, args)
+	}
+	if mode == ModeInterval {
+		return applyInterval(op, args)
+	}
+	if n == 1 {
+		switch mode {
+		case ModeRational:
+			return applyRatFunction(op, args[0])
+		case ModeInteger:
+			return applyIntFunction(op, args[0])
+		}
+		return applyFunction(op, args[0])
+	}
+	a, b := args[0], args[1]
+	switch mode {
+	case ModeRational:
+		return applyRat(op, a, b)
+	case ModeInteger:
+		return applyInt(op, a, b)
+	}
+	if a.IsComplex() || b.IsComplex() {
+		return applyComplex(op, a.Complex(), b.Complex())
+	}
+	switch op {
+	case "+":
+		return FloatValue(a.Float + b.Float), nil
+	case "-":
+		return FloatValue(a.Float - b.Float), nil
+	case "*":
+		return FloatValue(a.Float * b.Float), nil
+	case "/":
+		if b.Float == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		return FloatValue(a.Float / b.Float), nil
+	case "^":
+		result := math.Pow(a.Float, b.Float)
+		if math.IsNaN(result) {
+			return applyComplex(op, a.Complex(), b.Complex())
+		}
+		return FloatValue(result), nil
+	}
+	return Value{}, errorf(MsgUnknownOperation, op)
+}
+
+func compare(op string, a, b Value) (bool, error) {
+	if (a.Dim != nil || b.Dim != nil) && !a.IsArray() && !b.IsArray() {
+		if _, err := sameDimension(a, b); err != nil {
+			return false, err
+		}
+		a, b = withDimension(a, Dimension{}), withDimension(b, Dimension{})
+	}
+	var c int
+	switch {
+	case a.IsArray() || b.IsArray():
+		switch op {
+		case "==":
+			return equalArrays(a, b)
+		case "!=":
+			equal, err := equalArrays(a, b)
+			return !equal, err
+		}
+		return false, errorf(MsgArrayOrdering)
+	case a.Rat != nil && b.Rat != nil:
+		c = a.Rat.Cmp(b.Rat)
+	case a.Int != nil && b.Int != nil:
+		c = a.Int.Cmp(b.Int)
+	case a.Interval != nil && b.Interval != nil:
+		return compareIntervals(op, *a.Interval, *b.Interval)
+	case a.IsComplex() || b.IsComplex():
+		switch op {
+		case "==":
+			return a.Complex() == b.Complex(), nil
+		case "!=":
+			return a.Complex() != b.Complex(), nil
+		}
+		return false, errorf(MsgComplexOrdering)
+	case a.Float < b.Float:
+		c = -1
+	case a.Float > b.Float:
+		c = 1
+	}
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case "==":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return c > 0, nil
+}
+
+func applyRat(op string, a, b Value) (Value, error) {
+	if a.Rat == nil || b.Rat == nil {
+		return Value{}, errorf(MsgExpectedRational)
+	}
+	r := new(big.Rat)
+	switch op {
+	case "+":
+		r.Add(a.Rat, b.Rat)
+	case "-":
+		r.Sub(a.Rat, b.Rat)
+	case "*":
+		r.Mul(a.Rat, b.Rat)
+	case "/":
+		if b.Rat.Sign() == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		r.Quo(a.Rat, b.Rat)
+	case "^":
+		if !b.Rat.IsInt() {
+			return Value{}, errorf(MsgRationalExponent)
+		}
+		n, err := exponent(b.Rat.Num())
+		if err != nil {
+			return Value{}, err
+		}
+		if n < 0 {
+			if a.Rat.Sign() == 0 {
+				return Value{}, ErrDivisionByZero
+			}
+			n = -n
+			r.Inv(a.Rat)
+		} else {
+			r.Set(a.Rat)
+		}
+		num, err := intPower(r.Num(), n)
+		if err != nil {
+			return Value{}, err
+		}
+		den, err := intPower(r.Denom(), n)
+		if err != nil {
+			return Value{}, err
+		}
+		r.SetFrac(num, den)
+	default:
+		return Value{}, errorf(MsgUnknownOperation, op)
+	}
+	return RatValue(r), nil
+}
+
+// applyInt выполняет операцию над целыми числами. Деление целочисленное (с отбрасыванием
+// дробной части), ненулевой остаток возвращается в поле Rem результата.
+func applyInt(op string, a, b Value) (Value, error) {
+	if a.Int == nil || b.Int == nil {
+		return Value{}, errorf(MsgExpectedInteger)
+	}
+	r := new(big.Int)
+	switch op {
+	case "+":
+		r.Add(a.Int, b.Int)
+	case "-":
+		r.Sub(a.Int, b.Int)
+	case "*":
+		r.Mul(a.Int, b.Int)
+	case "/":
+		if b.Int.Sign() == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		rem := new(big.Int)
+		r.QuoRem(a.Int, b.Int, rem)
+		if rem.Sign() != 0 {
+			return Value{Int: r, Rem: rem}, nil
+		}
+	case "^":
+		n, err := exponent(b.Int)
+		if err != nil {
+			return Value{}, err
+		}
+		if n < 0 {
+			return Value{}, errorf(MsgNegativeExponent)
+		}
+		if r, err = intPower(a.Int, n); err != nil {
+			return Value{}, err
+		}
+	default:
+		return Value{}, errorf(MsgUnknownOperation, op)
+	}
+	return IntValue(r), nil
+}
+
+func exponent(n *big.Int) (int64, error) {
+	if !n.IsInt64() || n.Int64() > maxExponent || n.Int64() < -maxExponent {
+		return 0, errorf(MsgExponentTooLarge, n)
+	}
+	return n.Int64(), nil
+}
+
+// intPower возводит base в степень n >= 0. Результат занимает не больше bitlen(base)*n
+// бит; если эта оценка превышает maxPowerBits, возвращается ошибка вместо
+// вычисления, исчерпывающего память.
+func intPower(base *big.Int, n int64) (*big.Int, error) {
+	if bits := int64(base.BitLen()) * n; base.BitLen() > 1 && bits > maxPowerBits {
+		return nil, errorf(MsgPowerTooLarge, bits, maxPowerBits)
+	}
+	return new(big.Int).Exp(base, big.NewInt(n), nil), nil
+}
+
+func factorial(n *big.Int) (*big.Int, error) {
+	if n.Sign() < 0 {
+		return nil, errorf(MsgFactorialDomain)
+	}
+	if !n.IsInt64() || n.Int64() > maxFactorial {
+		return nil, errorf(MsgFactorialTooLarge, n)
+	}
+	return new(big.Int).MulRange(1, n.Int64()), nil
+}
+
+func applyComplex(op string, a, b complex128) (Value, error) {
+	switch op {
+	case "+":
+		return ComplexValue(a + b), nil
+	case "-":
+		return ComplexValue(a - b), nil
+	case "*":
+		return ComplexValue(a * b), nil
+	case "/":
+		if b == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		return ComplexValue(a / b), nil
+	case "^":
+		if n := real(b); imag(b) == 0 && n == math.Trunc(n) && math.Abs(n) <= maxComplexExponent && a != 0 {
+			return ComplexValue(complexPower(a, int(n))), nil
+		}
+		return ComplexValue(cmplx.Pow(a, b)), nil
+	}
+	return Value{}, errorf(MsgUnknownOperation, op)
+}
+
+// complexPower возводит a в целую степень n возведением в квадрат и умножением.
+func complexPower(a complex128, n int) complex128 {
+	if n < 0 {
+		return 1 / complexPower(a, -n)
+	}
+	result := complex(1, 0)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result *= a
+		}
+		a *= a
+	}
+	return result
+}
+
+func applyFunction(name string, a Value) (Value, error) {
+	switch name {
+	case "re":
+		return FloatValue(a.Float), nil
+	case "im":
+		return FloatValue(a.Imag), nil
+	case "abs":
+		return FloatValue(cmplx.Abs(a.Complex())), nil
+	case "arg":
+		return FloatValue(cmplx.Phase(a.Complex())), nil
+	case "conj":
+		return ComplexValue(cmplx.Conj(a.Complex())), nil
+	case "sqrt":
+		if !a.IsComplex() && a.Float >= 0 {
+			return FloatValue(math.Sqrt(a.Float)), nil
+		}
+		return ComplexValue(cmplx.Sqrt(a.Complex())), nil
+	case "ln":
+		if a.Complex() == 0 {
+			return Value{}, errorf(MsgLogZero)
+		}
+		if !a.IsComplex() && a.Float > 0 {
+			return FloatValue(math.Log(a.Float)), nil
+		}
+		return ComplexValue(cmplx.Log(a.Complex())), nil
+	case "exp":
+		if !a.IsComplex() {
+			return FloatValue(math.Exp(a.Float)), nil
+		}
+		return ComplexValue(cmplx.Exp(a.Complex())), nil
+	case "fact":
+		if a.IsComplex() || a.Float < 0 || a.Float != math.Trunc(a.Float) {
+			return Value{}, errorf(MsgFactorialDomain)
+		}
+		result := math.Gamma(a.Float + 1)
+		if math.IsInf(result, 0) {
+			return Value{}, errorf(MsgFactorialTooLarge, a.Float)
+		}
+		return FloatValue(math.Round(result)), nil
+	}
+	return Value{}, errorf(MsgUnknownFunction, name)
+}
+
+func applyRatFunction(name string, a Value) (Value, error) {
+	if a.Rat == nil {
+		return Value{}, errorf(MsgExpectedRational)
+	}
+	switch name {
+	case "re", "conj":
+		return RatValue(new(big.Rat).Set(a.Rat)), nil
+	case "im":
+		return RatValue(new(big.Rat)), nil
+	case "abs":
+		return RatValue(new(big.Rat).Abs(a.Rat)), nil
+	case "fact":
+		if !a.Rat.IsInt() {
+			return Value{}, errorf(MsgFactorialDomain)
+		}
+		f, err := factorial(a.Rat.Num())
+		if err != nil {
+			return Value{}, err
+		}
+		return RatValue(new(big.Rat).SetInt(f)), nil
+	}
+	return Value{}, errorf(MsgFunctionUnavailable, name, ModeRational)
+}
+
+func applyIntFunction(name string, a Value) (Value, error) {
+	if a.Int == nil {
+		return Value{}, errorf(MsgExpectedInteger)
+	}
+	switch name {
+	case "re", "conj":
+		return IntValue(new(big.Int).Set(a.Int)), nil
+	case "im":
+		return IntValue(new(big.Int)), nil
+	case "abs":
+		return IntValue(new(big.Int).Abs(a.Int)), nil
+	case "sqrt":
+		if a.Int.Sign() < 0 {
+			return Value{}, errorf(MsgNegativeIntegerSqrt)
+		}
+		root := new(big.Int).Sqrt(a.Int)
+		if new(big.Int).Mul(root, root).Cmp(a.Int) != 0 {
+			return Value{}, errorf(MsgInexactIntegerSqrt, a.Int)
+		}
+		return IntValue(root), nil
+	case "fact":
+		f, err := factorial(a.Int)
+		if err != nil {
+			return Value{}, err
+		}
+		return IntValue(f), nil
+	}
+	return Value{}, errorf(MsgFunctionUnavailable, name, ModeInteger)
+}
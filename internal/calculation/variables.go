@@ -0,0 +1,293 @@
+package calculation
+
+import (
+	"maps"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"unicode"
+)
+
+// Переменные в выражениях. Имя переменной состоит из букв и не совпадает с именем
+// функции, логической операции и мнимой единицей. В дереве переменная - числовой
+// узел с её именем; перед вычислением переменные заменяются значениями.
+
+var keywords = map[string]bool{"and": true, "or": true, "not": true}
+
+// ValidVariable сообщает, можно ли использовать name как имя переменной.
+func ValidVariable(name string) bool {
+	if name == "" || name == ImaginaryUnit || keywords[name] || IsFunction(name) {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsCell сообщает, что name - ссылка на ячейку таблицы: заглавные латинские буквы
+// столбца и номер строки, например A1 или AB12. Ссылки на ячейки подставляются в
+// выражение так же, как переменные.
+func IsCell(name string) bool {
+	letters := 0
+	for letters < len(name) && name[letters] >= 'A' && name[letters] <= 'Z' {
+		letters++
+	}
+	return letters > 0 && letters < len(name) && cellEnd(name, 0, letters) == len(name)
+}
+
+// cellEnd возвращает конец ссылки на ячейку, если буквы expression[start:end] -
+// заглавные латинские и за ними следует номер строки; иначе возвращает end.
+func cellEnd(expression string, start, end int) int {
+	for i := start; i < end; i++ {
+		if expression[i] < 'A' || expression[i] > 'Z' {
+			return end
+		}
+	}
+	digits := end
+	for digits < len(expression) && expression[digits] >= '0' && expression[digits] <= '9' {
+		digits++
+	}
+	if digits == end || expression[end] == '0' {
+		return end
+	}
+	return digits
+}
+
+// Cells возвращает ссылки на ячейки, встречающиеся в выражении, в порядке появления.
+func Cells(expression string) []string {
+	tokens, _ := tokenize(expression)
+	var cells []string
+	for _, tok := range tokens {
+		if tok.Kind == TokenIdent && IsCell(tok.Text) && !slices.Contains(cells, tok.Text) {
+			cells = append(cells, tok.Text)
+		}
+	}
+	return cells
+}
+
+// Answer - имя, под которым в выражение подставляется последний результат пользователя.
+const Answer = "ans"
+
+// IsReference сообщает, что name - ссылка на результат другого выражения: его
+// идентификатор в фигурных скобках, например {expr-1234}.
+func IsReference(name string) bool {
+	return referenceEnd(name, 0) == len(name)
+}
+
+// referenceEnd возвращает конец ссылки на результат, начинающейся в expression[start],
+// или -1, если ссылки там нет. Идентификатор состоит из латинских букв, цифр, "-" и "_".
+func referenceEnd(expression string, start int) int {
+	if start >= len(expression) || expression[start] != '{' {
+		return -1
+	}
+	for i := start + 1; i < len(expression); i++ {
+		switch ch := expression[i]; {
+		case ch == '}' && i > start+1:
+			return i + 1
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '-', ch == '_':
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// References возвращает ссылки на результаты других выражений в порядке появления:
+// {expr-1234} и Answer, если он встречается в выражении.
+func References(expression string) []string {
+	tokens, _ := tokenize(expression)
+	var refs []string
+	for _, tok := range tokens {
+		if tok.Kind == TokenIdent && (IsReference(tok.Text) || tok.Text == Answer) && !slices.Contains(refs, tok.Text) {
+			refs = append(refs, tok.Text)
+		}
+	}
+	return refs
+}
+
+// variableSet возвращает множество допустимых имён переменных.
+func variableSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		if ValidVariable(name) || IsCell(name) || IsReference(name) {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+// ParseWith разбирает выражение, в котором могут встречаться переменные variables.
+func ParseWith(expression string, variables ...string) (*Node, error) {
+	postfix, err := infixToPostfix(expression, variableSet(variables))
+	if err != nil {
+		return nil, err
+	}
+	return BuildTree(postfix)
+}
+
+// IsVariable сообщает, что узел - переменная.
+func (n *Node) IsVariable() bool {
+	return n.IsLiteral() && (ValidVariable(n.Token) || IsCell(n.Token) || IsReference(n.Token))
+}
+
+// Depends сообщает, встречается ли в дереве переменная variable.
+func (n *Node) Depends(variable string) bool {
+	if n.IsLiteral() {
+		return n.Token == variable
+	}
+	if _, bound, ok := ParseRangeOp(n.Op); ok && bound == variable {
+		// переменная диапазона скрывает внешнюю с тем же именем
+		return n.Args[0].Depends(variable) || n.Args[1].Depends(variable)
+	}
+	for _, arg := range n.Args {
+		if arg.Depends(variable) {
+			return true
+		}
+	}
+	return false
+}
+
+// Substitute возвращает копию дерева, в которой переменные заменены числами из values.
+// Исходное дерево не изменяется.
+func (n *Node) Substitute(values map[string]string) *Node {
+	bindings := make(map[string]*Node, len(values))
+	for name, value := range values {
+		bindings[name] = Literal(value)
+	}
+	return n.Bind(bindings)
+}
+
+// Constant возвращает дерево, которое в режиме mode вычисляется точно в значение v,
+// например в результат другого выражения. Запись v.String() для этого не подходит:
+// комплексные числа, отрезки и векторы записываются в ней не числом, а дробь "1/3"
+// не разбирается в режиме float. Дерево строится из неотрицательных чисел, поэтому
+// его запись String() снова разбирается в то же дерево. Значение другого режима
+// переводится в режим mode: дробь в режиме float становится ближайшим числом float64,
+// а отрезок вне режима interval и комплексное число вне режима float дают ошибку.
+func Constant(v Value, mode Mode) (*Node, error) {
+	switch {
+	case v.Dim != nil:
+		n, err := Constant(withDimension(v, Dimension{}), mode)
+		if err != nil || v.Dim.IsZero() {
+			return n, err
+		}
+		return op("*", n, Literal("1 "+v.Dim.String())), nil
+	case v.IsArray():
+		return arrayConstant(v, mode)
+	case v.Interval != nil:
+		if mode != ModeInterval {
+			return nil, errorf(MsgIntervalUnavailable, mode)
+		}
+		return intervalConstant(*v.Interval)
+	case v.IsComplex():
+		if mode != ModeFloat {
+			return nil, errorf(MsgComplexUnavailable, mode)
+		}
+		re, err := Constant(FloatValue(v.Float), mode)
+		if err != nil {
+			return nil, err
+		}
+		im := Literal(strconv.FormatFloat(math.Abs(v.Imag), 'g', -1, 64) + ImaginaryUnit)
+		if v.Imag < 0 {
+			return op("-", re, im), nil
+		}
+		return op("+", re, im), nil
+	case v.Rat != nil && mode == ModeFloat:
+		f, _ := v.Rat.Float64()
+		return Constant(FloatValue(f), mode)
+	case v.Rat != nil:
+		return numberConstant(new(big.Rat).Abs(v.Rat).RatString(), v.Rat.Sign() < 0, mode)
+	case v.Int != nil:
+		return numberConstant(new(big.Int).Abs(v.Int).String(), v.Int.Sign() < 0, mode)
+	}
+	if math.IsInf(v.Float, 0) || math.IsNaN(v.Float) {
+		return nil, errorf(MsgResultOutOfRange)
+	}
+	return numberConstant(strconv.FormatFloat(math.Abs(v.Float), 'g', -1, 64), v.Float < 0, mode)
+}
+
+// numberConstant возвращает число token, а если negative - разность 0 - token.
+func numberConstant(token string, negative bool, mode Mode) (*Node, error) {
+	if _, err := ParseValue(mode, token); err != nil {
+		return nil, err
+	}
+	if negative {
+		return op("-", Literal("0"), Literal(token)), nil
+	}
+	return Literal(token), nil
+}
+
+// arrayConstant собирает вектор из чисел или матрицу из векторов-строк.
+func arrayConstant(v Value, mode Mode) (*Node, error) {
+	if v.IsMatrix() {
+		rows := make([]*Node, v.Rows)
+		for i := range rows {
+			row, err := arrayConstant(v.row(i), mode)
+			if err != nil {
+				return nil, err
+			}
+			rows[i] = row
+		}
+		return op(ArrayOp(len(rows)), rows...), nil
+	}
+	elems := make([]*Node, len(v.Elems))
+	for i, elem := range v.Elems {
+		n, err := Constant(elem, mode)
+		if err != nil {
+			return nil, err
+		}
+		elems[i] = n
+	}
+	return op(ArrayOp(len(elems)), elems...), nil
+}
+
+// intervalConstant записывает отрезок числом с погрешностью: его середина и радиус -
+// двоично-рациональные числа, и их десятичная запись конечна и точна.
+func intervalConstant(x Interval) (*Node, error) {
+	if math.IsInf(x.Lo, 0) || math.IsInf(x.Hi, 0) {
+		return nil, errorf(MsgResultOutOfRange)
+	}
+	lo, hi := new(big.Rat).SetFloat64(x.Lo), new(big.Rat).SetFloat64(x.Hi)
+	center := new(big.Rat).Add(lo, hi)
+	center.Quo(center, big.NewRat(2, 1))
+	radius := new(big.Rat).Sub(hi, lo)
+	radius.Quo(radius, big.NewRat(2, 1))
+	token := exactDecimal(new(big.Rat).Abs(center))
+	if radius.Sign() > 0 {
+		token += PlusMinus + exactDecimal(radius)
+	}
+	return numberConstant(token, center.Sign() < 0, ModeInterval)
+}
+
+// exactDecimal возвращает точную десятичную запись неотрицательного числа, знаменатель
+// которого - степень двойки.
+func exactDecimal(r *big.Rat) string {
+	return r.FloatString(r.Denom().BitLen() - 1)
+}
+
+// Bind возвращает копию дерева, в которой переменные заменены поддеревьями из
+// bindings. Исходное дерево и поддеревья не изменяются.
+func (n *Node) Bind(bindings map[string]*Node) *Node {
+	if n.IsLiteral() {
+		if tree, ok := bindings[n.Token]; ok && n.IsVariable() {
+			return tree
+		}
+		return n
+	}
+	node := &Node{Op: n.Op, Args: make([]*Node, len(n.Args))}
+	for i, arg := range n.Args {
+		node.Args[i] = arg.Bind(bindings)
+	}
+	if _, bound, _ := ParseRangeOp(n.Op); bound != "" {
+		if _, ok := bindings[bound]; ok {
+			inner := maps.Clone(bindings)
+			delete(inner, bound)
+			node.Args[2] = n.Args[2].Bind(inner)
+		}
+	}
+	return node
+}
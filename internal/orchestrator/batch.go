@@ -0,0 +1,126 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
+)
+
+// Batch - пакет выражений, отправленных одним запросом /api/v1/calculate/batch.
+// Каждое принятое выражение вычисляется как обычное, с Parent, равным ID пакета;
+// сводный статус пакета считается по выражениям в базе.
+type Batch struct {
+	ID     string
+	UserID string `json:"-"`
+	// Status - pending, пока есть невычисленные выражения, затем completed.
+	Status    string
+	Total     int
+	Accepted  int
+	Rejected  int
+	Pending   int
+	Completed int
+	Failed    int
+	// Progress - доля вычисленных принятых выражений, от 0 до 1.
+	Progress float64
+	Items    []BatchItem
+}
+
+// BatchItem - результат проверки элемента пакета: идентификатор выражения или ошибка.
+type BatchItem struct {
+	Index  int                  `json:"index"`
+	ID     string               `json:"id,omitempty"`
+	Error  string               `json:"error,omitempty"`
+	Errors []parseErrorResponse `json:"errors,omitempty"`
+}
+
+// maxBatchItems - наибольшее число выражений в пакете.
+const maxBatchItems = 1000
+
+// maxBatchLine - наибольшая длина строки пакета в формате NDJSON.
+const maxBatchLine = 1 << 20
+
+func generateBatchID() string {
+	return "batch-" + uuid.New().String()
+}
+
+// calculateRequest - запрос на вычисление выражения: тело /api/v1/calculate или
+// элемент пакета.
+type calculateRequest struct {
+	Expression string `json:"expression"`
+	Mode       string `json:"mode"`
+	Precompute bool   `json:"precompute"`
+	Unit       string `json:"unit"`
+}
+
+// expression проверяет запрос и создаёт выражение пользователя userID в режиме mode.
+// Ссылки на результаты других выражений проверяются при разборе как переменные.
+// Ошибки разбора возвращаются как calculation.ParseErrors.
+func (req calculateRequest) expression(userID string, mode calculation.Mode) (*Expression, error) {
+	if errs := calculation.CheckScript(req.Expression, mode, calculation.References(req.Expression)...); len(errs) > 0 {
+		return nil, errs
+	}
+	if req.Unit != "" {
+		if _, err := calculation.ParseUnit(req.Unit); err != nil {
+			return nil, err
+		}
+	}
+	expr := NewExpression(generateID(), userID, req.Expression)
+	expr.Mode = mode
+	expr.Precompute = req.Precompute
+	expr.Unit = req.Unit
+	return expr, nil
+}
+
+// decodeBatch разбирает тело пакета: JSON-массив или NDJSON, по элементу в строке.
+// Элемент - строка с выражением или объект, как тело /api/v1/calculate.
+func decodeBatch(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+	var items []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, maxBatchLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return nil, errors.New("invalid NDJSON line")
+		}
+		items = append(items, json.RawMessage(bytes.Clone(line)))
+	}
+	return items, scanner.Err()
+}
+
+// decodeBatchItem разбирает элемент пакета.
+func decodeBatchItem(raw json.RawMessage) (calculateRequest, error) {
+	var req calculateRequest
+	if err := json.Unmarshal(raw, &req.Expression); err == nil {
+		return req, nil
+	}
+	err := json.Unmarshal(raw, &req)
+	return req, err
+}
+
+// summarize заполняет сводку пакета по числу его выражений с каждым статусом.
+func (b *Batch) summarize(statuses map[string]int) {
+	b.Pending, b.Completed, b.Failed = statuses["pending"], statuses["completed"], statuses["error"]
+	b.Status = "completed"
+	if b.Pending > 0 {
+		b.Status = "pending"
+	}
+	if b.Accepted > 0 {
+		b.Progress = float64(b.Completed+b.Failed) / float64(b.Accepted)
+	}
+}
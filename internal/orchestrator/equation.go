@@ -0,0 +1,184 @@
+package orchestrator
+
+import (
+	"math"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
+)
+
+// Методы решения уравнений.
+const (
+	MethodLinear    = "linear"
+	MethodNewton    = "newton"
+	MethodBisection = "bisection"
+)
+
+// Equation - уравнение с одним неизвестным. Линейные уравнения решаются символьно
+// и точно, в режиме Mode. Остальные решаются численно, методом Ньютона или бисекцией;
+// каждое значение функции и её производной вычисляют агенты, как обычное выражение.
+type Equation struct {
+	ID       string
+	UserID   string
+	Equation string
+	Variable string
+	Mode     calculation.Mode
+	Method   string
+	// Status - pending, converged или failed.
+	Status   string
+	Solution string  `json:",omitempty"`
+	Result   float64 `json:",omitempty"`
+	// Residual - значение левой части минус правая в найденном корне.
+	Residual      float64  `json:",omitempty"`
+	Iterations    int      `json:",omitempty"`
+	Tolerance     float64  `json:",omitempty"`
+	MaxIterations int      `json:",omitempty"`
+	Initial       float64  `json:",omitempty"`
+	Lower         *float64 `json:",omitempty"`
+	Upper         *float64 `json:",omitempty"`
+	// Evaluations - выражения, которыми агенты вычисляли функцию.
+	Evaluations []string `json:",omitempty"`
+	Error       string   `json:",omitempty"`
+
+	// function и derivative - левая часть минус правая и её производная.
+	function   string
+	derivative string
+	err        error
+}
+
+// evaluator вычисляет выражение function в точке x.
+type evaluator func(function string, x float64) (float64, error)
+
+func generateEquationID() string {
+	return "eq-" + uuid.New().String()
+}
+
+// solveLinear решает уравнение символьно. Возвращает false, если уравнение не линейно.
+func (eq *Equation) solveLinear(f *calculation.Node) bool {
+	x, linear, err := calculation.SolveLinear(f, eq.Variable, eq.Mode)
+	if !linear {
+		return false
+	}
+	eq.Method = MethodLinear
+	if err != nil {
+		eq.fail(err)
+		return true
+	}
+	eq.Status = "converged"
+	eq.Solution = x.String()
+	eq.Result = finite(x.Approx())
+	return true
+}
+
+// run решает уравнение численно. Поля уравнения изменяются под mu.
+func (eq *Equation) run(mu sync.Locker, evaluate evaluator) {
+	var x, fx float64
+	var err error
+	if eq.Method == MethodBisection {
+		x, fx, err = eq.bisection(mu, evaluate)
+	} else {
+		x, fx, err = eq.newton(mu, evaluate)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		eq.fail(err)
+		return
+	}
+	eq.Status = "converged"
+	eq.Solution = calculation.FloatValue(x).String()
+	eq.Result = x
+	eq.Residual = fx
+}
+
+func (eq *Equation) iteration(mu sync.Locker) {
+	mu.Lock()
+	eq.Iterations++
+	mu.Unlock()
+}
+
+// newton ищет корень методом Ньютона, начиная с Initial. Сходимость - когда значение
+// функции или шаг не больше Tolerance.
+func (eq *Equation) newton(mu sync.Locker, evaluate evaluator) (float64, float64, error) {
+	x := eq.Initial
+	for i := 0; i < eq.MaxIterations; i++ {
+		fx, err := evaluate(eq.function, x)
+		if err != nil {
+			return 0, 0, err
+		}
+		if math.Abs(fx) <= eq.Tolerance {
+			return x, fx, nil
+		}
+		dfx, err := evaluate(eq.derivative, x)
+		if err != nil {
+			return 0, 0, err
+		}
+		if dfx == 0 {
+			return 0, 0, messages.Error(MsgZeroDerivative, calculation.FloatValue(x))
+		}
+		eq.iteration(mu)
+		next := x - fx/dfx
+		if math.Abs(next-x) <= eq.Tolerance {
+			fx, err := evaluate(eq.function, next)
+			return next, fx, err
+		}
+		x = next
+	}
+	return 0, 0, messages.Error(MsgNotConverged, eq.MaxIterations)
+}
+
+// bisection ищет корень делением отрезка [Lower, Upper] пополам; на концах отрезка
+// функция должна иметь разные знаки.
+func (eq *Equation) bisection(mu sync.Locker, evaluate evaluator) (float64, float64, error) {
+	a, b := *eq.Lower, *eq.Upper
+	fa, err := evaluate(eq.function, a)
+	if err != nil {
+		return 0, 0, err
+	}
+	fb, err := evaluate(eq.function, b)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch {
+	case fa == 0:
+		return a, fa, nil
+	case fb == 0:
+		return b, fb, nil
+	case math.Signbit(fa) == math.Signbit(fb):
+		return 0, 0, messages.Error(MsgNoSignChange, calculation.FloatValue(a), calculation.FloatValue(b))
+	}
+	for i := 0; i < eq.MaxIterations; i++ {
+		m := (a + b) / 2
+		fm, err := evaluate(eq.function, m)
+		if err != nil {
+			return 0, 0, err
+		}
+		eq.iteration(mu)
+		if math.Abs(fm) <= eq.Tolerance || (b-a)/2 <= eq.Tolerance {
+			return m, fm, nil
+		}
+		if math.Signbit(fm) == math.Signbit(fa) {
+			a, fa = m, fm
+		} else {
+			b = m
+		}
+	}
+	return 0, 0, messages.Error(MsgNotConverged, eq.MaxIterations)
+}
+
+func (eq *Equation) fail(err error) {
+	eq.Status = "failed"
+	eq.Error = err.Error()
+	eq.err = err
+}
+
+// Localize возвращает копию уравнения с сообщением об ошибке на языке lang.
+func (eq *Equation) Localize(lang calculation.Lang) Equation {
+	localized := *eq
+	if eq.err != nil {
+		localized.Error = calculation.Translate(eq.err, lang)
+	}
+	return localized
+}
@@ -2,6 +2,10 @@ package orchestrator
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -9,15 +13,140 @@ import (
 )
 
 type Expression struct {
-	ID        string
-	UserID    string
-	Expr      string
-	Status    string
-	Result    float64
+	ID     string
+	UserID string
+	Expr   string
+	Mode   calculation.Mode
+	// Variables - значения переменных, которые подставляются в выражение.
+	Variables map[string]string `json:",omitempty"`
+	// Parent - задание, частью которого вычисляется выражение, например перебор параметров.
+	Parent   string `json:",omitempty"`
+	Status   string
+	Result   float64
+	Fraction string  `json:",omitempty"`
+	Imag     float64 `json:",omitempty"`
+	Integer  string  `json:",omitempty"`
+	// Array - результат-вектор или матрица, например "[[1, 2], [3, 4]]".
+	Array string `json:",omitempty"`
+	// Interval - границы и середина результата в режиме interval; Result - середина.
+	Interval *Bounds `json:",omitempty"`
+	// Unit - единица измерения результата: запрошенная или, если не задана, основная
+	// единица СИ размерности результата ("m/s"). Result выражен в этой единице.
+	Unit string `json:",omitempty"`
+	// Assignments - значения переменных, присвоенных в сценарии "a = 2; b = a * 3; b",
+	// в порядке присваивания; Result - значение последней инструкции.
+	Assignments []Assignment `json:",omitempty"`
+	// Remainders - ненулевые остатки целочисленного деления в режиме integer.
+	Remainders []Remainder `json:",omitempty"`
+	// Precompute - вычислять операции над одними литералами до отправки агентам.
+	Precompute bool `json:",omitempty"`
+	// Simplified - выражение после упрощения, Eliminated - сколько задач это сэкономило.
+	Simplified string `json:",omitempty"`
+	Eliminated int    `json:",omitempty"`
+	// Shared - сколько задач сэкономлено объединением одинаковых подвыражений.
+	Shared int `json:",omitempty"`
+	// Canonical, LaTeX и MathML - записи выражения, заполняются после вычисления.
+	Canonical string `json:",omitempty"`
+	LaTeX     string `json:",omitempty"`
+	MathML    string `json:",omitempty"`
+	// Skipped - число задач невыбранных ветвей условий, не отправленных агентам.
+	Skipped   int    `json:",omitempty"`
+	Error     string `json:",omitempty"`
 	Tasks     map[string]*calculation.Task
 	TaskOrder []string
 	Results   []float64
 	Postfix   []string
+	// Steps - выполненные задачи в порядке завершения, отдаются отдельным запросом.
+	Steps []Step `json:"-"`
+
+	values map[string]calculation.Value
+	// constants - точные значения переменных, например результаты ячеек таблицы;
+	// заменяют одноимённые записи Variables, которые остаются для показа.
+	constants map[string]calculation.Value
+	// value - точное значение результата до перевода в единицу Unit.
+	value calculation.Value
+	// references - выражения, на результаты которых ссылается выражение; задачи
+	// отправляются агентам после их вычисления.
+	references []reference
+	outputs    []calculation.Output
+	shapes     map[string]taskShape
+	started    map[string]taskStart
+	waiting    map[string]*calculation.Task
+	tasksChan  chan<- *calculation.Task
+	// done закрывается, когда выражение вычислено или завершилось ошибкой.
+	done chan struct{}
+	// err - причина ошибки, по которой Error переводится на язык запроса.
+	err error
+}
+
+// Assignment - значение переменной, присвоенной в сценарии.
+type Assignment struct {
+	Variable string  `json:"variable"`
+	Value    string  `json:"value"`
+	Result   float64 `json:"result"`
+}
+
+type Remainder struct {
+	TaskID    string `json:"task_id"`
+	Dividend  string `json:"dividend"`
+	Divisor   string `json:"divisor"`
+	Quotient  string `json:"quotient"`
+	Remainder string `json:"remainder"`
+}
+
+// Bounds - отрезок, содержащий точный результат вычисления в режиме interval.
+type Bounds struct {
+	Lower    float64 `json:"lower"`
+	Upper    float64 `json:"upper"`
+	Midpoint float64 `json:"midpoint"`
+}
+
+func newBounds(x *calculation.Interval) *Bounds {
+	return &Bounds{Lower: x.Lo, Upper: x.Hi, Midpoint: x.Midpoint()}
+}
+
+// Step - шаг пошагового решения: выполненная задача, её операнды и результат.
+type Step struct {
+	Number      int      `json:"number"`
+	TaskID      string   `json:"task_id"`
+	Operation   string   `json:"operation"`
+	Operands    []string `json:"operands"`
+	Calculation string   `json:"calculation"`
+	Result      string   `json:"result"`
+	// Expression - выражение после подстановки результата шага.
+	Expression string `json:"expression"`
+	// Agent - идентификатор агента; у задач, выполненных оркестратором, Local = true.
+	Agent      string     `json:"agent,omitempty"`
+	Local      bool       `json:"local,omitempty"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   time.Time  `json:"finished"`
+	DurationMS int64      `json:"duration_ms"`
+	// Text - объяснение шага на языке запроса.
+	Text string `json:"text,omitempty"`
+}
+
+// Explain возвращает объяснение шага на языке lang. last - последний шаг вычисления.
+func (s Step) Explain(lang calculation.Lang, last bool) string {
+	number := fmt.Sprint(s.Number)
+	if last {
+		return messages.Localize(lang, MsgStepAnswer, number, s.Calculation, s.Result)
+	}
+	return messages.Localize(lang, MsgStep, number, s.Calculation, s.Result, s.Expression)
+}
+
+// taskShape - исходный вид задачи: операнд - число или, если deps[i] не пуст, результат
+// другой задачи.
+type taskShape struct {
+	op       string
+	local    bool
+	operands []string
+	deps     []string
+}
+
+// taskStart - кто и когда взял задачу в работу.
+type taskStart struct {
+	agent string
+	at    time.Time
 }
 
 func NewExpression(id, userID, expr string) *Expression {
@@ -25,66 +154,580 @@ func NewExpression(id, userID, expr string) *Expression {
 		ID:        id,
 		UserID:    userID,
 		Expr:      expr,
+		Mode:      calculation.ModeFloat,
 		Status:    "pending",
 		Tasks:     make(map[string]*calculation.Task),
 		TaskOrder: []string{},
 		Results:   []float64{},
 		Postfix:   []string{},
+		values:    make(map[string]calculation.Value),
+		waiting:   make(map[string]*calculation.Task),
+		done:      make(chan struct{}),
 	}
 }
 
-func (s *Expression) Start(tasksChan chan<- *calculation.Task) {
-	postfix, err := calculation.InfixToPostfix(s.Expr)
-	if err != nil {
-		s.Status = "error"
+// Done возвращает канал, который закрывается по завершении вычисления.
+func (e *Expression) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *Expression) finish() {
+	if e.done == nil {
 		return
 	}
-	s.Postfix = postfix
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+}
+
+func (s *Expression) Start(tasksChan chan<- *calculation.Task) {
+	s.dispatch(s.plan(tasksChan))
+}
 
-	tasks, err := calculation.GenerateTasks(postfix)
+// plan разбирает выражение и регистрирует его задачи. Возвращает задачи, готовые
+// к отправке агентам; остальные ждут результатов задач, от которых зависят.
+func (s *Expression) plan(tasksChan chan<- *calculation.Task) []*calculation.Task {
+	s.tasksChan = tasksChan
+	plan, err := calculation.NewPlanValues(s.Expr, s.Mode, s.Precompute, s.Variables, s.constants)
 	if err != nil {
-		s.Status = "error"
-		return
+		s.fail(err)
+		return nil
+	}
+	s.Simplified = plan.Simplified.String()
+	s.Eliminated = plan.Eliminated()
+	s.Postfix = plan.Postfix
+	s.outputs = plan.Outputs
+	for i := range s.outputs {
+		if s.outputs[i].TaskID != "" {
+			s.outputs[i].TaskID = s.ID + "/" + s.outputs[i].TaskID
+		}
+	}
+	tasks := plan.Tasks
+	if len(tasks) == 0 {
+		value, err := calculation.LiteralValue(plan.Postfix, s.Mode)
+		if err != nil {
+			s.fail(err)
+			return nil
+		}
+		s.complete(value)
+		return nil
 	}
+	s.Shared = plan.Shared()
+	s.shapes = make(map[string]taskShape, len(tasks))
 
-	for i, task := range tasks {
-		fmt.Printf("Task %d: Operation=%s, Arg1=%f, Arg2=%f\n", i+1, task.Operation, task.Arg1, task.Arg2)
+	for _, task := range tasks {
+		task.ID = s.ID + "/" + task.ID
+		for i, dep := range task.DependsOn {
+			if dep != "" {
+				task.DependsOn[i] = s.ID + "/" + dep
+			}
+		}
+		for i := range task.Guards {
+			task.Guards[i].TaskID = s.ID + "/" + task.Guards[i].TaskID
+		}
+		shape := taskShape{op: task.Operation, local: task.Local, deps: append([]string(nil), task.DependsOn...)}
+		for _, arg := range task.Args {
+			shape.operands = append(shape.operands, arg.String())
+		}
+		if task.Body != "" {
+			shape.operands = append(shape.operands, task.Body)
+			shape.deps = append(shape.deps, "")
+		}
+		s.shapes[task.ID] = shape
 		s.Tasks[task.ID] = task
 		s.TaskOrder = append(s.TaskOrder, task.ID)
-		tasksChan <- task
+		s.waiting[task.ID] = task
+	}
+	for _, task := range tasks {
+		if task.Operation == "if" && task.DependsOn[0] == "" {
+			s.choose(task)
+		}
+	}
+	ready := s.collectReady()
+	s.checkCompleted()
+	return ready
+}
+
+func (s *Expression) dispatch(tasks []*calculation.Task) {
+	for _, task := range tasks {
+		s.tasksChan <- task
+	}
+}
+
+// Owns сообщает, принадлежит ли задача этому выражению.
+func (e *Expression) Owns(taskID string) bool {
+	return strings.HasPrefix(taskID, e.ID+"/")
+}
+
+// StartTask отмечает, что агент agent взял задачу в работу.
+func (e *Expression) StartTask(taskID, agent string, at time.Time) {
+	if e.started == nil {
+		e.started = make(map[string]taskStart)
 	}
+	e.started[taskID] = taskStart{agent: agent, at: at}
 }
 
 func (e *Expression) UpdateTaskResult(taskID string, result float64) bool {
-	if task, exists := e.Tasks[taskID]; exists {
-		delete(e.Tasks, taskID)
-		if task.Arg1 == 0 && len(e.Results) > 0 {
-			intermediateResult := e.Results[len(e.Results)-1]
-			switch task.Operation {
-			case "*":
-				result = intermediateResult * task.Arg2
-			case "/":
-				result = intermediateResult / task.Arg2
-			case "+":
-				result = intermediateResult + task.Arg2
-			case "-":
-				result = intermediateResult - task.Arg2
+	return e.UpdateTaskValue(taskID, calculation.FloatValue(result))
+}
+
+func (e *Expression) UpdateTaskValue(taskID string, result calculation.Value) bool {
+	task, exists := e.Tasks[taskID]
+	if !exists {
+		return false
+	}
+	delete(e.Tasks, taskID)
+	if result.Rem != nil {
+		e.Remainders = append(e.Remainders, Remainder{
+			TaskID:    taskID,
+			Dividend:  task.Args[0].String(),
+			Divisor:   task.Args[1].String(),
+			Quotient:  result.Int.String(),
+			Remainder: result.Rem.String(),
+		})
+		result.Rem = nil
+	}
+	e.resolve(taskID, result)
+
+	ready := e.collectReady()
+	if len(ready) > 0 {
+		go e.dispatch(ready)
+	}
+	e.checkCompleted()
+	return true
+}
+
+// resolve сохраняет результат задачи и подставляет его в зависящие от неё задачи.
+func (e *Expression) resolve(taskID string, result calculation.Value) {
+	delete(e.Tasks, taskID)
+	e.values[taskID] = result
+	e.Results = append(e.Results, finite(result.Approx()))
+	e.recordStep(taskID, result)
+	for _, task := range e.waiting {
+		for i, dep := range task.DependsOn {
+			if dep != taskID {
+				continue
+			}
+			task.SetArg(i, result)
+			if task.Operation == "if" && i == 0 {
+				e.choose(task)
 			}
 		}
-		e.Results = append(e.Results, result)
-		fmt.Printf("Updated task %s with result %f, e.Results=%v\n", taskID, result, e.Results)
+	}
+}
 
-		if len(e.Tasks) == 0 {
-			e.Status = "completed"
-			if len(e.Results) > 0 {
-				e.Result = e.Results[len(e.Results)-1]
-				fmt.Printf("Final e.Result=%f\n", e.Result)
+// recordStep добавляет шаг решения для только что вычисленной задачи. Операнды
+// невыбранной ветви условия не вычислялись и записываются как "…".
+func (e *Expression) recordStep(taskID string, result calculation.Value) {
+	shape, ok := e.shapes[taskID]
+	if !ok {
+		return
+	}
+	step := Step{
+		Number:    len(e.Steps) + 1,
+		TaskID:    taskID,
+		Operation: shape.op,
+		Result:    result.String(),
+		Local:     shape.local,
+		Finished:  time.Now(),
+	}
+	node := &calculation.Node{Op: shape.op}
+	for i, dep := range shape.deps {
+		operand := shape.operands[i]
+		if dep != "" {
+			operand = "…"
+			if value, ok := e.values[dep]; ok {
+				operand = value.String()
 			}
 		}
-		return true
+		step.Operands = append(step.Operands, operand)
+		node.Args = append(node.Args, calculation.Literal(operand))
+	}
+	step.Calculation = node.String()
+	if start, ok := e.started[taskID]; ok {
+		step.Agent, step.Started = start.agent, &start.at
+		step.DurationMS = step.Finished.Sub(start.at).Milliseconds()
+		delete(e.started, taskID)
+	}
+	step.Expression = e.resultTree().String()
+	e.Steps = append(e.Steps, step)
+}
+
+// resultTree строит дерево результата выражения, в котором вычисленные задачи заменены
+// их результатами. Результат сценария - значение последней инструкции.
+func (e *Expression) resultTree() *calculation.Node {
+	if n := len(e.outputs); n > 0 && e.outputs[n-1].TaskID == "" {
+		return calculation.Literal(e.outputs[n-1].Value.String())
+	}
+	return e.substituted(e.TaskOrder[len(e.TaskOrder)-1])
+}
+
+// outputValue возвращает значение инструкции сценария.
+func (e *Expression) outputValue(output calculation.Output) calculation.Value {
+	if output.TaskID != "" {
+		return e.values[output.TaskID]
+	}
+	return output.Value
+}
+
+// substituted строит дерево задачи, в котором вычисленные задачи заменены их результатами.
+func (e *Expression) substituted(taskID string) *calculation.Node {
+	if value, ok := e.values[taskID]; ok {
+		return calculation.Literal(value.String())
+	}
+	shape := e.shapes[taskID]
+	node := &calculation.Node{Op: shape.op}
+	for i, dep := range shape.deps {
+		if dep == "" {
+			node.Args = append(node.Args, calculation.Literal(shape.operands[i]))
+		} else {
+			node.Args = append(node.Args, e.substituted(dep))
+		}
+	}
+	return node
+}
+
+// choose выбирает ветвь условной задачи по уже известному условию: задачи невыбранной
+// ветви отменяются и никогда не попадут к агентам, с задач выбранной снимается ожидание.
+func (e *Expression) choose(cond *calculation.Task) {
+	branch := cond.Branch()
+	skipped := 3 - branch
+	cond.DependsOn[skipped] = ""
+	for id, task := range e.Tasks {
+		guards := task.Guards[:0]
+		cancel := false
+		for _, guard := range task.Guards {
+			switch {
+			case guard.TaskID != cond.ID:
+				guards = append(guards, guard)
+			case guard.Branch != branch:
+				cancel = true
+			}
+		}
+		task.Guards = guards
+		if cancel {
+			delete(e.Tasks, id)
+			delete(e.waiting, id)
+			e.Skipped++
+		}
+	}
+}
+
+// collectReady возвращает задачи, все операнды которых известны. Задачи, выполняемые
+// самим оркестратором, вычисляются сразу.
+func (e *Expression) collectReady() []*calculation.Task {
+	var ready []*calculation.Task
+	for {
+		var local []*calculation.Task
+		for id, task := range e.waiting {
+			if !task.Ready() {
+				continue
+			}
+			delete(e.waiting, id)
+			if task.Local {
+				local = append(local, task)
+			} else if blocks := e.splitMatmul(task); blocks != nil {
+				ready = append(ready, blocks...)
+			} else if calculation.IsRangeOp(task.Operation) {
+				chunks, err := e.splitRange(task)
+				if err != nil {
+					e.fail(err)
+					return nil
+				}
+				ready = append(ready, chunks...)
+				if task.Ready() {
+					local = append(local, task)
+				}
+			} else {
+				ready = append(ready, task)
+			}
+		}
+		if len(local) == 0 {
+			return ready
+		}
+		for _, task := range local {
+			value, err := calculation.Apply(task.Mode, task.Operation, task.Args...)
+			if err != nil {
+				e.fail(err)
+				return nil
+			}
+			e.resolve(task.ID, value)
+		}
+	}
+}
+
+// matrixBlockRows - сколько строк произведения матриц вычисляет одна задача агента.
+const matrixBlockRows = 16
+
+// splitMatmul делит умножение матрицы с большим числом строк на задачи по
+// matrixBlockRows строк, которые агенты вычисляют параллельно. Сама задача становится
+// задачей оркестратора, складывающей блоки результата. Возвращает nil, если делить
+// не нужно.
+func (e *Expression) splitMatmul(task *calculation.Task) []*calculation.Task {
+	if task.Operation != "matmul" {
+		return nil
+	}
+	a, b := task.Args[0], task.Args[1]
+	if !a.IsMatrix() || !b.IsMatrix() || a.Cols != b.Rows || a.Rows <= matrixBlockRows {
+		return nil
+	}
+	blocks := calculation.SplitRows(a, matrixBlockRows)
+	tasks := make([]*calculation.Task, len(blocks))
+	task.Operation = calculation.ArrayOp(len(blocks))
+	task.Local = true
+	task.Args = make([]calculation.Value, len(blocks))
+	task.DependsOn = make([]string, len(blocks))
+	for i, block := range blocks {
+		id := fmt.Sprintf("%s/b%d", task.ID, i)
+		tasks[i] = &calculation.Task{
+			ID:            id,
+			Operation:     "matmul",
+			OperationTime: task.OperationTime,
+			Mode:          task.Mode,
+			Args:          []calculation.Value{block, b},
+			DependsOn:     []string{"", ""},
+		}
+		task.DependsOn[i] = id
+		e.addSubtask(tasks[i], []string{block.String(), b.String()})
+	}
+	e.makeLocal(task)
+	return tasks
+}
+
+// rangeChunkSize - сколько значений диапазона агрегатной функции обрабатывает одна
+// задача агента.
+const rangeChunkSize = 10000
+
+// splitRange делит диапазон агрегатной функции на части по rangeChunkSize значений.
+// Агенты сводят каждую часть к частичному результату и попарно объединяют частичные
+// результаты деревом задач merge; сама задача становится задачей оркестратора,
+// получающей значение функции из корня дерева. Возвращает задачи частей.
+func (e *Expression) splitRange(task *calculation.Task) ([]*calculation.Task, error) {
+	name, _, _ := calculation.ParseRangeOp(task.Operation)
+	lo, hi, err := calculation.RangeBounds(task.Args[0], task.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	bound := func(k int64) calculation.Value {
+		v, _ := calculation.ParseValue(task.Mode, strconv.FormatInt(k, 10))
+		return v
+	}
+	var chunks []*calculation.Task
+	var level []string
+	for start := lo; start <= hi; start += rangeChunkSize {
+		end := min(start+rangeChunkSize-1, hi)
+		chunk := &calculation.Task{
+			ID:            fmt.Sprintf("%s/chunk-%d", task.ID, len(chunks)+1),
+			Operation:     task.Operation,
+			OperationTime: task.OperationTime,
+			Mode:          task.Mode,
+			Args:          []calculation.Value{bound(start), bound(end)},
+			DependsOn:     []string{"", ""},
+			Body:          task.Body,
+		}
+		operands := []string{chunk.Args[0].String(), chunk.Args[1].String()}
+		if task.Body != "" {
+			operands = append(operands, task.Body)
+		}
+		e.addSubtask(chunk, operands)
+		chunks = append(chunks, chunk)
+		level = append(level, chunk.ID)
+	}
+	for depth := 1; len(level) > 1; depth++ {
+		var next []string
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			merge := &calculation.Task{
+				ID:            fmt.Sprintf("%s/merge-%d-%d", task.ID, depth, len(next)+1),
+				Operation:     calculation.MergeOp(name),
+				OperationTime: task.OperationTime,
+				Mode:          task.Mode,
+				Args:          make([]calculation.Value, 2),
+				DependsOn:     []string{level[i], level[i+1]},
+				// упорядоченные значения median оркестратор сливает сам: передавать
+				// их агентам дольше, чем слить
+				Local: name == "median",
+			}
+			e.addSubtask(merge, []string{"", ""})
+			next = append(next, merge.ID)
+		}
+		level = next
+	}
+
+	task.Operation = calculation.FinishOp(name)
+	task.Body = ""
+	task.Args = make([]calculation.Value, 1)
+	task.DependsOn = []string{""}
+	if len(level) == 0 {
+		// пустой диапазон
+		if task.Args[0], err = calculation.Partial(task.Mode, name, nil); err != nil {
+			return nil, err
+		}
+	} else {
+		task.DependsOn[0] = level[0]
+	}
+	e.makeLocal(task)
+	return chunks, nil
+}
+
+// addSubtask добавляет задачу, на которую оркестратор разбил задачу плана.
+func (e *Expression) addSubtask(task *calculation.Task, operands []string) {
+	shape := taskShape{op: task.Operation, operands: operands, deps: make([]string, len(operands))}
+	copy(shape.deps, task.DependsOn)
+	e.Tasks[task.ID] = task
+	e.shapes[task.ID] = shape
+	if !task.Ready() {
+		e.waiting[task.ID] = task
+	}
+}
+
+// makeLocal оставляет разбитую задачу оркестратору: она ждёт результатов своих частей,
+// а в шагах решения по-прежнему записывается в исходном виде.
+func (e *Expression) makeLocal(task *calculation.Task) {
+	task.Local = true
+	shape := e.shapes[task.ID]
+	shape.local = true
+	e.shapes[task.ID] = shape
+	if !task.Ready() {
+		e.waiting[task.ID] = task
+	}
+}
+
+func (e *Expression) checkCompleted() {
+	if len(e.Tasks) == 0 && e.Status == "pending" {
+		if n := len(e.outputs); n > 0 {
+			e.complete(e.outputValue(e.outputs[n-1]))
+		} else {
+			e.complete(e.values[e.TaskOrder[len(e.TaskOrder)-1]])
+		}
+	}
+}
+
+// FailTask помечает выражение как ошибочное, если агент не смог выполнить задачу.
+func (e *Expression) FailTask(taskID string, err error) bool {
+	if _, exists := e.Tasks[taskID]; !exists {
+		return false
+	}
+	delete(e.Tasks, taskID)
+	e.fail(err)
+	return true
+}
+
+func (e *Expression) complete(value calculation.Value) {
+	e.value = value
+	value, unit, err := convertResult(e.Mode, value, e.Unit)
+	if err != nil {
+		e.fail(err)
+		return
+	}
+	e.Unit = unit
+	if outOfRange(value) {
+		e.fail(calculation.NewError(calculation.MsgResultOutOfRange))
+		return
+	}
+	e.Status = "completed"
+	e.Result = finite(value.Approx())
+	e.Imag = value.Imag
+	if value.Rat != nil {
+		e.Fraction = value.Rat.RatString()
+	}
+	if value.Int != nil {
+		e.Integer = value.Int.String()
+	}
+	if value.IsArray() {
+		e.Array = value.String()
+	}
+	if value.Interval != nil {
+		e.Interval = newBounds(value.Interval)
+	}
+	for _, output := range e.outputs {
+		if output.Variable != "" {
+			v := e.outputValue(output)
+			e.Assignments = append(e.Assignments, Assignment{Variable: output.Variable, Value: v.String(), Result: finite(v.Approx())})
+		}
+	}
+	e.format()
+	e.finish()
+}
+
+// format заполняет каноническую запись выражения, LaTeX и MathML.
+func (e *Expression) format() {
+	if calculation.IsScript(e.Expr) {
+		script, err := calculation.ParseScript(e.Expr, e.variableNames()...)
+		if err != nil {
+			return
+		}
+		e.Canonical, e.LaTeX, e.MathML = script.String(), script.LaTeX(), script.MathML()
+		return
+	}
+	tree, err := calculation.ParseWith(e.Expr, e.variableNames()...)
+	if err != nil {
+		return
+	}
+	e.Canonical, e.LaTeX, e.MathML = tree.String(), tree.LaTeX(), tree.MathML()
+}
+
+func (e *Expression) variableNames() []string {
+	names := make([]string, 0, len(e.Variables))
+	for name := range e.Variables {
+		names = append(names, name)
+	}
+	return names
+}
+
+// convertResult переводит величину в запрошенную единицу unit. Если единица не задана,
+// величина остаётся в основных единицах СИ, а их запись возвращается как unit.
+func convertResult(mode calculation.Mode, value calculation.Value, unit string) (calculation.Value, string, error) {
+	if unit != "" {
+		value, err := calculation.Convert(mode, value, unit)
+		return value, unit, err
+	}
+	if value.Dim != nil {
+		unit = value.Dim.String()
+		value.Dim = nil
+	}
+	return value, unit, nil
+}
+
+// outOfRange сообщает, что приближённое значение результата не представимо в float64,
+// а точного значения нет. У отрезка обе границы должны быть конечными.
+func outOfRange(value calculation.Value) bool {
+	if value.Interval != nil {
+		return math.IsInf(value.Interval.Lo, 0) || math.IsInf(value.Interval.Hi, 0)
+	}
+	approx := value.Approx()
+	return value.Int == nil && value.Rat == nil && (math.IsInf(approx, 0) || math.IsNaN(approx))
+}
+
+// finite заменяет значения, непредставимые в JSON, нулём; точное значение
+// в таких случаях остаётся в полях Fraction или Integer.
+func finite(f float64) float64 {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0
+	}
+	return f
+}
+
+func (e *Expression) fail(err error) {
+	e.Status = "error"
+	e.Error = err.Error()
+	e.err = err
+	e.waiting = make(map[string]*calculation.Task)
+	e.finish()
+}
+
+// Localize возвращает копию выражения с сообщением об ошибке на языке lang.
+func (e *Expression) Localize(lang calculation.Lang) Expression {
+	localized := *e
+	if e.err != nil {
+		localized.Error = calculation.Translate(e.err, lang)
 	}
-	fmt.Printf("Task %s not found\n", taskID)
-	return false
+	return localized
 }
 
 func generateID() string {
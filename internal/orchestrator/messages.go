@@ -0,0 +1,258 @@
+package orchestrator
+
+import "github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
+
+const (
+	MsgUnauthorized         calculation.MessageID = "unauthorized"
+	MsgAuthorizationNeeded  calculation.MessageID = "authorization_required"
+	MsgInvalidToken         calculation.MessageID = "invalid_token"
+	MsgInvalidTokenClaims   calculation.MessageID = "invalid_token_claims"
+	MsgInvalidUserID        calculation.MessageID = "invalid_user_id"
+	MsgInvalidRequestBody   calculation.MessageID = "invalid_request_body"
+	MsgInvalidMode          calculation.MessageID = "invalid_mode"
+	MsgInvalidLanguage      calculation.MessageID = "invalid_language"
+	MsgInvalidExpression    calculation.MessageID = "invalid_expression"
+	MsgInternalError        calculation.MessageID = "internal_error"
+	MsgDatabaseError        calculation.MessageID = "database_error"
+	MsgLoginExists          calculation.MessageID = "login_exists"
+	MsgInvalidCredentials   calculation.MessageID = "invalid_credentials"
+	MsgExpressionNotFound   calculation.MessageID = "expression_not_found"
+	MsgExpressionTooComplex calculation.MessageID = "expression_too_complex"
+	MsgInvalidVariable      calculation.MessageID = "invalid_variable"
+	MsgInvalidMethod        calculation.MessageID = "invalid_method"
+	MsgInvalidBounds        calculation.MessageID = "invalid_bounds"
+	MsgInvalidTolerance     calculation.MessageID = "invalid_tolerance"
+	MsgInvalidIterations    calculation.MessageID = "invalid_iterations"
+	MsgNumericMode          calculation.MessageID = "numeric_mode"
+	MsgEquationNotFound     calculation.MessageID = "equation_not_found"
+	MsgZeroDerivative       calculation.MessageID = "zero_derivative"
+	MsgNoSignChange         calculation.MessageID = "no_sign_change"
+	MsgNotConverged         calculation.MessageID = "not_converged"
+	MsgInvalidCell          calculation.MessageID = "invalid_cell"
+	MsgCircularReference    calculation.MessageID = "circular_reference"
+	MsgEmptyCell            calculation.MessageID = "empty_cell"
+	MsgCellError            calculation.MessageID = "cell_error"
+	MsgSheetNotFound        calculation.MessageID = "sheet_not_found"
+	MsgInvalidVersion       calculation.MessageID = "invalid_version"
+	MsgNoParameters         calculation.MessageID = "no_parameters"
+	MsgInvalidParameter     calculation.MessageID = "invalid_parameter"
+	MsgTooManyPoints        calculation.MessageID = "too_many_points"
+	MsgSweepNotFound        calculation.MessageID = "sweep_not_found"
+	MsgEmptyBatch           calculation.MessageID = "empty_batch"
+	MsgBatchTooLarge        calculation.MessageID = "batch_too_large"
+	MsgBatchNotFound        calculation.MessageID = "batch_not_found"
+	MsgReferenceNotFound    calculation.MessageID = "reference_not_found"
+	MsgNoAnswer             calculation.MessageID = "no_answer"
+	MsgReferenceFailed      calculation.MessageID = "reference_failed"
+	MsgReferenceLost        calculation.MessageID = "reference_lost"
+	MsgInvalidSchedule      calculation.MessageID = "invalid_schedule"
+	MsgInvalidCron          calculation.MessageID = "invalid_cron"
+	MsgPastSchedule         calculation.MessageID = "past_schedule"
+	MsgScheduleNotFound     calculation.MessageID = "schedule_not_found"
+	MsgScheduleInactive     calculation.MessageID = "schedule_inactive"
+	MsgStep                 calculation.MessageID = "step"
+	MsgStepAnswer           calculation.MessageID = "step_answer"
+)
+
+// messages - сообщения HTTP API. По умолчанию ответы на английском, как и раньше.
+var messages = &calculation.Catalogue{
+	Default: calculation.LangEnglish,
+	Messages: map[calculation.MessageID]map[calculation.Lang]string{
+		MsgUnauthorized: {
+			calculation.LangEnglish: "Unauthorized",
+			calculation.LangRussian: "Требуется авторизация",
+		},
+		MsgAuthorizationNeeded: {
+			calculation.LangEnglish: "Authorization header required",
+			calculation.LangRussian: "Нужен заголовок Authorization",
+		},
+		MsgInvalidToken: {
+			calculation.LangEnglish: "Invalid token",
+			calculation.LangRussian: "Недействительный токен",
+		},
+		MsgInvalidTokenClaims: {
+			calculation.LangEnglish: "Invalid token claims",
+			calculation.LangRussian: "Некорректные данные токена",
+		},
+		MsgInvalidUserID: {
+			calculation.LangEnglish: "Invalid user ID in token",
+			calculation.LangRussian: "Некорректный идентификатор пользователя в токене",
+		},
+		MsgInvalidRequestBody: {
+			calculation.LangEnglish: "Invalid request body",
+			calculation.LangRussian: "Некорректное тело запроса",
+		},
+		MsgInvalidMode: {
+			calculation.LangEnglish: "Invalid mode",
+			calculation.LangRussian: "Некорректный режим вычислений",
+		},
+		MsgInvalidLanguage: {
+			calculation.LangEnglish: "Invalid language",
+			calculation.LangRussian: "Неподдерживаемый язык",
+		},
+		MsgInvalidExpression: {
+			calculation.LangEnglish: "Invalid expression",
+			calculation.LangRussian: "Некорректное выражение",
+		},
+		MsgInternalError: {
+			calculation.LangEnglish: "Internal server error",
+			calculation.LangRussian: "Внутренняя ошибка сервера",
+		},
+		MsgDatabaseError: {
+			calculation.LangEnglish: "Database error: %s",
+			calculation.LangRussian: "Ошибка базы данных: %s",
+		},
+		MsgLoginExists: {
+			calculation.LangEnglish: "Login already exists",
+			calculation.LangRussian: "Логин уже занят",
+		},
+		MsgInvalidCredentials: {
+			calculation.LangEnglish: "Invalid credentials",
+			calculation.LangRussian: "Неверный логин или пароль",
+		},
+		MsgExpressionNotFound: {
+			calculation.LangEnglish: "Expression not found",
+			calculation.LangRussian: "Выражение не найдено",
+		},
+		MsgExpressionTooComplex: {
+			calculation.LangEnglish: "Expression is too complex to evaluate immediately: %s operations, the limit is %s",
+			calculation.LangRussian: "Выражение слишком сложное для немедленного вычисления: операций %s, допустимо не больше %s",
+		},
+		MsgInvalidVariable: {
+			calculation.LangEnglish: "Invalid variable name: %s",
+			calculation.LangRussian: "Некорректное имя переменной: %s",
+		},
+		MsgInvalidMethod: {
+			calculation.LangEnglish: "Invalid method: %s",
+			calculation.LangRussian: "Неизвестный метод решения: %s",
+		},
+		MsgInvalidBounds: {
+			calculation.LangEnglish: "Bisection requires bounds lower < upper",
+			calculation.LangRussian: "Для бисекции нужны границы lower < upper",
+		},
+		MsgInvalidTolerance: {
+			calculation.LangEnglish: "Tolerance must be positive",
+			calculation.LangRussian: "Точность должна быть положительной",
+		},
+		MsgInvalidIterations: {
+			calculation.LangEnglish: "The iteration limit must be between 1 and %s",
+			calculation.LangRussian: "Число итераций должно быть от 1 до %s",
+		},
+		MsgNumericMode: {
+			calculation.LangEnglish: "Non-linear equations are solved numerically only in float mode, got %s",
+			calculation.LangRussian: "Нелинейные уравнения решаются численно только в режиме float, а не %s",
+		},
+		MsgEquationNotFound: {
+			calculation.LangEnglish: "Equation not found",
+			calculation.LangRussian: "Уравнение не найдено",
+		},
+		MsgZeroDerivative: {
+			calculation.LangEnglish: "The derivative is zero at %s, Newton's method cannot continue",
+			calculation.LangRussian: "Производная в точке %s равна нулю, метод Ньютона не может продолжаться",
+		},
+		MsgNoSignChange: {
+			calculation.LangEnglish: "The function has the same sign at %s and %s",
+			calculation.LangRussian: "Функция имеет одинаковый знак в точках %s и %s",
+		},
+		MsgNotConverged: {
+			calculation.LangEnglish: "No convergence after %s iterations",
+			calculation.LangRussian: "Нет сходимости за %s итераций",
+		},
+		MsgInvalidCell: {
+			calculation.LangEnglish: "Invalid cell name: %s",
+			calculation.LangRussian: "Некорректное имя ячейки: %s",
+		},
+		MsgCircularReference: {
+			calculation.LangEnglish: "Circular reference: %s",
+			calculation.LangRussian: "Циклическая ссылка: %s",
+		},
+		MsgEmptyCell: {
+			calculation.LangEnglish: "Cell %s is empty",
+			calculation.LangRussian: "Ячейка %s пуста",
+		},
+		MsgCellError: {
+			calculation.LangEnglish: "Cell %s contains an error",
+			calculation.LangRussian: "Ячейка %s содержит ошибку",
+		},
+		MsgSheetNotFound: {
+			calculation.LangEnglish: "Sheet not found",
+			calculation.LangRussian: "Таблица не найдена",
+		},
+		MsgInvalidVersion: {
+			calculation.LangEnglish: "Invalid version: %s",
+			calculation.LangRussian: "Некорректный номер версии: %s",
+		},
+		MsgNoParameters: {
+			calculation.LangEnglish: "At least one parameter is required",
+			calculation.LangRussian: "Нужен хотя бы один параметр",
+		},
+		MsgInvalidParameter: {
+			calculation.LangEnglish: "Invalid values of parameter %s: expected a list of numbers or from, to and step with from <= to and step > 0",
+			calculation.LangRussian: "Некорректные значения параметра %s: нужен список чисел или from, to и step, где from <= to и step > 0",
+		},
+		MsgTooManyPoints: {
+			calculation.LangEnglish: "The parameter grid has %s points, the limit is %s",
+			calculation.LangRussian: "В сетке параметров %s точек, допустимо не больше %s",
+		},
+		MsgSweepNotFound: {
+			calculation.LangEnglish: "Sweep not found",
+			calculation.LangRussian: "Перебор не найден",
+		},
+		MsgEmptyBatch: {
+			calculation.LangEnglish: "The batch contains no expressions",
+			calculation.LangRussian: "Пакет не содержит выражений",
+		},
+		MsgBatchTooLarge: {
+			calculation.LangEnglish: "The batch has %s expressions, the limit is %s",
+			calculation.LangRussian: "В пакете %s выражений, допустимо не больше %s",
+		},
+		MsgBatchNotFound: {
+			calculation.LangEnglish: "Batch not found",
+			calculation.LangRussian: "Пакет не найден",
+		},
+		MsgReferenceNotFound: {
+			calculation.LangEnglish: "Referenced expression %s not found",
+			calculation.LangRussian: "Выражение %s, на которое есть ссылка, не найдено",
+		},
+		MsgNoAnswer: {
+			calculation.LangEnglish: "No previous result to use as ans",
+			calculation.LangRussian: "Нет предыдущего результата для ans",
+		},
+		MsgReferenceFailed: {
+			calculation.LangEnglish: "Referenced expression %s failed: %s",
+			calculation.LangRussian: "Выражение %s, на которое есть ссылка, завершилось ошибкой: %s",
+		},
+		MsgReferenceLost: {
+			calculation.LangEnglish: "Referenced expression %s was not computed",
+			calculation.LangRussian: "Выражение %s, на которое есть ссылка, не было вычислено",
+		},
+		MsgInvalidSchedule: {
+			calculation.LangEnglish: "Specify either at or cron",
+			calculation.LangRussian: "Укажите либо at, либо cron",
+		},
+		MsgInvalidCron: {
+			calculation.LangEnglish: "Invalid cron schedule: %s",
+			calculation.LangRussian: "Некорректное расписание cron: %s",
+		},
+		MsgPastSchedule: {
+			calculation.LangEnglish: "Scheduled time must be in the future",
+			calculation.LangRussian: "Время запуска должно быть в будущем",
+		},
+		MsgScheduleNotFound: {
+			calculation.LangEnglish: "Schedule not found",
+			calculation.LangRussian: "Расписание не найдено",
+		},
+		MsgScheduleInactive: {
+			calculation.LangEnglish: "Schedule is no longer active",
+			calculation.LangRussian: "Расписание больше не активно",
+		},
+		MsgStep: {
+			calculation.LangEnglish: "Step %s: %s = %s, substituting into %s",
+			calculation.LangRussian: "Шаг %s: %s = %s, подставляем в %s",
+		},
+		MsgStepAnswer: {
+			calculation.LangEnglish: "Step %s: %s = %s, this is the answer",
+			calculation.LangRussian: "Шаг %s: %s = %s, это ответ",
+		},
+	},
+}
@@ -1,13 +1,20 @@
 package orchestrator
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
+	"google.golang.org/grpc/metadata"
 )
 
 func TestExpressionCalculateResult(t *testing.T) {
@@ -231,3 +238,1451 @@ func TestExpressionMultipleOperations(t *testing.T) {
 		t.Errorf("Expected status 'completed', got %s", expr.Status)
 	}
 }
+
+func TestExpressionRationalResult(t *testing.T) {
+	expr := NewExpression("test4", "user1", "1/3+1/6")
+	expr.Mode = calculation.ModeRational
+	tasksChan := make(chan *calculation.Task, 3)
+	expr.Start(tasksChan)
+
+	taskCount := 0
+	for task := range tasksChan {
+		result, err := calculation.Apply(task.Mode, task.Operation, task.Args[0], task.Args[1])
+		if err != nil {
+			t.Fatalf("Failed to compute task %s: %v", task.ID, err)
+		}
+		if !expr.UpdateTaskValue(task.ID, result) {
+			t.Fatalf("Failed to update task %s", task.ID)
+		}
+		taskCount++
+		if taskCount == len(expr.TaskOrder) {
+			break
+		}
+	}
+	close(tasksChan)
+
+	if expr.Fraction != "1/2" {
+		t.Errorf("Expected fraction 1/2, got %q", expr.Fraction)
+	}
+	if expr.Result != 0.5 {
+		t.Errorf("Expected result 0.5, got %f", expr.Result)
+	}
+	if expr.Status != "completed" {
+		t.Errorf("Expected status 'completed', got %s", expr.Status)
+	}
+}
+
+func TestExpressionIntegerRemainder(t *testing.T) {
+	expr := NewExpression("test5", "user1", "7/2+2^70")
+	expr.Mode = calculation.ModeInteger
+	tasksChan := make(chan *calculation.Task, 3)
+	expr.Start(tasksChan)
+
+	taskCount := 0
+	for task := range tasksChan {
+		result, err := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		if err != nil {
+			t.Fatalf("Failed to compute task %s: %v", task.ID, err)
+		}
+		if !expr.UpdateTaskValue(task.ID, result) {
+			t.Fatalf("Failed to update task %s", task.ID)
+		}
+		taskCount++
+		if taskCount == len(expr.TaskOrder) {
+			break
+		}
+	}
+	close(tasksChan)
+
+	if expr.Integer != "1180591620717411303427" {
+		t.Errorf("Expected integer 1180591620717411303427, got %q", expr.Integer)
+	}
+	if len(expr.Remainders) != 1 {
+		t.Fatalf("Expected 1 remainder, got %d", len(expr.Remainders))
+	}
+	if r := expr.Remainders[0]; r.Dividend != "7" || r.Divisor != "2" || r.Quotient != "3" || r.Remainder != "1" {
+		t.Errorf("Unexpected remainder %+v", r)
+	}
+	if expr.Status != "completed" {
+		t.Errorf("Expected status 'completed', got %s", expr.Status)
+	}
+}
+
+func TestExpressionIntervalResult(t *testing.T) {
+	expr := NewExpression("test-interval", "user1", "(2.5±0.1)*(2±0.5)")
+	expr.Mode = calculation.ModeInterval
+	tasksChan := make(chan *calculation.Task, 1)
+	expr.Start(tasksChan)
+
+	task := <-tasksChan
+	args := make([]calculation.Value, len(task.Args))
+	for i, arg := range taskToProto(task).Args {
+		value, err := ValueFromProto(arg)
+		if err != nil {
+			t.Fatalf("Failed to decode argument %d: %v", i, err)
+		}
+		args[i] = value
+	}
+	result, err := calculation.Apply(task.Mode, task.Operation, args...)
+	if err != nil {
+		t.Fatalf("Failed to compute task %s: %v", task.ID, err)
+	}
+	if !expr.UpdateTaskValue(task.ID, result) {
+		t.Fatalf("Failed to update task %s", task.ID)
+	}
+	close(tasksChan)
+
+	if expr.Status != "completed" {
+		t.Fatalf("Expected status 'completed', got %s", expr.Status)
+	}
+	// 2.4 и 2.6 не представимы точно, поэтому отрезок чуть шире [3.6, 6.5]
+	if expr.Interval == nil || expr.Interval.Lower > 3.6 || expr.Interval.Lower < 3.6-1e-12 || expr.Interval.Upper < 6.5 || expr.Interval.Upper > 6.5+1e-12 {
+		t.Errorf("Expected interval enclosing [3.6, 6.5], got %+v", expr.Interval)
+	}
+	if expr.Result != expr.Interval.Midpoint {
+		t.Errorf("Expected result to be the midpoint %f, got %f", expr.Interval.Midpoint, expr.Result)
+	}
+}
+
+func TestExpressionUnitResult(t *testing.T) {
+	// run вычисляет задачи выражения, передавая операнды через proto, как агент
+	run := func(expr *Expression) {
+		tasksChan := make(chan *calculation.Task, 4)
+		expr.Start(tasksChan)
+		for expr.Status == "pending" {
+			task := <-tasksChan
+			args := make([]calculation.Value, len(task.Args))
+			for i, arg := range taskToProto(task).Args {
+				value, err := ValueFromProto(arg)
+				if err != nil {
+					t.Fatalf("Failed to decode argument %d: %v", i, err)
+				}
+				args[i] = value
+			}
+			result, err := calculation.Apply(task.Mode, task.Operation, args...)
+			if err != nil {
+				expr.FailTask(task.ID, err)
+				continue
+			}
+			if !expr.UpdateTaskValue(task.ID, result) {
+				t.Fatalf("Failed to update task %s", task.ID)
+			}
+		}
+	}
+
+	expr := NewExpression("test-unit", "user1", "5 km / 2 h + 3 m/s")
+	expr.Mode = calculation.ModeRational
+	expr.Unit = "km/h"
+	run(expr)
+	if expr.Status != "completed" || expr.Fraction != "133/10" || expr.Unit != "km/h" {
+		t.Errorf("Expected 133/10 km/h, got %s %s %s (%s)", expr.Status, expr.Fraction, expr.Unit, expr.Error)
+	}
+
+	expr = NewExpression("test-si-unit", "user1", "10 N / 2 kg")
+	run(expr)
+	if expr.Status != "completed" || expr.Result != 5 || expr.Unit != "m/s^2" {
+		t.Errorf("Expected 5 m/s^2, got %s %f %s (%s)", expr.Status, expr.Result, expr.Unit, expr.Error)
+	}
+
+	expr = NewExpression("test-dimension", "user1", "3 m + 2 s")
+	run(expr)
+	if expr.Status != "error" || expr.Error != "несовместимые размерности: m и s" {
+		t.Errorf("Expected dimension mismatch, got %s %q", expr.Status, expr.Error)
+	}
+
+	// упрощение не должно отбрасывать величину, размерность которой несовместима
+	expr = NewExpression("test-dimension-simplify", "user1", "5 m * 0 + 3 s")
+	run(expr)
+	if expr.Status != "error" || expr.Error != "несовместимые размерности: m и s" {
+		t.Errorf("Expected dimension mismatch, got %s %q", expr.Status, expr.Error)
+	}
+}
+
+func TestExpressionConditionalSkipsBranch(t *testing.T) {
+	expr := NewExpression("test6", "user1", "if(2>1, 3*4, 5*6) + (0 ? 7 : 8)")
+	tasksChan := make(chan *calculation.Task, 4)
+	expr.Start(tasksChan)
+
+	var operations []string
+	for expr.Status == "pending" {
+		task := <-tasksChan
+		operations = append(operations, task.Operation)
+		result, err := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		if err != nil {
+			t.Fatalf("Failed to compute task %s: %v", task.ID, err)
+		}
+		if !expr.UpdateTaskValue(task.ID, result) {
+			t.Fatalf("Failed to update task %s", task.ID)
+		}
+	}
+
+	if expr.Result != 20 {
+		t.Errorf("Expected result 20, got %f", expr.Result)
+	}
+	if expr.Skipped != 1 {
+		t.Errorf("Expected 1 skipped task, got %d", expr.Skipped)
+	}
+	for _, op := range operations {
+		if op == "if" {
+			t.Errorf("Local if task was sent to agents")
+		}
+	}
+	if len(operations) != 3 {
+		t.Errorf("Expected 3 agent tasks (>, *, +), got %v", operations)
+	}
+}
+
+func TestExpressionSimplified(t *testing.T) {
+	expr := NewExpression("test7", "user1", "(2+3)*1 + 0*(4-1)")
+	tasksChan := make(chan *calculation.Task, 4)
+	expr.Start(tasksChan)
+
+	if expr.Simplified != "2 + 3" {
+		t.Errorf("Expected simplified '2 + 3', got %q", expr.Simplified)
+	}
+	if expr.Eliminated != 4 {
+		t.Errorf("Expected 4 eliminated tasks, got %d", expr.Eliminated)
+	}
+	if len(expr.TaskOrder) != 1 {
+		t.Fatalf("Expected 1 task, got %d", len(expr.TaskOrder))
+	}
+
+	expr.UpdateTaskResult(expr.TaskOrder[0], 5)
+	if expr.Status != "completed" || expr.Result != 5 {
+		t.Errorf("Expected completed with 5, got %s %f", expr.Status, expr.Result)
+	}
+
+	literal := NewExpression("test8", "user1", "2*3+1")
+	literal.Precompute = true
+	literal.Start(tasksChan)
+	if literal.Status != "completed" || literal.Result != 7 {
+		t.Errorf("Expected precomputed 7, got %s %f", literal.Status, literal.Result)
+	}
+}
+
+func TestExpressionSharedSubexpressions(t *testing.T) {
+	expr := NewExpression("test9", "user1", "(2+3)*(2+3)+(2+3)")
+	tasksChan := make(chan *calculation.Task, 4)
+	expr.Start(tasksChan)
+
+	if expr.Shared != 2 {
+		t.Errorf("Expected 2 shared tasks, got %d", expr.Shared)
+	}
+	sent := 0
+	for expr.Status == "pending" {
+		task := <-tasksChan
+		sent++
+		result, err := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		if err != nil {
+			t.Fatalf("Failed to compute task %s: %v", task.ID, err)
+		}
+		expr.UpdateTaskValue(task.ID, result)
+	}
+	if sent != 3 {
+		t.Errorf("Expected 3 agent tasks, got %d", sent)
+	}
+	if expr.Result != 30 {
+		t.Errorf("Expected result 30, got %f", expr.Result)
+	}
+}
+
+func TestServerHandleCalculateParseErrors(t *testing.T) {
+	srv := NewServer()
+	req, _ := http.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "2@3+(1"}`))
+	req.Header.Set("X-User-ID", "1")
+	rr := httptest.NewRecorder()
+	srv.handleCalculate(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	var resp struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Pos     int    `json:"pos"`
+			Len     int    `json:"len"`
+			Message string `json:"message"`
+			Snippet string `json:"snippet"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Errors) != 1 {
+		t.Fatalf("Expected 1 error, got %+v", resp.Errors)
+	}
+	e := resp.Errors[0]
+	if e.Code != "invalid_character" || e.Pos != 1 || e.Len != 1 || e.Snippet != "2@3+(1\n ^" {
+		t.Errorf("Unexpected error %+v", e)
+	}
+}
+
+func TestServerLocalizedErrors(t *testing.T) {
+	srv := NewServer()
+
+	req, _ := http.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "2+2", "mode": "octal"}`))
+	req.Header.Set("Accept-Language", "ru-RU,ru;q=0.9")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if body := strings.TrimSpace(rr.Body.String()); body != "Некорректный режим вычислений" {
+		t.Errorf("Expected Russian error, got %q", body)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "2+2", "mode": "octal"}`))
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if body := strings.TrimSpace(rr.Body.String()); body != "Invalid mode" {
+		t.Errorf("Expected English error by default, got %q", body)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "2+*2"}`))
+	req.Header.Set("Accept-Language", "en")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var resp struct {
+		Error  string `json:"error"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Error != "Invalid expression" || len(resp.Errors) != 1 || resp.Errors[0].Message != "invalid operator: *" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+}
+
+func TestExpressionLocalize(t *testing.T) {
+	expr := NewExpression("test10", "user1", "1/0")
+	expr.Mode = calculation.ModeRational
+	tasksChan := make(chan *calculation.Task, 1)
+	expr.Start(tasksChan)
+
+	task := <-tasksChan
+	_, err := calculation.Apply(task.Mode, task.Operation, task.Args...)
+	expr.FailTask(task.ID, err)
+
+	if expr.Error != "деление на ноль" {
+		t.Errorf("Expected Russian error, got %q", expr.Error)
+	}
+	if localized := expr.Localize(calculation.LangEnglish); localized.Error != "division by zero" {
+		t.Errorf("Expected English error, got %q", localized.Error)
+	}
+	if expr.Error != "деление на ноль" {
+		t.Errorf("Localize must not change the expression, got %q", expr.Error)
+	}
+}
+
+func TestServerHandleEvaluate(t *testing.T) {
+	srv := NewServer()
+
+	req, _ := http.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "1/3+1/6", "mode": "rational"}`))
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var resp struct {
+		Result   float64 `json:"result"`
+		Value    string  `json:"value"`
+		Fraction string  `json:"fraction"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Result != 0.5 || resp.Value != "1/2" || resp.Fraction != "1/2" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	if len(srv.tasks) != 0 {
+		t.Errorf("Expected no tasks for agents, got %d", len(srv.tasks))
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "1/0", "mode": "rational"}`))
+	req.Header.Set("Accept-Language", "en")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var errResp map[string]string
+	json.NewDecoder(rr.Body).Decode(&errResp)
+	if rr.Code != http.StatusUnprocessableEntity || errResp["error"] != "division by zero" {
+		t.Errorf("Expected division by zero, got %v %v", rr.Code, errResp)
+	}
+
+	long := strings.Repeat("1+", maxEvaluateOperations+1) + "1"
+	req, _ = http.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "`+long+`"}`))
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected too complex expression to be rejected, got %v", rr.Code)
+	}
+
+	for expression, code := range map[string]int{
+		"sum(1..10000000, k -> sum(1..10000000, j -> j))": http.StatusUnprocessableEntity,
+		"sum(1..10, k -> sum(1..k, j -> j))":              http.StatusUnprocessableEntity,
+		"sum(1..5, k -> sum(1..k, j -> j))":               http.StatusOK,
+		"sum(1..1000)":                                    http.StatusUnprocessableEntity,
+		"sum(1..20)":                                      http.StatusOK,
+	} {
+		req, _ = http.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "`+expression+`"}`))
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != code {
+			t.Errorf("%s: expected status %v, got %v: %s", expression, code, rr.Code, rr.Body.String())
+		}
+	}
+
+	// два действия, но результат не уместился бы в памяти
+	for _, body := range []string{
+		`{"expression": "(2^100000)^100000", "mode": "integer"}`,
+		`{"expression": "fact(10000)^100000", "mode": "integer"}`,
+		`{"expression": "(2^100000)^100000", "mode": "rational"}`,
+	} {
+		req, _ = http.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(body))
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "1048576") {
+			t.Errorf("%s: expected an oversized power to be rejected, got %v: %s", body, rr.Code, rr.Body.String())
+		}
+	}
+
+	req, _ = http.NewRequest("POST", "/api/v1/evaluate", strings.NewReader(`{"expression": "7/2 + 9/4", "mode": "integer"}`))
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var remResp struct {
+		Integer    string                  `json:"integer"`
+		Remainders []calculation.Remainder `json:"remainders"`
+	}
+	json.NewDecoder(rr.Body).Decode(&remResp)
+	want := []calculation.Remainder{
+		{Dividend: "7", Divisor: "2", Quotient: "3", Remainder: "1"},
+		{Dividend: "9", Divisor: "4", Quotient: "2", Remainder: "1"},
+	}
+	if rr.Code != http.StatusOK || remResp.Integer != "5" || !reflect.DeepEqual(remResp.Remainders, want) {
+		t.Errorf("Expected integer 5 with remainders %v, got %v %+v", want, rr.Code, remResp)
+	}
+}
+
+func TestServerHandleParse(t *testing.T) {
+	srv := NewServer()
+	for _, id := range []string{"worker-1", "worker-2"} {
+		srv.touchWorker(metadata.NewIncomingContext(context.Background(), metadata.Pairs(WorkerIDKey, id)))
+	}
+
+	req, _ := http.NewRequest("POST", "/api/v1/parse", strings.NewReader(`{"expression": "(2+3)*(4+5)"}`))
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var resp struct {
+		Tokens []struct {
+			Kind string `json:"kind"`
+			Text string `json:"text"`
+		} `json:"tokens"`
+		AST struct {
+			Op   string `json:"op"`
+			Args []struct {
+				Op string `json:"op"`
+			} `json:"args"`
+		} `json:"ast"`
+		Postfix []string `json:"postfix"`
+		Tasks   []struct {
+			ID        string   `json:"id"`
+			DependsOn []string `json:"depends_on"`
+		} `json:"tasks"`
+		Agents      int `json:"agents"`
+		EstimatedMS int `json:"estimated_ms"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Tokens) != 11 || resp.Tokens[0].Kind != "lparen" || resp.Tokens[1].Kind != "number" {
+		t.Errorf("Unexpected tokens %+v", resp.Tokens)
+	}
+	if resp.AST.Op != "*" || len(resp.AST.Args) != 2 || resp.AST.Args[0].Op != "+" {
+		t.Errorf("Unexpected AST %+v", resp.AST)
+	}
+	if strings.Join(resp.Postfix, " ") != "2 3 + 4 5 + *" {
+		t.Errorf("Unexpected postfix %v", resp.Postfix)
+	}
+	if len(resp.Tasks) != 3 || resp.Tasks[2].DependsOn[0] != resp.Tasks[0].ID || resp.Tasks[2].DependsOn[1] != resp.Tasks[1].ID {
+		t.Errorf("Unexpected tasks %+v", resp.Tasks)
+	}
+	if resp.Agents != 2 || resp.EstimatedMS != 3000 {
+		t.Errorf("Expected 2 agents and 3000 ms, got %d and %d", resp.Agents, resp.EstimatedMS)
+	}
+	if len(srv.tasks) != 0 || len(srv.expressions) != 0 {
+		t.Errorf("Parse must not enqueue anything")
+	}
+}
+
+func TestServerHandleFormat(t *testing.T) {
+	srv := NewServer()
+	expr := NewExpression("test11", "1", "(1+2)/3")
+	tasksChan := make(chan *calculation.Task, 2)
+	expr.Start(tasksChan)
+	for expr.Status == "pending" {
+		task := <-tasksChan
+		result, _ := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		expr.UpdateTaskValue(task.ID, result)
+	}
+	if expr.Canonical != "(1 + 2) / 3" || expr.LaTeX != `\frac{1 + 2}{3}` || expr.MathML == "" {
+		t.Errorf("Expected formatted completed expression, got %q %q %q", expr.Canonical, expr.LaTeX, expr.MathML)
+	}
+	srv.expressions[expr.ID] = expr
+
+	for _, body := range []string{`{"expression": "2*(6/3)"}`, `{"id": "test11"}`} {
+		req, _ := http.NewRequest("POST", "/api/v1/format", strings.NewReader(body))
+		req.Header.Set("X-User-ID", "1")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var resp map[string]string
+		json.NewDecoder(rr.Body).Decode(&resp)
+		if resp["canonical"] == "" || resp["latex"] == "" || !strings.HasPrefix(resp["mathml"], "<math") {
+			t.Errorf("Unexpected response %v", resp)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", "/api/v1/format", strings.NewReader(`{"expression": "2+"}`))
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for invalid expression, got %v", rr.Code)
+	}
+	req, _ = http.NewRequest("POST", "/api/v1/format", strings.NewReader(`{"id": "missing"}`))
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown expression, got %v", rr.Code)
+	}
+	req, _ = http.NewRequest("POST", "/api/v1/format", strings.NewReader(`{"id": "test11"}`))
+	req.Header.Set("X-User-ID", "2")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's expression, got %v", rr.Code)
+	}
+}
+
+func TestExpressionSteps(t *testing.T) {
+	expr := NewExpression("test12", "1", "(1-3)^2*5")
+	tasksChan := make(chan *calculation.Task, 3)
+	expr.Start(tasksChan)
+	for expr.Status == "pending" {
+		task := <-tasksChan
+		expr.StartTask(task.ID, "worker-1", time.Now())
+		result, _ := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		expr.UpdateTaskValue(task.ID, result)
+	}
+
+	want := []struct{ calculation, result, expression string }{
+		{"1 - 3", "-2", "(-2) ^ 2 * 5"},
+		{"(-2) ^ 2", "4", "4 * 5"},
+		{"4 * 5", "20", "20"},
+	}
+	if len(expr.Steps) != len(want) {
+		t.Fatalf("Expected %d steps, got %+v", len(want), expr.Steps)
+	}
+	for i, step := range expr.Steps {
+		if step.Number != i+1 || step.Calculation != want[i].calculation || step.Result != want[i].result || step.Expression != want[i].expression {
+			t.Errorf("Unexpected step %d: %+v", i+1, step)
+		}
+		if step.Agent != "worker-1" || step.Started == nil || step.Finished.Before(*step.Started) {
+			t.Errorf("Expected agent and timing in step %d: %+v", i+1, step)
+		}
+	}
+	if got := expr.Steps[0].Explain(calculation.LangEnglish, false); got != "Step 1: 1 - 3 = -2, substituting into (-2) ^ 2 * 5" {
+		t.Errorf("Unexpected explanation %q", got)
+	}
+}
+
+func TestServerHandleSteps(t *testing.T) {
+	srv := NewServer()
+	expr := NewExpression("test13", "1", "1 > 0 ? 2+3*4 : 5*6")
+	srv.expressions[expr.ID] = expr
+	expr.Start(srv.tasks)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(WorkerIDKey, "worker-1"))
+	for expr.Status == "pending" {
+		// следующая задача отправляется в канал асинхронно, агент опрашивает сервер
+		pt, err := srv.GetTask(ctx, &Empty{})
+		for i := 0; err != nil && i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+			pt, err = srv.GetTask(ctx, &Empty{})
+		}
+		if err != nil {
+			t.Fatalf("GetTask failed: %v", err)
+		}
+		task := expr.Tasks[pt.Id]
+		result, _ := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		expr.UpdateTaskValue(task.ID, result)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/expressions/test13/steps", nil)
+	req.Header.Set("X-User-ID", "1")
+	req.Header.Set("Accept-Language", "ru")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var resp struct {
+		Status string `json:"status"`
+		Steps  []Step `json:"steps"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	var texts []string
+	for _, step := range resp.Steps {
+		texts = append(texts, step.Text)
+		if step.Local == (step.Agent != "") || !step.Local && step.Agent != "worker-1" {
+			t.Errorf("Expected agent worker-1 for agent tasks only in step %+v", step)
+		}
+	}
+	want := []string{
+		"Шаг 1: 1 > 0 = 1, подставляем в if(1, 2 + 3 * 4, 5 * 6)",
+		"Шаг 2: 3 * 4 = 12, подставляем в if(1, 2 + 12, 5 * 6)",
+		"Шаг 3: 2 + 12 = 14, подставляем в if(1, 14, 5 * 6)",
+		"Шаг 4: if(1, 14, …) = 14, это ответ",
+	}
+	if resp.Status != "completed" || strings.Join(texts, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected steps %q with status %s", texts, resp.Status)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/expressions/missing/steps", nil)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown expression, got %v", rr.Code)
+	}
+	req, _ = http.NewRequest("GET", "/api/v1/expressions/test13/steps", nil)
+	req.Header.Set("X-User-ID", "2")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's expression, got %v", rr.Code)
+	}
+}
+
+func TestServerHandleDerivative(t *testing.T) {
+	srv := NewServer()
+	req, _ := http.NewRequest("POST", "/api/v1/derivative", strings.NewReader(`{"expression": "x^3 + 2*x", "at": 2}`))
+	req.Header.Set("X-User-ID", "1")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var resp map[string]string
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp["derivative"] != "3 * x ^ 2 + 2" || resp["latex"] != "3 \\cdot {x}^{2} + 2" {
+		t.Errorf("Unexpected derivative %v", resp)
+	}
+
+	srv.mu.Lock()
+	expr := srv.expressions[resp["id"]]
+	srv.mu.Unlock()
+	if expr == nil || expr.Variables["x"] != "2" {
+		t.Fatalf("Expected the derivative to be submitted at x = 2, got %+v", expr)
+	}
+	for {
+		srv.mu.Lock()
+		done := expr.Status != "pending"
+		srv.mu.Unlock()
+		if done {
+			break
+		}
+		task := <-srv.tasks
+		result, _ := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		srv.mu.Lock()
+		expr.UpdateTaskValue(task.ID, result)
+		srv.mu.Unlock()
+	}
+	if expr.Result != 14 || expr.Canonical != "3 * x ^ 2 + 2" {
+		t.Errorf("Expected 14 for 3 * x ^ 2 + 2, got %f for %s", expr.Result, expr.Canonical)
+	}
+
+	for _, body := range []string{`{"expression": "x!"}`, `{"expression": "x+1", "variable": "sqrt"}`, `{"expression": "x+y"}`} {
+		req, _ := http.NewRequest("POST", "/api/v1/derivative", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for %s, got %v", body, rr.Code)
+		}
+	}
+}
+
+func TestEquationSolvers(t *testing.T) {
+	evaluate := func(function string, x float64) (float64, error) {
+		tree, err := calculation.ParseWith(function, "x")
+		if err != nil {
+			return 0, err
+		}
+		v, err := calculation.Evaluate(tree.Substitute(map[string]string{"x": calculation.FloatValue(x).String()}), calculation.ModeFloat)
+		return v.Approx(), err
+	}
+	lower, upper, far := 0.0, 2.0, 3.0
+	tests := []struct {
+		method string
+		lower  *float64
+		upper  *float64
+		status string
+		err    string
+	}{
+		{MethodNewton, nil, nil, "converged", ""},
+		{MethodBisection, &lower, &upper, "converged", ""},
+		{MethodBisection, &upper, &far, "failed", "The function has the same sign at 2 and 3"},
+	}
+	for _, tt := range tests {
+		eq := &Equation{
+			Variable: "x", Method: tt.method, Status: "pending", Tolerance: 1e-12, MaxIterations: 100,
+			Initial: 1, Lower: tt.lower, Upper: tt.upper, function: "x ^ 2 - 2", derivative: "2 * x",
+		}
+		eq.run(&sync.Mutex{}, evaluate)
+		if eq.Status != tt.status || eq.Error != tt.err {
+			t.Errorf("%s: expected %s %q, got %s %q", tt.method, tt.status, tt.err, eq.Status, eq.Error)
+		}
+		if tt.status == "converged" && (math.Abs(eq.Result-math.Sqrt2) > 1e-9 || eq.Iterations == 0) {
+			t.Errorf("%s: expected sqrt(2), got %v after %d iterations", tt.method, eq.Result, eq.Iterations)
+		}
+	}
+
+	eq := &Equation{Variable: "x", Method: MethodNewton, Tolerance: 1e-12, MaxIterations: 3, Initial: 100, function: "x ^ 2 - 2", derivative: "2 * x"}
+	eq.run(&sync.Mutex{}, evaluate)
+	if eq.Status != "failed" || eq.Localize(calculation.LangRussian).Error != "Нет сходимости за 3 итераций" {
+		t.Errorf("Expected failure after the iteration limit, got %s %q", eq.Status, eq.Error)
+	}
+}
+
+func TestServerHandleSolve(t *testing.T) {
+	srv := NewServer()
+	solve := func(body string) (int, Equation) {
+		req, _ := http.NewRequest("POST", "/api/v1/equations", strings.NewReader(body))
+		req.Header.Set("X-User-ID", "1")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			return rr.Code, Equation{}
+		}
+		var created map[string]string
+		json.NewDecoder(rr.Body).Decode(&created)
+		srv.mu.Lock()
+		eq := srv.equations[created["id"]]
+		srv.mu.Unlock()
+		// агенты: вычисляем задачи, пока уравнение решается
+		for {
+			srv.mu.Lock()
+			done := eq.Status != "pending"
+			srv.mu.Unlock()
+			if done {
+				break
+			}
+			select {
+			case task := <-srv.tasks:
+				result, _ := calculation.Apply(task.Mode, task.Operation, task.Args...)
+				srv.mu.Lock()
+				for _, expr := range srv.expressions {
+					if expr.Owns(task.ID) {
+						expr.UpdateTaskValue(task.ID, result)
+					}
+				}
+				srv.mu.Unlock()
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		req, _ = http.NewRequest("GET", "/api/v1/equations/"+created["id"], nil)
+		req.Header.Set("X-User-ID", "1")
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		var resp map[string]Equation
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp["equation"]
+	}
+
+	code, eq := solve(`{"equation": "2*x + 3 = 11"}`)
+	if code != http.StatusOK || eq.Status != "converged" || eq.Method != MethodLinear || eq.Solution != "4" {
+		t.Errorf("Expected x = 4 by the linear method, got %d %+v", code, eq)
+	}
+	code, eq = solve(`{"equation": "x^2 = 2", "initial": 1, "tolerance": 1e-10}`)
+	if code != http.StatusOK || eq.Status != "converged" || eq.Method != MethodNewton || math.Abs(eq.Result-math.Sqrt2) > 1e-9 || len(eq.Evaluations) == 0 {
+		t.Errorf("Expected sqrt(2) by Newton's method, got %d %+v", code, eq)
+	}
+	code, eq = solve(`{"equation": "x*x*x = 0 - 8", "lower": -5, "upper": 0, "max_iterations": 100}`)
+	if code != http.StatusOK || eq.Status != "converged" || eq.Method != MethodBisection || math.Abs(eq.Result+2) > 1e-6 {
+		t.Errorf("Expected -2 by bisection, got %d %+v", code, eq)
+	}
+
+	for _, body := range []string{
+		`{"equation": "2*x + 3"}`,
+		`{"equation": "x = 1", "method": "secant"}`,
+		`{"equation": "x^2 = 1", "method": "bisection"}`,
+		`{"equation": "x^2 = 1", "tolerance": 0}`,
+		`{"equation": "x^2 = 1", "max_iterations": 100000}`,
+		`{"equation": "x^2 = 2", "mode": "rational"}`,
+		`{"equation": "x = 1", "method": "newton", "mode": "integer"}`,
+	} {
+		if code, _ := solve(body); code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for %s, got %v", body, code)
+		}
+	}
+	code, eq = solve(`{"equation": "3*x = 1", "mode": "rational"}`)
+	if code != http.StatusOK || eq.Solution != "1/3" {
+		t.Errorf("Expected x = 1/3 in rational mode, got %d %+v", code, eq)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/equations/"+eq.ID, nil)
+	req.Header.Set("X-User-ID", "2")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's equation, got %v", rr.Code)
+	}
+}
+
+func TestServerCalculateBatch(t *testing.T) {
+	srv := NewServer()
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-User-ID", "1")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	var created struct {
+		ID       string
+		Accepted int
+		Rejected int
+		Items    []BatchItem
+	}
+	rr := request("POST", "/api/v1/calculate/batch", `["2+2", {"expression": "1/3+1/6", "mode": "rational"}, "2+*2", {"expression": "1", "mode": "octal"}, 5, "7"]`)
+	json.NewDecoder(rr.Body).Decode(&created)
+	if rr.Code != http.StatusCreated || created.Accepted != 3 || created.Rejected != 3 || len(created.Items) != 6 {
+		t.Fatalf("Expected 3 accepted and 3 rejected items, got %v %+v", rr.Code, created)
+	}
+	if items := created.Items; items[0].ID == "" || items[2].Error != "Invalid expression" || len(items[2].Errors) != 1 ||
+		items[3].Error != "Invalid mode" || items[4].Error != "Invalid request body" || items[5].ID == "" {
+		t.Errorf("Unexpected items %+v", items)
+	}
+
+	batch := func(id string) Batch {
+		var resp map[string]Batch
+		json.NewDecoder(request("GET", "/api/v1/batches/"+id, "").Body).Decode(&resp)
+		return resp["batch"]
+	}
+	// "7" вычислено сразу, остальные ждут агентов
+	if b := batch(created.ID); b.Status != "pending" || b.Pending != 2 || b.Completed != 1 || b.Total != 6 {
+		t.Errorf("Expected a pending batch, got %+v", b)
+	}
+	for batch(created.ID).Status == "pending" {
+		task := <-srv.tasks
+		result, _ := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		if _, err := srv.SendResult(context.Background(), &Result{Id: task.ID, Value: ValueToProto(result)}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if b := batch(created.ID); b.Status != "completed" || b.Completed != 3 || b.Progress != 1 {
+		t.Errorf("Expected a completed batch, got %+v", b)
+	}
+	srv.mu.Lock()
+	expr := srv.expressions[created.Items[1].ID]
+	srv.mu.Unlock()
+	if expr.Fraction != "1/2" || expr.Parent != created.ID {
+		t.Errorf("Expected 1/2 in the batch, got %+v", expr)
+	}
+
+	rr = request("POST", "/api/v1/calculate/batch", "{\"expression\": \"3*3\"}\n\n\"4-1\"\n")
+	json.NewDecoder(rr.Body).Decode(&created)
+	if rr.Code != http.StatusCreated || created.Accepted != 2 {
+		t.Errorf("Expected an NDJSON batch of 2, got %v %+v", rr.Code, created)
+	}
+
+	many := "[" + strings.Repeat(`"1",`, maxBatchItems) + `"1"]`
+	if rr := request("POST", "/api/v1/calculate/batch", many); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %v", rr.Code)
+	}
+	for _, body := range []string{"[]", "{not json}", ""} {
+		if rr := request("POST", "/api/v1/calculate/batch", body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for %q, got %v", body, rr.Code)
+		}
+	}
+	if rr := request("GET", "/api/v1/batches/batch-missing", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", rr.Code)
+	}
+
+	// элемент, который не удалось записать в базу, отклоняется, остальные отправляются
+	srv.db.Exec("CREATE TRIGGER IF NOT EXISTS batch_failure BEFORE INSERT ON expressions WHEN NEW.expression = '6*7' BEGIN SELECT RAISE(FAIL, 'batch failure'); END")
+	rr = request("POST", "/api/v1/calculate/batch", `["1+1", "6*7", "2+2"]`)
+	srv.db.Exec("DROP TRIGGER batch_failure")
+	created.Items = nil
+	json.NewDecoder(rr.Body).Decode(&created)
+	if rr.Code != http.StatusCreated || created.Accepted != 2 || created.Rejected != 1 || len(created.Items) != 3 {
+		t.Fatalf("Expected 2 accepted and 1 rejected items, got %v %+v", rr.Code, created)
+	}
+	if items := created.Items; items[0].ID == "" || items[1].ID != "" || items[1].Error != "Internal server error" || items[2].ID == "" {
+		t.Errorf("Unexpected items %+v", items)
+	}
+	if b := batch(created.ID); b.Accepted != 2 || b.Rejected != 1 || b.Items[1].Error != "Internal server error" {
+		t.Errorf("Expected the stored batch to reject the item, got %+v", b)
+	}
+}
+
+func TestServerReferences(t *testing.T) {
+	srv := NewServer()
+	// ans зависит от выражений, сохранённых в базе прошлыми запусками
+	srv.db.Exec("DELETE FROM expressions WHERE user_id = 49")
+	request := func(userID, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body))
+		req.Header.Set("X-User-ID", userID)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	submit := func(body string) string {
+		rr := request("49", body)
+		var created map[string]string
+		json.NewDecoder(rr.Body).Decode(&created)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected 201 for %s, got %v", body, rr.Code)
+		}
+		return created["id"]
+	}
+	// агенты: вычисляем задачи, пока выражение не вычислено
+	wait := func(id string) Expression {
+		for {
+			srv.mu.Lock()
+			expr := *srv.expressions[id]
+			srv.mu.Unlock()
+			if expr.Status != "pending" {
+				return expr
+			}
+			select {
+			case task := <-srv.tasks:
+				result, err := calculation.Apply(task.Mode, task.Operation, task.Args...)
+				srv.mu.Lock()
+				for _, expr := range srv.expressions {
+					if !expr.Owns(task.ID) {
+						continue
+					}
+					if err != nil {
+						expr.FailTask(task.ID, err)
+					} else {
+						expr.UpdateTaskValue(task.ID, result)
+					}
+				}
+				srv.mu.Unlock()
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	if rr := request("49", `{"expression": "ans + 1"}`); rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "No previous result") {
+		t.Errorf("Expected 422 without a previous result, got %v: %s", rr.Code, rr.Body.String())
+	}
+	first := submit(`{"expression": "2+3"}`)
+	second := submit(`{"expression": "{` + first + `} * 2"}`)
+	third := submit(`{"expression": "ans - 1"}`)
+	// "ans - 1" ссылается на "{first} * 2", которое ждёт "2+3"
+	if expr := wait(third); expr.Status != "completed" || expr.Result != 9 || expr.Variables["ans"] != "10" || expr.Canonical != "ans - 1" {
+		t.Errorf("Expected 9, got %+v", expr)
+	}
+	if expr := wait(second); expr.Result != 10 || expr.Canonical != "{"+first+"} * 2" {
+		t.Errorf("Expected 10, got %+v", expr)
+	}
+
+	if rr := request("50", `{"expression": "{`+first+`} + 1"}`); rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "not found") {
+		t.Errorf("Expected 422 for another user's expression, got %v: %s", rr.Code, rr.Body.String())
+	}
+	failed := submit(`{"expression": "1/0"}`)
+	if expr := wait(submit(`{"expression": "{` + failed + `} + 1"}`)); expr.Status != "error" || !strings.Contains(expr.Error, failed+" failed") {
+		t.Errorf("Expected the reference error to propagate, got %+v", expr)
+	}
+
+	// подставляется точное значение, а не его запись: запись "3+2i" или "[2, 4]"
+	// не разбирается как литерал; ссылки на выражения только из базы - тоже
+	for _, tt := range []struct {
+		name, referenced, referencing, expected string
+	}{
+		{"complex", `{"expression": "sqrt(0-4) + 3"}`, `{"expression": "{ref} * 2"}`, "6+4i"},
+		{"array", `{"expression": "[1, 2] * 2", "mode": "integer"}`, `{"expression": "{ref} * 3", "mode": "integer"}`, "[6, 12]"},
+		{"interval", `{"expression": "5±0.25", "mode": "interval"}`, `{"expression": "{ref} * 2", "mode": "interval"}`, "[9.5, 10.5]"},
+		{"rational", `{"expression": "1/3", "mode": "rational"}`, `{"expression": "{ref} * 3"}`, "1"},
+	} {
+		ref := submit(tt.referenced)
+		wait(ref)
+		for _, stored := range []bool{false, true} {
+			if stored {
+				// агенты теста не сохраняют результаты в базу
+				srv.mu.Lock()
+				if err := srv.saveExpression(srv.expressions[ref]); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				delete(srv.expressions, ref)
+				srv.mu.Unlock()
+			}
+			expr := wait(submit(strings.ReplaceAll(tt.referencing, "{ref}", "{"+ref+"}")))
+			if expr.Status != "completed" || expr.value.String() != tt.expected {
+				t.Errorf("%s (stored %v): expected %s, got %s %+v", tt.name, stored, tt.expected, expr.value, expr)
+			}
+		}
+	}
+}
+
+func TestCronSchedule(t *testing.T) {
+	saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	for spec, expected := range map[string]time.Time{
+		"*/15 9-17 * * 1-5": time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+		"30 2 * * 7":        time.Date(2026, 10, 18, 2, 30, 0, 0, time.UTC),
+		"0 0 1,15 * 0":      time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+		"5/20 12 17 10 *":   time.Date(2026, 10, 17, 12, 5, 0, 0, time.UTC),
+		"0 0 29 2 *":        time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+	} {
+		cron, err := parseCron(spec)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", spec, err)
+			continue
+		}
+		if next := cron.Next(saturday); !next.Equal(expected) {
+			t.Errorf("Expected %v for %q, got %v", expected, spec, next)
+		}
+	}
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+	if cron, _ := parseCron("0 0 30 2 *"); !cron.Next(saturday).IsZero() {
+		t.Error("Expected no runs on February 30")
+	}
+}
+
+func TestServerSchedule(t *testing.T) {
+	srv := NewServer()
+	// runDue запускает все наступившие расписания, в том числе оставшиеся от прошлых запусков тестов
+	srv.db.Exec("DELETE FROM schedules")
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-User-ID", "1")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	schedule := func(id string) Schedule {
+		var resp map[string]Schedule
+		json.NewDecoder(request("GET", "/api/v1/schedules/"+id, "").Body).Decode(&resp)
+		return resp["schedule"]
+	}
+	now := time.Now()
+	at := now.Add(time.Hour).Format(time.RFC3339)
+	for _, body := range []string{
+		`{"expression": "1"}`,
+		`{"expression": "1", "at": "` + at + `", "cron": "* * * * *"}`,
+		`{"expression": "1", "at": "` + now.Add(-time.Hour).Format(time.RFC3339) + `"}`,
+		`{"expression": "1", "cron": "61 * * * *"}`,
+		`{"expression": "1", "cron": "0 0 30 2 *"}`,
+		`{"expression": "2+*2", "at": "` + at + `"}`,
+	} {
+		if rr := request("POST", "/api/v1/schedules", body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for %s, got %v", body, rr.Code)
+		}
+	}
+
+	// однократный запуск: выражение вычисляют агенты
+	rr := request("POST", "/api/v1/schedules", `{"expression": "6*7", "at": "`+at+`"}`)
+	var created struct{ ID string }
+	json.NewDecoder(rr.Body).Decode(&created)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %v: %s", rr.Code, rr.Body.String())
+	}
+	srv.runDue(now)
+	if sc := schedule(created.ID); sc.Status != "active" || len(sc.Runs) != 0 {
+		t.Errorf("Expected no runs before the scheduled time, got %+v", sc)
+	}
+	srv.runDue(now.Add(2 * time.Hour))
+	task := <-srv.tasks
+	result, _ := calculation.Apply(task.Mode, task.Operation, task.Args...)
+	if _, err := srv.SendResult(context.Background(), &Result{Id: task.ID, Value: ValueToProto(result)}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sc := schedule(created.ID)
+	if sc.Status != "finished" || sc.Next != nil || len(sc.Runs) != 1 || sc.Runs[0].Status != "completed" || sc.Runs[0].Result != 42 {
+		t.Fatalf("Expected a finished schedule with one run, got %+v", sc)
+	}
+	srv.mu.Lock()
+	expr := srv.expressions[sc.Runs[0].ExpressionID]
+	srv.mu.Unlock()
+	if expr.Parent != created.ID {
+		t.Errorf("Expected the run to belong to the schedule, got %+v", expr)
+	}
+
+	// повторные запуски: каждый запуск назначает следующий, отмена останавливает их
+	rr = request("POST", "/api/v1/schedules", `{"expression": "7", "cron": "0 0 1 1 *"}`)
+	json.NewDecoder(rr.Body).Decode(&created)
+	first := *schedule(created.ID).Next
+	if first.Month() != time.January || first.Day() != 1 || !first.After(now) {
+		t.Fatalf("Unexpected next run %v", first)
+	}
+	srv.runDue(first)
+	srv.runDue(first)
+	sc = schedule(created.ID)
+	if sc.Status != "active" || len(sc.Runs) != 1 || sc.Runs[0].Value != "7" || !sc.Next.Equal(first.AddDate(1, 0, 0)) {
+		t.Errorf("Expected one run and the next in a year, got %+v", sc)
+	}
+	if rr := request("DELETE", "/api/v1/schedules/"+created.ID, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %v", rr.Code)
+	}
+	if rr := request("DELETE", "/api/v1/schedules/"+created.ID, ""); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409, got %v", rr.Code)
+	}
+	srv.runDue(first.AddDate(2, 0, 0))
+	if sc := schedule(created.ID); sc.Status != "cancelled" || sc.Next != nil || len(sc.Runs) != 1 {
+		t.Errorf("Expected a cancelled schedule, got %+v", sc)
+	}
+	if rr := request("GET", "/api/v1/schedules/schedule-missing", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", rr.Code)
+	}
+}
+
+func TestServerSweep(t *testing.T) {
+	srv := NewServer()
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-User-ID", "1")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	// агенты: вычисляем задачи, пока не вычислены все точки перебора
+	sweep := func(body string) Sweep {
+		rr := request("POST", "/api/v1/sweeps", body)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %v: %s", rr.Code, rr.Body.String())
+		}
+		var created struct{ ID string }
+		json.NewDecoder(rr.Body).Decode(&created)
+		for {
+			var resp map[string]Sweep
+			json.NewDecoder(request("GET", "/api/v1/sweeps/"+created.ID, "").Body).Decode(&resp)
+			if resp["sweep"].Status != "pending" {
+				return resp["sweep"]
+			}
+			select {
+			case task := <-srv.tasks:
+				result, err := calculation.Apply(task.Mode, task.Operation, task.Args...)
+				srv.mu.Lock()
+				for _, expr := range srv.expressions {
+					if !expr.Owns(task.ID) {
+						continue
+					}
+					if err != nil {
+						expr.FailTask(task.ID, err)
+					} else {
+						expr.UpdateTaskValue(task.ID, result)
+					}
+				}
+				srv.mu.Unlock()
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	sw := sweep(`{"expression": "p*(1+r)^n", "parameters": {"p": [1000], "r": {"from": 0.01, "to": 0.03, "step": 0.01}, "n": [1, 2]}}`)
+	if sw.Total != 6 || sw.Completed != 6 || sw.Progress != 1 || strings.Join(sw.Parameters, ",") != "n,p,r" {
+		t.Fatalf("Unexpected sweep %+v", sw)
+	}
+	if first, last := sw.Rows[0], sw.Rows[5]; strings.Join(first.Values, " ") != "1 1000 0.01" || first.Result != 1010 ||
+		strings.Join(last.Values, " ") != "2 1000 0.03" || math.Abs(last.Result-1060.9) > 1e-9 {
+		t.Errorf("Unexpected rows %+v and %+v", first, last)
+	}
+	srv.mu.Lock()
+	parent := srv.expressions[sw.Rows[0].ExpressionID].Parent
+	srv.mu.Unlock()
+	if parent != sw.ID {
+		t.Errorf("Expected the point expression to belong to %s, got %q", sw.ID, parent)
+	}
+	rr := request("GET", "/api/v1/sweeps/"+sw.ID+"/csv", "")
+	lines := strings.Split(rr.Body.String(), "\n")
+	if rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" || len(lines) != 8 ||
+		lines[0] != "n,p,r,result,value,status,error,expression_id" || !strings.HasPrefix(lines[1], "1,1000,0.01,1010,1010,completed,,expr-") {
+		t.Errorf("Unexpected CSV %q", rr.Body.String())
+	}
+
+	sw = sweep(`{"expression": "1/(x - 2)", "mode": "rational", "parameters": {"x": {"from": 1, "to": 3, "step": 0.5}}}`)
+	if sw.Completed != 4 || sw.Failed != 1 || sw.Rows[2].Status != "error" || sw.Rows[1].Value != "-2" || sw.Rows[4].Value != "1" {
+		t.Errorf("Expected one failed point at x = 2, got %+v", sw)
+	}
+
+	for _, body := range []string{
+		`{"expression": "x + 1", "parameters": {}}`,
+		`{"expression": "x + 1", "parameters": {"x": {"from": 0, "to": 1, "step": 0}}}`,
+		`{"expression": "x + 1", "parameters": {"x": {"from": 0, "to": 1, "step": 0.00001}}}`,
+		`{"expression": "x + y", "parameters": {"x": {"from": 1, "to": 100, "step": 1}, "y": {"from": 1, "to": 101, "step": 1}}}`,
+		`{"expression": "x + y", "parameters": {"x": [1, 2]}}`,
+		`{"expression": "x + 1", "mode": "integer", "parameters": {"x": [0.5]}}`,
+	} {
+		if rr := request("POST", "/api/v1/sweeps", body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for %s, got %v", body, rr.Code)
+		}
+	}
+	if rr := request("GET", "/api/v1/sweeps/sweep-missing", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", rr.Code)
+	}
+}
+
+func TestServerSheet(t *testing.T) {
+	srv := NewServer()
+	request := func(method, url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-User-ID", "1")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	// агенты: вычисляем задачи, пока в таблице есть ячейки для пересчёта
+	var computed []string
+	recalculate := func(sheet *Sheet) {
+		for {
+			srv.mu.Lock()
+			idle := sheet.Idle()
+			srv.mu.Unlock()
+			if idle {
+				return
+			}
+			select {
+			case task := <-srv.tasks:
+				result, _ := calculation.Apply(task.Mode, task.Operation, task.Args...)
+				srv.mu.Lock()
+				for _, expr := range srv.expressions {
+					if expr.Owns(task.ID) {
+						computed = append(computed, expr.Expr)
+						expr.UpdateTaskValue(task.ID, result)
+					}
+				}
+				srv.mu.Unlock()
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	cells := func(sheet *Sheet) string {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		var values []string
+		for _, name := range []string{"A1", "B1", "C1", "D1"} {
+			values = append(values, name+"="+sheet.Cells[name].Value)
+		}
+		return strings.Join(values, " ")
+	}
+
+	rr := request("POST", "/api/v1/sheets", `{"cells": {"A1": "10", "B1": "A1*2", "C1": "B1 + A1", "D1": "5*2"}}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %v: %s", rr.Code, rr.Body.String())
+	}
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+	srv.mu.Lock()
+	sheet := srv.sheets[created["id"]]
+	srv.mu.Unlock()
+	recalculate(sheet)
+	if got := cells(sheet); got != "A1=10 B1=20 C1=30 D1=10" {
+		t.Errorf("Unexpected cells %s", got)
+	}
+
+	computed = nil
+	rr = request("PUT", "/api/v1/sheets/"+sheet.ID+"/cells/A1", `{"expression": "3"}`)
+	var updated struct {
+		Version       int
+		Recalculating []string
+	}
+	json.NewDecoder(rr.Body).Decode(&updated)
+	if rr.Code != http.StatusOK || strings.Join(updated.Recalculating, ",") != "A1,B1,C1" {
+		t.Fatalf("Expected A1, B1 and C1 to be recalculated, got %v %+v", rr.Code, updated)
+	}
+	recalculate(sheet)
+	if got := cells(sheet); got != "A1=3 B1=6 C1=9 D1=10" || strings.Join(computed, ",") != "A1*2,B1 + A1" {
+		t.Errorf("Unexpected cells %s after computing %v", got, computed)
+	}
+	rr = request("GET", fmt.Sprintf("/api/v1/sheets/%s/changes?since=%d", sheet.ID, updated.Version), "")
+	var feed struct {
+		Version int
+		Changes []Change
+	}
+	json.NewDecoder(rr.Body).Decode(&feed)
+	if len(feed.Changes) != 3 || feed.Changes[0].Cell != "A1" || feed.Changes[2].Cell != "C1" || feed.Changes[2].Value != "9" || feed.Version != updated.Version+3 {
+		t.Errorf("Unexpected change feed %+v", feed)
+	}
+
+	rr = request("PUT", "/api/v1/sheets/"+sheet.ID+"/cells/A1", `{"expression": "C1 - 1"}`)
+	var failed map[string]string
+	json.NewDecoder(rr.Body).Decode(&failed)
+	if rr.Code != http.StatusUnprocessableEntity || failed["error"] != "Circular reference: A1 -> C1 -> B1 -> A1" {
+		t.Errorf("Expected a circular reference, got %v %v", rr.Code, failed)
+	}
+	request("PUT", "/api/v1/sheets/"+sheet.ID+"/cells/B1", `{"expression": "Z9 + 1"}`)
+	recalculate(sheet)
+	req, _ := http.NewRequest("GET", "/api/v1/sheets/"+sheet.ID, nil)
+	req.Header.Set("X-User-ID", "1")
+	req.Header.Set("Accept-Language", "ru")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var resp map[string]Sheet
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if b1, c1 := resp["sheet"].Cells["B1"], resp["sheet"].Cells["C1"]; b1.Error != "Ячейка Z9 пуста" || c1.Error != "Ячейка B1 содержит ошибку" {
+		t.Errorf("Expected errors in B1 and C1, got %+v %+v", b1, c1)
+	}
+
+	if rr := request("PUT", "/api/v1/sheets/"+sheet.ID+"/cells/b1", `{"expression": "1"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an invalid cell name, got %v", rr.Code)
+	}
+	if rr := request("GET", "/api/v1/sheets/sheet-missing", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", rr.Code)
+	}
+
+	// ячейки получают точные значения ссылок, а не их запись
+	for _, tt := range []struct {
+		mode, cells, expected string
+	}{
+		{"interval", `{"A1": "1±3", "B1": "A1 * 2", "C1": "0 - 5±1", "D1": "C1 * 2"}`, "A1=[-2, 4] B1=[-4, 8] C1=[-6, -4] D1=[-12, -8]"},
+		{"float", `{"A1": "sqrt(0-4) - 3", "B1": "A1 * 2", "C1": "1/3", "D1": "C1 * 3"}`, "A1=-3+2i B1=-6+4i C1=0.3333333333333333 D1=1"},
+		{"integer", `{"A1": "[2, 4] * 3", "B1": "A1 + 1", "C1": "[[1, 2], [3, 4]] * 2", "D1": "transpose(C1)"}`, "A1=[6, 12] B1=[7, 13] C1=[[2, 4], [6, 8]] D1=[[2, 6], [4, 8]]"},
+	} {
+		rr := request("POST", "/api/v1/sheets", `{"mode": "`+tt.mode+`", "cells": `+tt.cells+`}`)
+		json.NewDecoder(rr.Body).Decode(&created)
+		srv.mu.Lock()
+		sheet := srv.sheets[created["id"]]
+		srv.mu.Unlock()
+		if sheet == nil {
+			t.Fatalf("%s: expected a sheet, got %v: %s", tt.mode, rr.Code, rr.Body.String())
+		}
+		recalculate(sheet)
+		if got := cells(sheet); got != tt.expected {
+			t.Errorf("%s: expected cells %s, got %s", tt.mode, tt.expected, got)
+		}
+	}
+
+	// точные значения сохраняются в базе вместе с таблицей
+	srv.mu.Lock()
+	delete(srv.sheets, created["id"])
+	srv.mu.Unlock()
+	request("PUT", "/api/v1/sheets/"+created["id"]+"/cells/D1", `{"expression": "transpose(C1) - C1"}`)
+	srv.mu.Lock()
+	sheet = srv.sheets[created["id"]]
+	srv.mu.Unlock()
+	recalculate(sheet)
+	if got := cells(sheet); got != "A1=[6, 12] B1=[7, 13] C1=[[2, 4], [6, 8]] D1=[[0, 2], [-2, 0]]" {
+		t.Errorf("Unexpected cells after reloading the sheet: %s", got)
+	}
+}
+
+func TestExpressionMatmulBlocks(t *testing.T) {
+	rows := make([]string, 40)
+	for i := range rows {
+		rows[i] = fmt.Sprintf("[%d, 1]", i)
+	}
+	expr := NewExpression("test14", "1", "matmul(["+strings.Join(rows, ", ")+"], [[1, 0], [1, 2]])")
+	expr.Mode = calculation.ModeInteger
+	tasksChan := make(chan *calculation.Task, 10)
+	expr.Start(tasksChan)
+	var blocks []string
+	for expr.Status == "pending" {
+		task := <-tasksChan
+		if task.Operation != "matmul" || task.Args[0].Rows > 16 {
+			t.Fatalf("Expected a matmul block of at most 16 rows, got %s %v", task.Operation, task.Args)
+		}
+		blocks = append(blocks, task.ID)
+		result, err := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expr.UpdateTaskValue(task.ID, result)
+	}
+	if len(blocks) != 3 || expr.Status != "completed" {
+		t.Fatalf("Expected 3 block tasks and a completed expression, got %v %s %s", blocks, expr.Status, expr.Error)
+	}
+	if !strings.HasPrefix(expr.Array, "[[1, 2], [2, 2], [3, 2]") || !strings.HasSuffix(expr.Array, "[40, 2]]") {
+		t.Errorf("Unexpected result %s", expr.Array)
+	}
+	last := expr.Steps[len(expr.Steps)-1]
+	if last.Operation != "matmul" || !last.Local || last.Result != expr.Array {
+		t.Errorf("Expected the blocks to be assembled locally, got %+v", last)
+	}
+}
+
+func TestExpressionScript(t *testing.T) {
+	expr := NewExpression("test-script", "1", "a = 2; b = a * 3; c = (a + b)^2; c")
+	tasksChan := make(chan *calculation.Task, 10)
+	expr.Start(tasksChan)
+	var operations []string
+	for expr.Status == "pending" {
+		task := <-tasksChan
+		operations = append(operations, task.Operation)
+		result, err := calculation.Apply(task.Mode, task.Operation, task.Args...)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expr.UpdateTaskValue(task.ID, result)
+	}
+	if strings.Join(operations, " ") != "* + ^" {
+		t.Errorf("Expected tasks * + ^ in dependency order, got %v", operations)
+	}
+	if expr.Status != "completed" || expr.Result != 64 {
+		t.Fatalf("Expected 64, got %s %f %s", expr.Status, expr.Result, expr.Error)
+	}
+	expected := []Assignment{{"a", "2", 2}, {"b", "6", 6}, {"c", "64", 64}}
+	if !reflect.DeepEqual(expr.Assignments, expected) {
+		t.Errorf("Expected assignments %+v, got %+v", expected, expr.Assignments)
+	}
+	if expr.Canonical != "a = 2; b = a * 3; c = (a + b) ^ 2; c" {
+		t.Errorf("Unexpected canonical form %q", expr.Canonical)
+	}
+
+	literal := NewExpression("test-script-literal", "1", "x = 4; y = x")
+	literal.Start(tasksChan)
+	if literal.Status != "completed" || literal.Result != 4 || len(literal.Assignments) != 2 {
+		t.Errorf("Expected 4 with two assignments, got %s %f %+v", literal.Status, literal.Result, literal.Assignments)
+	}
+}
+
+func TestExpressionRangeReduction(t *testing.T) {
+	expr := NewExpression("test15", "1", "sum(1..25000, k -> k^2) + 1")
+	expr.Mode = calculation.ModeInteger
+	tasksChan := make(chan *calculation.Task, 10)
+	expr.Start(tasksChan)
+	var chunks, merges int
+	for expr.Status == "pending" {
+		task := <-tasksChan
+		var result calculation.Value
+		var err error
+		switch {
+		case calculation.IsRangeOp(task.Operation):
+			chunks++
+			result, err = calculation.PartialRange(task.Mode, task.Operation, task.Body, task.Args[0], task.Args[1])
+		case task.Operation == calculation.MergeOp("sum"):
+			merges++
+			result, err = calculation.Apply(task.Mode, task.Operation, task.Args...)
+		default:
+			result, err = calculation.Apply(task.Mode, task.Operation, task.Args...)
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expr.UpdateTaskValue(task.ID, result)
+	}
+	if chunks != 3 || merges != 2 {
+		t.Errorf("Expected 3 chunk and 2 merge tasks, got %d and %d", chunks, merges)
+	}
+	if expr.Status != "completed" || expr.Integer != "5208645837501" {
+		t.Fatalf("Expected 5208645837501, got %s %s %s", expr.Status, expr.Integer, expr.Error)
+	}
+	var found bool
+	for _, step := range expr.Steps {
+		if step.Calculation == "sum(1..25000, k -> k ^ 2)" && step.Local && step.Result == "5208645837500" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the aggregate step, got %+v", expr.Steps)
+	}
+
+	empty := NewExpression("test16", "1", "avg(1..0)")
+	empty.Start(tasksChan)
+	if empty.Status != "error" || empty.Error != "функция avg не определена для пустого набора значений" {
+		t.Errorf("Expected an empty range error, got %s %q", empty.Status, empty.Error)
+	}
+}
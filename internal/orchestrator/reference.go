@@ -0,0 +1,133 @@
+package orchestrator
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
+)
+
+// Ссылки на результаты: выражение "{expr-1234} * 2" или "ans + 1" вычисляется со
+// значениями других выражений того же пользователя; ans - его последнее выражение,
+// отправленное не в составе пакета или перебора. Выражение, ссылающееся на ещё не
+// вычисленные выражения, ждёт их и отправляется агентам после них. Если выражение,
+// на которое есть ссылка, завершилось ошибкой, ошибкой завершается и ссылающееся.
+
+// reference - выражение, на результат которого ссылается другое выражение.
+type reference struct {
+	// name - запись ссылки в выражении: {expr-1234} или ans.
+	name string
+	id   string
+	// expr - выражение в памяти; nil, если оно есть только в базе, и тогда его
+	// состояние задают status и value.
+	expr   *Expression
+	status string
+	value  calculation.Value
+}
+
+// references находит выражения пользователя userID, на которые ссылаются names.
+// Ссылка на выражение другого пользователя считается ссылкой на несуществующее.
+func (s *Server) references(userID string, names []string) ([]reference, error) {
+	refs := make([]reference, 0, len(names))
+	for _, name := range names {
+		ref := reference{name: name, id: strings.Trim(name, "{}")}
+		if name == calculation.Answer {
+			err := s.db.QueryRow("SELECT id FROM expressions WHERE user_id = ? AND parent_id IS NULL ORDER BY rowid DESC LIMIT 1", userID).Scan(&ref.id)
+			if err != nil {
+				return nil, messages.Error(MsgNoAnswer)
+			}
+		}
+		var result float64
+		var mode, unit string
+		var value sql.NullString
+		err := s.db.QueryRow("SELECT status, COALESCE(result, 0), COALESCE(mode, 'float'), COALESCE(unit, ''), value FROM expressions WHERE id = ? AND user_id = ?", ref.id, userID).
+			Scan(&ref.status, &result, &mode, &unit, &value)
+		if err != nil {
+			return nil, messages.Error(MsgReferenceNotFound, ref.id)
+		}
+		ref.value = storedValue(value, calculation.Mode(mode), result, unit)
+		s.mu.Lock()
+		ref.expr = s.expressions[ref.id]
+		s.mu.Unlock()
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// storedValue восстанавливает точное значение выражения из столбца value, где оно
+// хранится в JSON. Прежние версии сервера записывали туда запись значения или не
+// записывали ничего; тогда значение берётся из записи или из result и unit.
+func storedValue(value sql.NullString, mode calculation.Mode, result float64, unit string) calculation.Value {
+	var v calculation.Value
+	if value.Valid && json.Unmarshal([]byte(value.String), &v) == nil {
+		return v
+	}
+	if value.Valid {
+		if v, err := calculation.ParseValue(mode, value.String); err == nil {
+			return v
+		}
+	}
+	v, err := calculation.ParseValue(calculation.ModeFloat, strings.TrimSpace(strconv.FormatFloat(result, 'g', -1, 64)+" "+unit))
+	if err != nil {
+		return calculation.FloatValue(result)
+	}
+	return v
+}
+
+// result возвращает значение выражения, на которое ссылаются, или причину, по которой
+// его нет. Вызывается под s.mu.
+func (ref reference) result() (calculation.Value, error) {
+	switch {
+	case ref.expr == nil && ref.status == "completed":
+		return ref.value, nil
+	case ref.expr == nil:
+		// выражение не вычислено до перезапуска сервера
+		return calculation.Value{}, messages.Error(MsgReferenceLost, ref.id)
+	case ref.expr.Status == "completed":
+		return ref.expr.value, nil
+	}
+	return calculation.Value{}, messages.Error(MsgReferenceFailed, ref.id, ref.expr.Error)
+}
+
+// await ждёт выражения, на которые ссылается expr, подставляет их точные значения и
+// отправляет задачи expr агентам. В Variables значения ссылок остаются для показа.
+func (s *Server) await(expr *Expression) {
+	for _, ref := range expr.references {
+		if ref.expr != nil {
+			<-ref.expr.Done()
+		}
+	}
+	s.mu.Lock()
+	var ready []*calculation.Task
+	variables := maps.Clone(expr.Variables)
+	if variables == nil {
+		variables = make(map[string]string, len(expr.references))
+	}
+	constants := maps.Clone(expr.constants)
+	if constants == nil {
+		constants = make(map[string]calculation.Value, len(expr.references))
+	}
+	var err error
+	for _, ref := range expr.references {
+		var value calculation.Value
+		if value, err = ref.result(); err != nil {
+			break
+		}
+		variables[ref.name], constants[ref.name] = value.String(), value
+	}
+	if err != nil {
+		expr.fail(err)
+	} else {
+		expr.Variables, expr.constants = variables, constants
+		ready = expr.plan(s.tasks)
+	}
+	if err := s.saveExpression(expr); err != nil {
+		fmt.Printf("Error updating DB for expr %s: %v\n", expr.ID, err)
+	}
+	s.mu.Unlock()
+	expr.dispatch(ready)
+}
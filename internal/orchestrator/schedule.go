@@ -0,0 +1,193 @@
+package orchestrator
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
+)
+
+// Schedule - расписание выражения: однократный запуск в момент At или повторные
+// запуски по расписанию cron. Каждый запуск - обычное выражение с Parent, равным ID
+// расписания; планировщик оркестратора отправляет запуски, время которых наступило.
+type Schedule struct {
+	ID         string
+	UserID     string
+	Expression string
+	Mode       calculation.Mode
+	Precompute bool       `json:",omitempty"`
+	Unit       string     `json:",omitempty"`
+	At         *time.Time `json:",omitempty"`
+	Cron       string     `json:",omitempty"`
+	// Status - active, пока ожидаются запуски, затем finished; cancelled - расписание
+	// отменено пользователем.
+	Status string
+	// Next - время следующего запуска.
+	Next *time.Time `json:",omitempty"`
+	// Runs - последние запуски в порядке времени.
+	Runs []ScheduleRun
+}
+
+// ScheduleRun - запуск расписания. Состояние выражения запуска заполняется при
+// запросе расписания.
+type ScheduleRun struct {
+	ExpressionID string    `json:"expression_id"`
+	Time         time.Time `json:"time"`
+	Status       string    `json:"status,omitempty"`
+	Result       float64   `json:"result"`
+	Value        string    `json:"value,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// maxScheduleRuns - сколько последних запусков хранит расписание; более ранние
+// запуски остаются в списке выражений.
+const maxScheduleRuns = 1000
+
+// schedulerInterval - как часто планировщик проверяет расписания.
+const schedulerInterval = time.Second
+
+func generateScheduleID() string {
+	return "schedule-" + uuid.New().String()
+}
+
+// advance назначает следующий запуск после now; однократное расписание и расписание
+// cron без будущих запусков завершаются.
+func (sc *Schedule) advance(now time.Time) {
+	sc.Next = nil
+	if sc.Cron != "" {
+		if cron, err := parseCron(sc.Cron); err == nil {
+			if next := cron.Next(now); !next.IsZero() {
+				sc.Next = &next
+				return
+			}
+		}
+	}
+	sc.Status = "finished"
+}
+
+// record добавляет запуск выражением expr в момент now.
+func (sc *Schedule) record(expr *Expression, now time.Time) {
+	sc.Runs = append(sc.Runs, ScheduleRun{ExpressionID: expr.ID, Time: now})
+	if len(sc.Runs) > maxScheduleRuns {
+		sc.Runs = slices.Clone(sc.Runs[len(sc.Runs)-maxScheduleRuns:])
+	}
+}
+
+// cronSchedule - расписание cron из пяти полей: минута, час, день месяца, месяц и
+// день недели. Поле - "*", число, диапазон "1-5", шаг "*/15" или "1-30/2" и их
+// списки через запятую; воскресенье - 0 или 7. Время расписания - UTC.
+type cronSchedule struct {
+	minute, hour, day, month, weekday uint64
+	// anyDay и anyWeekday - поле дня месяца или дня недели начинается с "*". Если
+	// ограничены оба поля, подходит день, совпадающий с любым из них, как в cron.
+	anyDay, anyWeekday bool
+}
+
+// cronFields - допустимые значения полей расписания cron.
+var cronFields = [5]struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// maxCronSearch - как далеко вперёд ищется следующий запуск; расписание без запусков
+// в этом окне ("0 0 30 2 *") не запускается.
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, messages.Error(MsgInvalidCron, spec)
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		b, ok := cronField(field, cronFields[i].min, cronFields[i].max)
+		if !ok {
+			return nil, messages.Error(MsgInvalidCron, spec)
+		}
+		bits[i] = b
+	}
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cronSchedule{
+		minute:     bits[0],
+		hour:       bits[1],
+		day:        bits[2],
+		month:      bits[3],
+		weekday:    bits[4],
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// cronField разбирает поле расписания в множество значений от low до high.
+func cronField(field string, low, high int) (uint64, bool) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		values, step := part, 1
+		stepped := false
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return 0, false
+			}
+			values, step, stepped = r, n, true
+		}
+		lo, hi := low, high
+		if values != "*" {
+			first, last, isRange := strings.Cut(values, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return 0, false
+			}
+			hi = lo
+			switch {
+			case isRange:
+				if hi, err = strconv.Atoi(last); err != nil {
+					return 0, false
+				}
+			case stepped:
+				// "5/15" - с 5 до конца поля с шагом 15
+				hi = high
+			}
+			if lo < low || hi > high || lo > hi {
+				return 0, false
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, true
+}
+
+// Next возвращает время первого запуска позже after или нулевое время, если в
+// пределах maxCronSearch запусков нет.
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	for limit := t.Add(maxCronSearch); t.Before(limit); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	day := c.day&(1<<uint(t.Day())) != 0
+	weekday := c.weekday&(1<<uint(t.Weekday())) != 0
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,6 +22,8 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -25,11 +31,22 @@ type Server struct {
 	UnimplementedTaskServiceServer
 	Router      *mux.Router
 	expressions map[string]*Expression
+	equations   map[string]*Equation
+	sheets      map[string]*Sheet
+	sweeps      map[string]*Sweep
 	tasks       chan *calculation.Task
 	mu          sync.Mutex
 	db          *sql.DB
+	// workers - время последнего обращения каждого агента по gRPC.
+	workers map[string]time.Time
 }
 
+// WorkerIDKey - ключ метаданных gRPC, в котором агент передаёт свой идентификатор.
+const WorkerIDKey = "worker-id"
+
+// workerTimeout - сколько агент считается живым после последнего обращения.
+const workerTimeout = 10 * time.Second
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.Router.ServeHTTP(w, r)
 }
@@ -42,7 +59,8 @@ func NewServer() *Server {
 	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS users (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         login TEXT UNIQUE,
-        password TEXT
+        password TEXT,
+        lang TEXT
     )`)
 	if err != nil {
 		log.Fatal(err)
@@ -53,24 +71,130 @@ func NewServer() *Server {
         expression TEXT,
         status TEXT,
         result REAL,
+        mode TEXT DEFAULT 'float',
+        fraction TEXT,
+        imag REAL,
+        integer_value TEXT,
+        simplified TEXT,
+        eliminated INTEGER DEFAULT 0,
+        steps TEXT,
+        variables TEXT,
+        array_value TEXT,
+        interval_lower REAL,
+        interval_upper REAL,
+        unit TEXT,
+        assignments TEXT,
+        parent_id TEXT,
+        value TEXT,
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS equations (
+        id TEXT PRIMARY KEY,
+        user_id INTEGER,
+        equation TEXT,
+        status TEXT,
+        data TEXT,
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS batches (
+        id TEXT PRIMARY KEY,
+        user_id INTEGER,
+        data TEXT,
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS sweeps (
+        id TEXT PRIMARY KEY,
+        user_id INTEGER,
+        expression TEXT,
+        status TEXT,
+        data TEXT,
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS sheets (
+        id TEXT PRIMARY KEY,
+        user_id INTEGER,
+        data TEXT,
+        FOREIGN KEY(user_id) REFERENCES users(id)
+    )`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schedules (
+        id TEXT PRIMARY KEY,
+        user_id INTEGER,
+        status TEXT,
+        next_run INTEGER,
+        data TEXT,
         FOREIGN KEY(user_id) REFERENCES users(id)
     )`)
 	if err != nil {
 		log.Fatal(err)
 	}
+	addColumn(db, "users", "lang", "TEXT")
+	addColumn(db, "expressions", "mode", "TEXT DEFAULT 'float'")
+	addColumn(db, "expressions", "fraction", "TEXT")
+	addColumn(db, "expressions", "imag", "REAL")
+	addColumn(db, "expressions", "integer_value", "TEXT")
+	addColumn(db, "expressions", "simplified", "TEXT")
+	addColumn(db, "expressions", "eliminated", "INTEGER DEFAULT 0")
+	addColumn(db, "expressions", "steps", "TEXT")
+	addColumn(db, "expressions", "variables", "TEXT")
+	addColumn(db, "expressions", "array_value", "TEXT")
+	addColumn(db, "expressions", "interval_lower", "REAL")
+	addColumn(db, "expressions", "interval_upper", "REAL")
+	addColumn(db, "expressions", "unit", "TEXT")
+	addColumn(db, "expressions", "assignments", "TEXT")
+	addColumn(db, "expressions", "parent_id", "TEXT")
+	addColumn(db, "expressions", "value", "TEXT")
 
 	router := mux.NewRouter()
 	srv := &Server{
 		Router:      router,
 		expressions: make(map[string]*Expression),
+		equations:   make(map[string]*Equation),
+		sheets:      make(map[string]*Sheet),
+		sweeps:      make(map[string]*Sweep),
 		tasks:       make(chan *calculation.Task, 100),
 		db:          db,
+		workers:     make(map[string]time.Time),
 	}
 	router.HandleFunc("/api/v1/register", srv.handleRegister).Methods("POST")
 	router.HandleFunc("/api/v1/login", srv.handleLogin).Methods("POST")
 	router.HandleFunc("/api/v1/calculate", srv.handleCalculate).Methods("POST")
+	router.HandleFunc("/api/v1/calculate/batch", srv.handleCalculateBatch).Methods("POST")
+	router.HandleFunc("/api/v1/batches/{id}", srv.handleGetBatch).Methods("GET")
+	router.HandleFunc("/api/v1/evaluate", srv.handleEvaluate).Methods("POST")
+	router.HandleFunc("/api/v1/parse", srv.handleParse).Methods("POST")
+	router.HandleFunc("/api/v1/format", srv.handleFormat).Methods("POST")
+	router.HandleFunc("/api/v1/derivative", srv.handleDerivative).Methods("POST")
+	router.HandleFunc("/api/v1/equations", srv.handleSolve).Methods("POST")
+	router.HandleFunc("/api/v1/equations/{id}", srv.handleGetEquation).Methods("GET")
+	router.HandleFunc("/api/v1/sweeps", srv.handleSweep).Methods("POST")
+	router.HandleFunc("/api/v1/sweeps/{id}", srv.handleGetSweep).Methods("GET")
+	router.HandleFunc("/api/v1/sweeps/{id}/csv", srv.handleGetSweepCSV).Methods("GET")
+	router.HandleFunc("/api/v1/sheets", srv.handleCreateSheet).Methods("POST")
+	router.HandleFunc("/api/v1/sheets/{id}", srv.handleGetSheet).Methods("GET")
+	router.HandleFunc("/api/v1/sheets/{id}/cells/{cell}", srv.handleSetCell).Methods("PUT")
+	router.HandleFunc("/api/v1/sheets/{id}/changes", srv.handleGetSheetChanges).Methods("GET")
+	router.HandleFunc("/api/v1/schedules", srv.handleCreateSchedule).Methods("POST")
+	router.HandleFunc("/api/v1/schedules/{id}", srv.handleGetSchedule).Methods("GET")
+	router.HandleFunc("/api/v1/schedules/{id}", srv.handleCancelSchedule).Methods("DELETE")
 	router.HandleFunc("/api/v1/expressions", srv.handleGetExpressions).Methods("GET")
 	router.HandleFunc("/api/v1/expressions/{id}", srv.handleGetExpression).Methods("GET")
+	router.HandleFunc("/api/v1/expressions/{id}/steps", srv.handleGetSteps).Methods("GET")
+	router.HandleFunc("/api/v1/settings", srv.handleSettings).Methods("PUT")
 	return srv
 }
 
@@ -80,25 +204,26 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
+		lang := s.lang(r, "")
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			httpError(w, lang, http.StatusUnauthorized, MsgUnauthorized)
 			return
 		}
 		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) { return []byte("secret"), nil })
 		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			httpError(w, lang, http.StatusUnauthorized, MsgInvalidToken)
 			return
 		}
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			httpError(w, lang, http.StatusUnauthorized, MsgInvalidTokenClaims)
 			return
 		}
 		userID, ok := claims["user_id"].(float64)
 		if !ok {
-			http.Error(w, "Invalid user ID in token", http.StatusUnauthorized)
+			httpError(w, lang, http.StatusUnauthorized, MsgInvalidUserID)
 			return
 		}
 		r.Header.Set("X-User-ID", fmt.Sprintf("%.0f", userID))
@@ -108,42 +233,472 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 
 func (s *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var req calculateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	mode, err := calculation.ParseMode(req.Mode)
+	if err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidMode)
+		return
+	}
+	expr, err := req.expression(userID, mode)
+	if err == nil {
+		expr.references, err = s.references(userID, calculation.References(req.Expression))
+	}
+	var errs calculation.ParseErrors
+	switch {
+	case errors.As(err, &errs):
+		writeParseErrors(w, lang, req.Expression, errs)
+		return
+	case err != nil:
+		writeError(w, lang, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err := s.submit(expr); err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"id": expr.ID})
+}
+
+// handleCalculateBatch принимает пакет выражений. Каждый элемент проверяется отдельно:
+// принятые выражения отправляются на вычисление с Parent, равным ID пакета, а для
+// отклонённых возвращается ошибка. Пакет с ошибками в части элементов принимается.
+func (s *Server) handleCalculateBatch(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	raw, err := decodeBatch(body)
+	switch {
+	case err != nil:
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	case len(raw) == 0:
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgEmptyBatch)
+		return
+	case len(raw) > maxBatchItems:
+		httpError(w, lang, http.StatusRequestEntityTooLarge, MsgBatchTooLarge, strconv.Itoa(len(raw)), strconv.Itoa(maxBatchItems))
+		return
+	}
+
+	batch := &Batch{ID: generateBatchID(), UserID: userID, Total: len(raw)}
+	var accepted []*Expression
+	for i, item := range raw {
+		result := BatchItem{Index: i}
+		req, err := decodeBatchItem(item)
+		if err != nil {
+			result.Error = messages.Localize(lang, MsgInvalidRequestBody)
+			batch.Items = append(batch.Items, result)
+			continue
+		}
+		mode, err := calculation.ParseMode(req.Mode)
+		if err != nil {
+			result.Error = messages.Localize(lang, MsgInvalidMode)
+			batch.Items = append(batch.Items, result)
+			continue
+		}
+		expr, err := req.expression(userID, mode)
+		if err == nil {
+			expr.references, err = s.references(userID, calculation.References(req.Expression))
+		}
+		var errs calculation.ParseErrors
+		switch {
+		case errors.As(err, &errs):
+			result.Error = messages.Localize(lang, MsgInvalidExpression)
+			for _, err := range errs {
+				result.Errors = append(result.Errors, parseErrorResponse{ParseError: err.Localize(lang), Snippet: err.Snippet(req.Expression)})
+			}
+		case err != nil:
+			result.Error = calculation.Translate(err, lang)
+		default:
+			expr.Parent = batch.ID
+			result.ID = expr.ID
+			accepted = append(accepted, expr)
+		}
+		batch.Items = append(batch.Items, result)
+	}
+	batch.Accepted, batch.Rejected = len(accepted), batch.Total-len(accepted)
+
+	if err := s.saveBatch(batch, true); err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+	// часть пакета уже может быть в очереди, поэтому элемент, который не удалось
+	// отправить, отклоняется, а остальные отправляются дальше
+	var failed bool
+	for _, expr := range accepted {
+		if err := s.submit(expr); err != nil {
+			for i := range batch.Items {
+				if batch.Items[i].ID == expr.ID {
+					batch.Items[i] = BatchItem{Index: batch.Items[i].Index, Error: messages.Localize(lang, MsgInternalError)}
+				}
+			}
+			batch.Accepted, batch.Rejected = batch.Accepted-1, batch.Rejected+1
+			failed = true
+		}
+	}
+	if failed {
+		if err := s.saveBatch(batch, false); err != nil {
+			fmt.Printf("Error updating DB for batch %s: %v\n", batch.ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"id": batch.ID, "accepted": batch.Accepted, "rejected": batch.Rejected, "items": batch.Items})
+}
+
+// handleGetBatch возвращает сводный статус пакета и результаты проверки его элементов.
+func (s *Server) handleGetBatch(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var data string
+	var batch Batch
+	err := s.db.QueryRow("SELECT data FROM batches WHERE id = ? AND user_id = ?", id, userID).Scan(&data)
+	if err != nil || json.Unmarshal([]byte(data), &batch) != nil {
+		httpError(w, lang, http.StatusNotFound, MsgBatchNotFound)
+		return
+	}
+	rows, err := s.db.Query("SELECT status, COUNT(*) FROM expressions WHERE parent_id = ? AND user_id = ? GROUP BY status", id, userID)
+	if err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+	defer rows.Close()
+	statuses := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+			return
+		}
+		statuses[status] = count
+	}
+	batch.summarize(statuses)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]Batch{"batch": batch})
+}
+
+// submit сохраняет выражение в базе и отправляет его задачи агентам. Выражение со
+// ссылками на результаты других выражений отправляется, когда они вычислены.
+func (s *Server) submit(expr *Expression) error {
+	variables, err := json.Marshal(expr.Variables)
+	if err != nil {
+		return err
+	}
+	uid, _ := strconv.Atoi(expr.UserID)
+	var parent sql.NullString
+	if expr.Parent != "" {
+		parent = sql.NullString{String: expr.Parent, Valid: true}
+	}
+	_, err = s.db.Exec("INSERT INTO expressions (id, user_id, status, expression, mode, variables, unit, parent_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		expr.ID, uid, "pending", expr.Expr, string(expr.Mode), string(variables), expr.Unit, parent)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.expressions[expr.ID] = expr
+	if len(expr.references) > 0 && expr.Status == "pending" {
+		s.mu.Unlock()
+		go s.await(expr)
+		return nil
+	}
+	var ready []*calculation.Task
+	if expr.Status == "pending" {
+		// выражение, завершившееся ошибкой до отправки, только сохраняется
+		ready = expr.plan(s.tasks)
+	}
+	s.saveExpression(expr)
+	s.mu.Unlock()
+
+	go expr.dispatch(ready)
+	return nil
+}
+
+// handleDerivative возвращает производную выражения по переменной. Если задана точка
+// at, производная в этой точке отправляется на вычисление агентам, как обычное выражение.
+func (s *Server) handleDerivative(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var req struct {
+		Expression string      `json:"expression"`
+		Variable   string      `json:"variable"`
+		Mode       string      `json:"mode"`
+		At         json.Number `json:"at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	if req.Variable == "" {
+		req.Variable = "x"
+	}
+	if !calculation.ValidVariable(req.Variable) {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidVariable, req.Variable)
+		return
+	}
+	mode, err := calculation.ParseMode(req.Mode)
+	if err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidMode)
+		return
+	}
+	if errs := calculation.CheckWith(req.Expression, mode, req.Variable); len(errs) > 0 {
+		writeParseErrors(w, lang, req.Expression, errs)
+		return
+	}
+	tree, _ := calculation.ParseWith(req.Expression, req.Variable)
+	derivative, err := calculation.Derivative(tree, req.Variable, mode)
+	if err != nil {
+		writeError(w, lang, http.StatusUnprocessableEntity, err)
+		return
+	}
+	resp := map[string]string{
+		"expression": req.Expression,
+		"variable":   req.Variable,
+		"derivative": derivative.String(),
+		"latex":      derivative.LaTeX(),
+	}
+	if req.At != "" {
+		if _, err := calculation.ParseValue(mode, req.At.String()); err != nil {
+			writeError(w, lang, http.StatusUnprocessableEntity, err)
+			return
+		}
+		expr := NewExpression(generateID(), userID, derivative.String())
+		expr.Mode = mode
+		expr.Variables = map[string]string{req.Variable: req.At.String()}
+		if err := s.submit(expr); err != nil {
+			httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+			return
+		}
+		resp["id"] = expr.ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// maxEvaluateOperations - наибольшее число операций в выражении с учётом каждого
+// значения диапазонов агрегатных функций, которое /api/v1/evaluate
+// вычисляет сразу; более сложные выражения нужно отправлять в /api/v1/calculate.
+const maxEvaluateOperations = 100
+
+// handleEvaluate вычисляет небольшое выражение сразу в оркестраторе, без агентов.
+func (s *Server) handleEvaluate(w http.ResponseWriter, r *http.Request) {
+	lang := s.lang(r, r.Header.Get("X-User-ID"))
 	var req struct {
 		Expression string `json:"expression"`
+		Mode       string `json:"mode"`
+		Unit       string `json:"unit"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	mode, err := calculation.ParseMode(req.Mode)
+	if err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidMode)
+		return
+	}
+	if errs := calculation.Check(req.Expression, mode); len(errs) > 0 {
+		writeParseErrors(w, lang, req.Expression, errs)
+		return
+	}
+	tree, err := calculation.Parse(req.Expression)
+	if err != nil {
+		writeError(w, lang, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if count := tree.Cost(); count > maxEvaluateOperations {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgExpressionTooComplex, strconv.FormatInt(count, 10), strconv.Itoa(maxEvaluateOperations))
 		return
 	}
-	if req.Expression == "" || strings.ContainsAny(req.Expression, "!@#$%^&") {
-		http.Error(w, "Invalid expression", http.StatusUnprocessableEntity)
+	value, remainders, err := calculation.EvaluateRemainders(tree, mode)
+	unit := req.Unit
+	if err == nil {
+		value, unit, err = convertResult(mode, value, unit)
+	}
+	if err == nil && outOfRange(value) {
+		err = calculation.NewError(calculation.MsgResultOutOfRange)
+	}
+	if err != nil {
+		writeError(w, lang, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	id := generateID()
-	expr := NewExpression(id, userID, req.Expression)
+	resp := struct {
+		Expression string           `json:"expression"`
+		Mode       calculation.Mode `json:"mode"`
+		Result     float64          `json:"result"`
+		Value      string           `json:"value"`
+		Fraction   string           `json:"fraction,omitempty"`
+		Imag       float64          `json:"imag,omitempty"`
+		Integer    string           `json:"integer,omitempty"`
+		Interval   *Bounds          `json:"interval,omitempty"`
+		Unit       string           `json:"unit,omitempty"`
+		// Remainders - ненулевые остатки целочисленного деления в режиме integer.
+		Remainders []calculation.Remainder `json:"remainders,omitempty"`
+	}{Expression: req.Expression, Mode: mode, Result: finite(value.Approx()), Value: value.String(), Imag: value.Imag, Unit: unit, Remainders: remainders}
+	if value.Rat != nil {
+		resp.Fraction = value.Rat.RatString()
+	}
+	if value.Int != nil {
+		resp.Integer = value.Int.String()
+	}
+	if value.Interval != nil {
+		resp.Interval = newBounds(value.Interval)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleParse разбирает выражение и планирует задачи, ничего не отправляя агентам.
+func (s *Server) handleParse(w http.ResponseWriter, r *http.Request) {
+	lang := s.lang(r, r.Header.Get("X-User-ID"))
+	var req struct {
+		Expression string `json:"expression"`
+		Mode       string `json:"mode"`
+		Precompute bool   `json:"precompute"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	mode, err := calculation.ParseMode(req.Mode)
+	if err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidMode)
+		return
+	}
+	if errs := calculation.Check(req.Expression, mode); len(errs) > 0 {
+		writeParseErrors(w, lang, req.Expression, errs)
+		return
+	}
+	tokens, err := calculation.Tokenize(req.Expression)
+	if err != nil {
+		writeError(w, lang, http.StatusUnprocessableEntity, err)
+		return
+	}
+	plan, err := calculation.NewPlan(req.Expression, mode, req.Precompute)
+	if err != nil {
+		writeError(w, lang, http.StatusUnprocessableEntity, err)
+		return
+	}
 	s.mu.Lock()
-	s.expressions[id] = expr
+	workers := s.liveWorkers()
 	s.mu.Unlock()
+	if plan.Tasks == nil {
+		plan.Tasks = []*calculation.Task{}
+	}
+
+	resp := struct {
+		Expression  string              `json:"expression"`
+		Mode        calculation.Mode    `json:"mode"`
+		Tokens      []calculation.Token `json:"tokens"`
+		AST         *calculation.Node   `json:"ast"`
+		Postfix     []string            `json:"postfix"`
+		Simplified  string              `json:"simplified"`
+		Tasks       []*calculation.Task `json:"tasks"`
+		Agents      int                 `json:"agents"`
+		EstimatedMS int                 `json:"estimated_ms"`
+	}{
+		Expression:  req.Expression,
+		Mode:        mode,
+		Tokens:      tokens,
+		AST:         plan.Tree,
+		Postfix:     plan.Postfix,
+		Simplified:  plan.Simplified.String(),
+		Tasks:       plan.Tasks,
+		Agents:      workers,
+		EstimatedMS: calculation.EstimateDuration(plan.Tasks, workers),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
 
-	uid, _ := strconv.Atoi(userID)
-	_, err := s.db.Exec("INSERT INTO expressions (id, user_id, status, expression) VALUES (?, ?, ?, ?)", id, uid, "pending", req.Expression)
+// handleFormat возвращает каноническую запись, LaTeX и MathML выражения, переданного
+// текстом или идентификатором ранее отправленного выражения.
+func (s *Server) handleFormat(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var req struct {
+		Expression string `json:"expression"`
+		ID         string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	if req.ID != "" {
+		s.mu.Lock()
+		expr, exists := s.expressions[req.ID]
+		if exists && expr.UserID == userID {
+			req.Expression = expr.Expr
+		}
+		s.mu.Unlock()
+		if !exists || expr.UserID != userID {
+			err := s.db.QueryRow("SELECT expression FROM expressions WHERE id = ? AND user_id = ?", req.ID, userID).Scan(&req.Expression)
+			if err != nil {
+				httpError(w, lang, http.StatusNotFound, MsgExpressionNotFound)
+				return
+			}
+		}
+	}
+	tree, err := calculation.Parse(req.Expression)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeParseErrors(w, lang, req.Expression, calculation.Check(req.Expression, calculation.ModeFloat))
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"expression": req.Expression,
+		"canonical":  tree.String(),
+		"latex":      tree.LaTeX(),
+		"mathml":     tree.MathML(),
+	})
+}
+
+// writeError возвращает ошибку вычисления в виде JSON {"error": "..."}.
+func writeError(w http.ResponseWriter, lang calculation.Lang, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": calculation.Translate(err, lang)})
+}
 
-	go expr.Start(s.tasks)
+// parseErrorResponse - описание ошибки разбора с фрагментом выражения для подсветки.
+type parseErrorResponse struct {
+	*calculation.ParseError
+	Snippet string `json:"snippet"`
+}
 
+func writeParseErrors(w http.ResponseWriter, lang calculation.Lang, expression string, errs calculation.ParseErrors) {
+	response := struct {
+		Error  string               `json:"error"`
+		Errors []parseErrorResponse `json:"errors"`
+	}{Error: messages.Localize(lang, MsgInvalidExpression)}
+	for _, err := range errs {
+		response.Errors = append(response.Errors, parseErrorResponse{ParseError: err.Localize(lang), Snippet: err.Snippet(expression)})
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"id": id})
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(response)
 }
 
 func (s *Server) handleGetExpressions(w http.ResponseWriter, r *http.Request) {
+	lang := s.lang(r, "")
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		httpError(w, lang, http.StatusUnauthorized, MsgAuthorizationNeeded)
 		return
 	}
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
@@ -151,21 +706,21 @@ func (s *Server) handleGetExpressions(w http.ResponseWriter, r *http.Request) {
 		return []byte("secret"), nil
 	})
 	if err != nil || !token.Valid {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		httpError(w, lang, http.StatusUnauthorized, MsgInvalidToken)
 		return
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		httpError(w, lang, http.StatusUnauthorized, MsgInvalidTokenClaims)
 		return
 	}
 	userID, ok := claims["user_id"].(float64)
 	if !ok {
-		http.Error(w, "Invalid user ID in token", http.StatusUnauthorized)
+		httpError(w, lang, http.StatusUnauthorized, MsgInvalidUserID)
 		return
 	}
 
-	rows, err := s.db.Query("SELECT id, expression, status, result FROM expressions WHERE user_id = ?", int(userID))
+	rows, err := s.db.Query("SELECT id, expression, status, COALESCE(result, 0), COALESCE(mode, 'float'), COALESCE(fraction, ''), COALESCE(imag, 0), COALESCE(integer_value, ''), COALESCE(array_value, ''), interval_lower, interval_upper, COALESCE(unit, ''), COALESCE(parent_id, '') FROM expressions WHERE user_id = ?", int(userID))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -177,6 +732,14 @@ func (s *Server) handleGetExpressions(w http.ResponseWriter, r *http.Request) {
 		Expression string  `json:"expression"`
 		Status     string  `json:"status"`
 		Result     float64 `json:"result"`
+		Mode       string  `json:"mode"`
+		Fraction   string  `json:"fraction,omitempty"`
+		Imag       float64 `json:"imag,omitempty"`
+		Integer    string  `json:"integer,omitempty"`
+		Array      string  `json:"array,omitempty"`
+		Interval   *Bounds `json:"interval,omitempty"`
+		Unit       string  `json:"unit,omitempty"`
+		Parent     string  `json:"parent,omitempty"`
 	}
 	for rows.Next() {
 		var expr struct {
@@ -184,11 +747,21 @@ func (s *Server) handleGetExpressions(w http.ResponseWriter, r *http.Request) {
 			Expression string  `json:"expression"`
 			Status     string  `json:"status"`
 			Result     float64 `json:"result"`
+			Mode       string  `json:"mode"`
+			Fraction   string  `json:"fraction,omitempty"`
+			Imag       float64 `json:"imag,omitempty"`
+			Integer    string  `json:"integer,omitempty"`
+			Array      string  `json:"array,omitempty"`
+			Interval   *Bounds `json:"interval,omitempty"`
+			Unit       string  `json:"unit,omitempty"`
+			Parent     string  `json:"parent,omitempty"`
 		}
-		if err := rows.Scan(&expr.ID, &expr.Expression, &expr.Status, &expr.Result); err != nil {
+		var lower, upper sql.NullFloat64
+		if err := rows.Scan(&expr.ID, &expr.Expression, &expr.Status, &expr.Result, &expr.Mode, &expr.Fraction, &expr.Imag, &expr.Integer, &expr.Array, &lower, &upper, &expr.Unit, &expr.Parent); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		expr.Interval = storedBounds(lower, upper)
 		expressions = append(expressions, expr)
 	}
 
@@ -200,25 +773,36 @@ func (s *Server) handleGetExpressions(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	lang := s.lang(r, "")
 	var req struct {
 		Login    string `json:"login"`
 		Password string `json:"password"`
+		Lang     string `json:"lang"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
 		return
 	}
+	var userLang calculation.Lang
+	if req.Lang != "" {
+		var ok bool
+		if userLang, ok = calculation.ParseLang(req.Lang); !ok {
+			httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidLanguage)
+			return
+		}
+		lang = userLang
+	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
 		return
 	}
-	_, err = s.db.Exec("INSERT INTO users (login, password) VALUES (?, ?)", req.Login, hashedPassword)
+	_, err = s.db.Exec("INSERT INTO users (login, password, lang) VALUES (?, ?, ?)", req.Login, hashedPassword, string(userLang))
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-			http.Error(w, "Login already exists", http.StatusConflict)
+			httpError(w, lang, http.StatusConflict, MsgLoginExists)
 		} else {
-			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			httpError(w, lang, http.StatusInternalServerError, MsgDatabaseError, err.Error())
 		}
 		return
 	}
@@ -226,23 +810,24 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	lang := s.lang(r, "")
 	var req struct {
 		Login    string `json:"login"`
 		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
 		return
 	}
 	var passwordHash []byte
 	var userID int
 	err := s.db.QueryRow("SELECT id, password FROM users WHERE login = ?", req.Login).Scan(&userID, &passwordHash)
 	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		httpError(w, lang, http.StatusUnauthorized, MsgInvalidCredentials)
 		return
 	}
 	if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(req.Password)); err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		httpError(w, lang, http.StatusUnauthorized, MsgInvalidCredentials)
 		return
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
@@ -251,7 +836,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	})
 	tokenString, err := token.SignedString([]byte("secret"))
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -267,7 +852,7 @@ func (s *Server) handleListExpressions(w http.ResponseWriter, r *http.Request) {
 	}{}
 	rows, err := s.db.Query("SELECT id, status, result, expr FROM expressions WHERE user_id = ?", userID)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		httpError(w, s.lang(r, userID), http.StatusInternalServerError, MsgInternalError)
 		return
 	}
 	defer rows.Close()
@@ -286,54 +871,1100 @@ func (s *Server) handleListExpressions(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleGetExpression(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
 	s.mu.Lock()
 	expr, exists := s.expressions[id]
 	s.mu.Unlock()
 	if !exists {
-		var status, exprStr string
-		var result float64
-		err := s.db.QueryRow("SELECT status, result, expr FROM expressions WHERE id = ? AND user_id = ?", id, userID).Scan(&status, &result, &exprStr)
+		var status, exprStr, mode, fraction, integer, array, simplified, variables, unit, assignments, parent string
+		var result, imag float64
+		var eliminated int
+		var lower, upper sql.NullFloat64
+		err := s.db.QueryRow("SELECT status, COALESCE(result, 0), expression, COALESCE(mode, 'float'), COALESCE(fraction, ''), COALESCE(imag, 0), COALESCE(integer_value, ''), COALESCE(array_value, ''), interval_lower, interval_upper, COALESCE(simplified, ''), COALESCE(eliminated, 0), COALESCE(variables, ''), COALESCE(unit, ''), COALESCE(assignments, ''), COALESCE(parent_id, '') FROM expressions WHERE id = ? AND user_id = ?", id, userID).
+			Scan(&status, &result, &exprStr, &mode, &fraction, &imag, &integer, &array, &lower, &upper, &simplified, &eliminated, &variables, &unit, &assignments, &parent)
 		if err != nil {
-			http.Error(w, "Expression not found", http.StatusNotFound)
+			httpError(w, lang, http.StatusNotFound, MsgExpressionNotFound)
 			return
 		}
-		expr = &Expression{ID: id, Status: status, Result: result, Expr: exprStr, UserID: userID}
+		expr = &Expression{ID: id, Status: status, Result: result, Expr: exprStr, UserID: userID, Mode: calculation.Mode(mode), Fraction: fraction, Imag: imag, Integer: integer, Array: array, Interval: storedBounds(lower, upper), Unit: unit, Parent: parent, Simplified: simplified, Eliminated: eliminated}
+		if variables != "" {
+			json.Unmarshal([]byte(variables), &expr.Variables)
+		}
+		if assignments != "" {
+			json.Unmarshal([]byte(assignments), &expr.Assignments)
+		}
+		if status == "completed" {
+			expr.format()
+		}
 	}
+	s.mu.Lock()
+	localized := expr.Localize(lang)
+	s.mu.Unlock()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]Expression{"expression": *expr})
+	json.NewEncoder(w).Encode(map[string]Expression{"expression": localized})
 }
 
-func (s *Server) GetTask(ctx context.Context, _ *Empty) (*Task, error) {
-	select {
-	case task := <-s.tasks:
-		return &Task{
-			Id:            task.ID,
-			Arg1:          task.Arg1,
-			Arg2:          task.Arg2,
-			Operation:     task.Operation,
-			OperationTime: int32(task.OperationTime),
-		}, nil
-	default:
-		return nil, status.Errorf(codes.NotFound, "No tasks available")
+// handleGetSteps возвращает пошаговое решение выражения на языке пользователя.
+func (s *Server) handleGetSteps(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var status string
+	steps := []Step{}
+	s.mu.Lock()
+	expr, exists := s.expressions[id]
+	exists = exists && expr.UserID == userID
+	if exists {
+		status = expr.Status
+		steps = append(steps, expr.Steps...)
+	}
+	s.mu.Unlock()
+	if !exists {
+		var stored string
+		err := s.db.QueryRow("SELECT status, COALESCE(steps, '') FROM expressions WHERE id = ? AND user_id = ?", id, userID).
+			Scan(&status, &stored)
+		if err != nil {
+			httpError(w, lang, http.StatusNotFound, MsgExpressionNotFound)
+			return
+		}
+		if stored != "" {
+			json.Unmarshal([]byte(stored), &steps)
+		}
 	}
+	for i := range steps {
+		steps[i].Text = steps[i].Explain(lang, status == "completed" && i == len(steps)-1)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "status": status, "steps": steps})
 }
 
-func (s *Server) SendResult(ctx context.Context, result *Result) (*Empty, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	for _, expr := range s.expressions {
-		if expr.UpdateTaskResult(result.Id, result.Result) {
-			_, err := s.db.Exec("UPDATE expressions SET result = ?, status = ? WHERE id = ?", expr.Result, expr.Status, expr.ID)
-			if err != nil {
-				fmt.Printf("Error updating DB for expr %s: %v\n", expr.ID, err)
-				return nil, status.Errorf(codes.Internal, err.Error())
-			}
-			fmt.Printf("Updated DB for expr %s: result=%f, status=%s\n", expr.ID, expr.Result, expr.Status)
-			return &Empty{}, nil
-		}
+// Настройки численного решения уравнений по умолчанию и предел числа итераций.
+const (
+	defaultTolerance      = 1e-9
+	defaultMaxIterations  = 50
+	maxEquationIterations = 1000
+)
+
+// handleSolve принимает уравнение с одним неизвестным. Линейное уравнение решается
+// сразу, остальные - в фоне; ход решения возвращает /api/v1/equations/{id}.
+func (s *Server) handleSolve(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var req struct {
+		Equation      string   `json:"equation"`
+		Variable      string   `json:"variable"`
+		Mode          string   `json:"mode"`
+		Method        string   `json:"method"`
+		Initial       float64  `json:"initial"`
+		Lower         *float64 `json:"lower"`
+		Upper         *float64 `json:"upper"`
+		Tolerance     *float64 `json:"tolerance"`
+		MaxIterations *int     `json:"max_iterations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	if req.Variable == "" {
+		req.Variable = "x"
+	}
+	if !calculation.ValidVariable(req.Variable) {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidVariable, req.Variable)
+		return
+	}
+	mode, err := calculation.ParseMode(req.Mode)
+	if err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidMode)
+		return
+	}
+	eq := &Equation{
+		ID:            generateEquationID(),
+		UserID:        userID,
+		Equation:      req.Equation,
+		Variable:      req.Variable,
+		Mode:          mode,
+		Method:        req.Method,
+		Status:        "pending",
+		Tolerance:     defaultTolerance,
+		MaxIterations: defaultMaxIterations,
+		Initial:       req.Initial,
+		Lower:         req.Lower,
+		Upper:         req.Upper,
+	}
+	if req.Tolerance != nil {
+		eq.Tolerance = *req.Tolerance
+	}
+	if req.MaxIterations != nil {
+		eq.MaxIterations = *req.MaxIterations
+	}
+	switch {
+	case eq.Method != "" && eq.Method != MethodLinear && eq.Method != MethodNewton && eq.Method != MethodBisection:
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidMethod, eq.Method)
+		return
+	case eq.Method == MethodBisection && (eq.Lower == nil || eq.Upper == nil || *eq.Lower >= *eq.Upper):
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidBounds)
+		return
+	case !(eq.Tolerance > 0):
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidTolerance)
+		return
+	case eq.MaxIterations < 1 || eq.MaxIterations > maxEquationIterations:
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidIterations, strconv.Itoa(maxEquationIterations))
+		return
+	}
+	if errs := calculation.CheckEquation(req.Equation, mode, req.Variable); len(errs) > 0 {
+		writeParseErrors(w, lang, req.Equation, errs)
+		return
+	}
+	f, _ := calculation.ParseEquation(req.Equation, req.Variable)
+
+	solved := (eq.Method == "" || eq.Method == MethodLinear) && eq.solveLinear(f)
+	if !solved && mode != calculation.ModeFloat {
+		// численные методы перебирают значения float64
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgNumericMode, string(mode))
+		return
+	}
+	if !solved {
+		if eq.Method == "" || eq.Method == MethodLinear {
+			eq.Method = MethodNewton
+			if eq.Lower != nil && eq.Upper != nil {
+				eq.Method = MethodBisection
+			}
+		}
+		eq.function = f.String()
+		if eq.Method == MethodNewton {
+			derivative, err := calculation.Derivative(f, eq.Variable, calculation.ModeFloat)
+			if err != nil {
+				writeError(w, lang, http.StatusUnprocessableEntity, err)
+				return
+			}
+			eq.derivative = derivative.String()
+		}
+	}
+
+	s.mu.Lock()
+	s.equations[eq.ID] = eq
+	err = s.saveEquation(eq, true)
+	s.mu.Unlock()
+	if err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+	if !solved {
+		go s.solve(eq)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"id": eq.ID})
+}
+
+// solve решает уравнение численно и сохраняет результат.
+func (s *Server) solve(eq *Equation) {
+	eq.run(&s.mu, func(function string, x float64) (float64, error) {
+		return s.evaluateAt(eq, function, x)
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveEquation(eq, false); err != nil {
+		fmt.Printf("Error updating DB for equation %s: %v\n", eq.ID, err)
+	}
+}
+
+// evaluateAt отправляет агентам выражение function с неизвестным, равным x, и ждёт
+// результата.
+func (s *Server) evaluateAt(eq *Equation, function string, x float64) (float64, error) {
+	expr := NewExpression(generateID(), eq.UserID, function)
+	expr.Variables = map[string]string{eq.Variable: calculation.FloatValue(x).String()}
+	expr.Parent = eq.ID
+	if err := s.submit(expr); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	eq.Evaluations = append(eq.Evaluations, expr.ID)
+	s.mu.Unlock()
+
+	<-expr.Done()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expr.Status != "completed" {
+		return 0, expr.err
+	}
+	return expr.Result, nil
+}
+
+func (s *Server) handleGetEquation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	s.mu.Lock()
+	eq, exists := s.equations[id]
+	exists = exists && eq.UserID == userID
+	var localized Equation
+	if exists {
+		localized = eq.Localize(lang)
+	}
+	s.mu.Unlock()
+	if !exists {
+		var data string
+		err := s.db.QueryRow("SELECT data FROM equations WHERE id = ? AND user_id = ?", id, userID).Scan(&data)
+		if err != nil || json.Unmarshal([]byte(data), &localized) != nil {
+			httpError(w, lang, http.StatusNotFound, MsgEquationNotFound)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]Equation{"equation": localized})
+}
+
+// handleSweep принимает шаблон выражения и сетку значений параметров. Выражения всех
+// точек сетки отправляются агентам в фоне; прогресс и таблицу результатов возвращает
+// /api/v1/sweeps/{id}.
+func (s *Server) handleSweep(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var req struct {
+		Expression string                     `json:"expression"`
+		Mode       string                     `json:"mode"`
+		Parameters map[string]json.RawMessage `json:"parameters"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	mode, err := calculation.ParseMode(req.Mode)
+	if err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidMode)
+		return
+	}
+	if len(req.Parameters) == 0 {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgNoParameters)
+		return
+	}
+	names := slices.Sorted(maps.Keys(req.Parameters))
+	values := make([][]string, len(names))
+	total := 1
+	for i, name := range names {
+		if !calculation.ValidVariable(name) {
+			httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidVariable, name)
+			return
+		}
+		if values[i], err = sweepValues(name, req.Parameters[name]); err != nil {
+			writeError(w, lang, http.StatusUnprocessableEntity, err)
+			return
+		}
+		for _, value := range values[i] {
+			if _, err := calculation.ParseValue(mode, value); err != nil {
+				writeError(w, lang, http.StatusUnprocessableEntity, err)
+				return
+			}
+		}
+		if total *= len(values[i]); total > maxSweepPoints {
+			httpError(w, lang, http.StatusUnprocessableEntity, MsgTooManyPoints, strconv.Itoa(total), strconv.Itoa(maxSweepPoints))
+			return
+		}
+	}
+	if errs := calculation.CheckWith(req.Expression, mode, names...); len(errs) > 0 {
+		writeParseErrors(w, lang, req.Expression, errs)
+		return
+	}
+
+	sw := &Sweep{
+		ID:          generateSweepID(),
+		UserID:      userID,
+		Expression:  req.Expression,
+		Mode:        mode,
+		Parameters:  names,
+		Status:      "pending",
+		Total:       total,
+		expressions: make([]*Expression, total),
+	}
+	for _, point := range grid(values) {
+		sw.Rows = append(sw.Rows, SweepRow{Values: point, Status: "pending"})
+	}
+	s.mu.Lock()
+	s.sweeps[sw.ID] = sw
+	err = s.saveSweep(sw, true)
+	s.mu.Unlock()
+	if err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+	go s.runSweep(sw)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"id": sw.ID, "total": total})
+}
+
+// runSweep отправляет агентам выражения всех точек перебора и сохраняет таблицу
+// результатов, когда они вычислены.
+func (s *Server) runSweep(sw *Sweep) {
+	expressions := make([]*Expression, sw.Total)
+	for i := range expressions {
+		s.mu.Lock()
+		variables := sw.variables(i)
+		s.mu.Unlock()
+		expr := NewExpression(generateID(), sw.UserID, sw.Expression)
+		expr.Mode = sw.Mode
+		expr.Variables = variables
+		expr.Parent = sw.ID
+		err := s.submit(expr)
+		s.mu.Lock()
+		if err != nil {
+			expr.fail(err)
+		}
+		sw.expressions[i] = expr
+		s.mu.Unlock()
+		expressions[i] = expr
+	}
+	for _, expr := range expressions {
+		<-expr.Done()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sw.refresh()
+	if err := s.saveSweep(sw, false); err != nil {
+		fmt.Printf("Error updating DB for sweep %s: %v\n", sw.ID, err)
+	}
+}
+
+// findSweep возвращает копию перебора пользователя на языке lang: из памяти с текущим
+// прогрессом или, после перезапуска сервера, из базы.
+func (s *Server) findSweep(id, userID string, lang calculation.Lang) (Sweep, bool) {
+	s.mu.Lock()
+	sw, exists := s.sweeps[id]
+	if exists && sw.UserID == userID {
+		sw.refresh()
+		localized := sw.Localize(lang)
+		s.mu.Unlock()
+		return localized, true
+	}
+	s.mu.Unlock()
+	var data string
+	var stored Sweep
+	err := s.db.QueryRow("SELECT data FROM sweeps WHERE id = ? AND user_id = ?", id, userID).Scan(&data)
+	if err != nil || json.Unmarshal([]byte(data), &stored) != nil {
+		return Sweep{}, false
+	}
+	return stored, true
+}
+
+func (s *Server) handleGetSweep(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	sw, ok := s.findSweep(mux.Vars(r)["id"], userID, lang)
+	if !ok {
+		httpError(w, lang, http.StatusNotFound, MsgSweepNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]Sweep{"sweep": sw})
+}
+
+// handleGetSweepCSV отдаёт таблицу результатов перебора файлом CSV.
+func (s *Server) handleGetSweepCSV(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	sw, ok := s.findSweep(mux.Vars(r)["id"], userID, lang)
+	if !ok {
+		httpError(w, lang, http.StatusNotFound, MsgSweepNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sw.ID+".csv"))
+	if err := sw.WriteCSV(w); err != nil {
+		fmt.Printf("Error writing CSV for sweep %s: %v\n", sw.ID, err)
+	}
+}
+
+// checkCells проверяет имена и выражения ячеек. Выражения могут ссылаться на любые
+// ячейки таблицы. Возвращает false, если ответ с ошибкой уже записан.
+func checkCells(w http.ResponseWriter, lang calculation.Lang, mode calculation.Mode, cells map[string]string) bool {
+	for _, name := range slices.Sorted(maps.Keys(cells)) {
+		if !calculation.IsCell(name) {
+			httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidCell, name)
+			return false
+		}
+		if errs := calculation.CheckWith(cells[name], mode, calculation.Cells(cells[name])...); len(errs) > 0 {
+			writeParseErrors(w, lang, cells[name], errs)
+			return false
+		}
+	}
+	return true
+}
+
+// handleCreateSheet создаёт таблицу с ячейками cells и отправляет их на вычисление.
+func (s *Server) handleCreateSheet(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var req struct {
+		Mode  string            `json:"mode"`
+		Cells map[string]string `json:"cells"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	mode, err := calculation.ParseMode(req.Mode)
+	if err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidMode)
+		return
+	}
+	if !checkCells(w, lang, mode, req.Cells) {
+		return
+	}
+	sheet := NewSheet(generateSheetID(), userID, mode)
+	if _, err := sheet.Set(req.Cells); err != nil {
+		writeError(w, lang, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.sheets[sheet.ID] = sheet
+	err = s.saveSheet(sheet, true)
+	if err == nil {
+		s.recalculate(sheet)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"id": sheet.ID})
+}
+
+// handleSetCell изменяет выражение ячейки и пересчитывает её и зависящие от неё ячейки.
+func (s *Server) handleSetCell(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var req struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sheet := s.sheet(vars["id"], userID)
+	if sheet == nil {
+		httpError(w, lang, http.StatusNotFound, MsgSheetNotFound)
+		return
+	}
+	cells := map[string]string{vars["cell"]: req.Expression}
+	if !checkCells(w, lang, sheet.Mode, cells) {
+		return
+	}
+	marked, err := sheet.Set(cells)
+	if err != nil {
+		writeError(w, lang, http.StatusUnprocessableEntity, err)
+		return
+	}
+	s.recalculate(sheet)
+	if err := s.saveSheet(sheet, false); err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"version": sheet.Version, "recalculating": marked})
+}
+
+func (s *Server) handleGetSheet(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	s.mu.Lock()
+	sheet := s.sheet(mux.Vars(r)["id"], userID)
+	var localized Sheet
+	if sheet != nil {
+		localized = sheet.Localize(lang)
+	}
+	s.mu.Unlock()
+	if sheet == nil {
+		httpError(w, lang, http.StatusNotFound, MsgSheetNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]Sheet{"sheet": localized})
+}
+
+// handleGetSheetChanges возвращает ленту изменений таблицы после версии since.
+func (s *Server) handleGetSheetChanges(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	since := 0
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		if since, err = strconv.Atoi(value); err != nil {
+			httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidVersion, value)
+			return
+		}
+	}
+	s.mu.Lock()
+	sheet := s.sheet(mux.Vars(r)["id"], userID)
+	var resp map[string]any
+	if sheet != nil {
+		resp = map[string]any{"version": sheet.Version, "pending": !sheet.Idle(), "changes": sheet.Since(since, lang)}
+	}
+	s.mu.Unlock()
+	if sheet == nil {
+		httpError(w, lang, http.StatusNotFound, MsgSheetNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// sheet возвращает таблицу пользователя, загружая её из базы, если её нет в памяти.
+// Ячейки, не пересчитанные до остановки сервера или сохранённые без точного значения,
+// пересчитываются. Вызывается под s.mu.
+func (s *Server) sheet(id, userID string) *Sheet {
+	if sheet, ok := s.sheets[id]; ok {
+		if sheet.UserID != userID {
+			return nil
+		}
+		return sheet
+	}
+	var data string
+	if err := s.db.QueryRow("SELECT data FROM sheets WHERE id = ? AND user_id = ?", id, userID).Scan(&data); err != nil {
+		return nil
+	}
+	stored := storedSheet{Sheet: NewSheet(id, userID, calculation.ModeFloat)}
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return nil
+	}
+	sheet := stored.Sheet
+	sheet.Changes = stored.Changes
+	for name, cell := range sheet.Cells {
+		value, exact := stored.Values[name]
+		switch {
+		case cell.Status == "completed" && exact:
+			cell.value = value
+		case cell.Status != "error":
+			cell.Status = "pending"
+			sheet.dirty[name] = true
+		}
+	}
+	s.sheets[id] = sheet
+	s.recalculate(sheet)
+	return sheet
+}
+
+// recalculate отправляет агентам ячейки таблицы, готовые к пересчёту. Вызывается под s.mu.
+func (s *Server) recalculate(sheet *Sheet) {
+	for name, expr := range sheet.take() {
+		go s.evaluateCell(sheet, name, expr)
+	}
+}
+
+// evaluateCell вычисляет выражение ячейки, записывает результат и отправляет на
+// пересчёт ячейки, которые ждали этого результата.
+func (s *Server) evaluateCell(sheet *Sheet, name string, expr *Expression) {
+	if err := s.submit(expr); err != nil {
+		s.mu.Lock()
+		expr.fail(err)
+		s.mu.Unlock()
+	}
+	<-expr.Done()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sheet.record(name, expr)
+	s.recalculate(sheet)
+	if err := s.saveSheet(sheet, false); err != nil {
+		fmt.Printf("Error updating DB for sheet %s: %v\n", sheet.ID, err)
+	}
+}
+
+// handleCreateSchedule создаёт расписание выражения: однократный запуск в момент at
+// или повторные запуски по расписанию cron. Ход запусков - /api/v1/schedules/{id}.
+func (s *Server) handleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var req struct {
+		calculateRequest
+		At   *time.Time `json:"at"`
+		Cron string     `json:"cron"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	mode, err := calculation.ParseMode(req.Mode)
+	if err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidMode)
+		return
+	}
+	_, err = req.expression(userID, mode)
+	var errs calculation.ParseErrors
+	switch {
+	case errors.As(err, &errs):
+		writeParseErrors(w, lang, req.Expression, errs)
+		return
+	case err != nil:
+		writeError(w, lang, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	sc := &Schedule{
+		ID:         generateScheduleID(),
+		UserID:     userID,
+		Expression: req.Expression,
+		Mode:       mode,
+		Precompute: req.Precompute,
+		Unit:       req.Unit,
+		Cron:       req.Cron,
+		Status:     "active",
+	}
+	switch {
+	case (req.At == nil) == (req.Cron == ""):
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidSchedule)
+		return
+	case req.At != nil:
+		if !req.At.After(time.Now()) {
+			httpError(w, lang, http.StatusUnprocessableEntity, MsgPastSchedule)
+			return
+		}
+		at := req.At.UTC()
+		sc.At, sc.Next = &at, &at
+	default:
+		cron, err := parseCron(req.Cron)
+		if err != nil {
+			writeError(w, lang, http.StatusUnprocessableEntity, err)
+			return
+		}
+		next := cron.Next(time.Now())
+		if next.IsZero() {
+			httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidCron, req.Cron)
+			return
+		}
+		sc.Next = &next
+	}
+	if err := s.saveSchedule(sc); err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"id": sc.ID, "next": sc.Next})
+}
+
+// handleGetSchedule возвращает расписание с результатами его запусков.
+func (s *Server) handleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	sc, ok := s.findSchedule(mux.Vars(r)["id"], userID)
+	if !ok {
+		httpError(w, lang, http.StatusNotFound, MsgScheduleNotFound)
+		return
+	}
+	for i := range sc.Runs {
+		run := &sc.Runs[i]
+		s.mu.Lock()
+		expr, exists := s.expressions[run.ExpressionID]
+		if exists {
+			localized := expr.Localize(lang)
+			run.Status, run.Result, run.Value, run.Error = localized.Status, localized.Result, localized.value.String(), localized.Error
+		}
+		s.mu.Unlock()
+		if !exists {
+			var mode, unit string
+			var value sql.NullString
+			s.db.QueryRow("SELECT status, COALESCE(result, 0), COALESCE(mode, 'float'), COALESCE(unit, ''), value FROM expressions WHERE id = ?", run.ExpressionID).
+				Scan(&run.Status, &run.Result, &mode, &unit, &value)
+			run.Value = storedValue(value, calculation.Mode(mode), run.Result, unit).String()
+		}
+		if run.Status != "completed" {
+			run.Value = ""
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]*Schedule{"schedule": sc})
+}
+
+// handleCancelSchedule отменяет будущие запуски расписания; выражения уже
+// отправленных запусков вычисляются до конца.
+func (s *Server) handleCancelSchedule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	if _, ok := s.findSchedule(id, userID); !ok {
+		httpError(w, lang, http.StatusNotFound, MsgScheduleNotFound)
+		return
+	}
+	result, err := s.db.Exec("UPDATE schedules SET status = 'cancelled', next_run = NULL WHERE id = ? AND status = 'active'", id)
+	if err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		httpError(w, lang, http.StatusConflict, MsgScheduleInactive)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findSchedule загружает расписание пользователя из базы. Статус хранится отдельно
+// от данных: отмена меняет только его.
+func (s *Server) findSchedule(id, userID string) (*Schedule, bool) {
+	var status, data string
+	var sc Schedule
+	err := s.db.QueryRow("SELECT status, data FROM schedules WHERE id = ? AND user_id = ?", id, userID).Scan(&status, &data)
+	if err != nil || json.Unmarshal([]byte(data), &sc) != nil {
+		return nil, false
+	}
+	sc.Status = status
+	if status != "active" {
+		sc.Next = nil
+	}
+	return &sc, true
+}
+
+// RunScheduler раз в schedulerInterval отправляет запуски расписаний, время которых
+// наступило. Не возвращает управление; запускается в отдельной горутине.
+func (s *Server) RunScheduler() {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.runDue(now)
+	}
+}
+
+// runDue отправляет запуски всех расписаний, время которых наступило к now. Запуски,
+// пропущенные, пока сервер не работал, выполняются один раз.
+func (s *Server) runDue(now time.Time) {
+	rows, err := s.db.Query("SELECT status, data FROM schedules WHERE status = 'active' AND next_run <= ?", now.Unix())
+	if err != nil {
+		fmt.Printf("Error loading schedules: %v\n", err)
+		return
+	}
+	var due []*Schedule
+	for rows.Next() {
+		var status, data string
+		var sc Schedule
+		if rows.Scan(&status, &data) != nil || json.Unmarshal([]byte(data), &sc) != nil {
+			continue
+		}
+		sc.Status = status
+		due = append(due, &sc)
+	}
+	rows.Close()
+	for _, sc := range due {
+		s.runSchedule(sc, now)
+	}
+}
+
+// runSchedule отправляет запуск расписания и назначает следующий. Запуск отправляется,
+// только если расписание не отменено и не запущено другим планировщиком.
+func (s *Server) runSchedule(sc *Schedule, now time.Time) {
+	due := sc.Next.Unix()
+	expr := NewExpression(generateID(), sc.UserID, sc.Expression)
+	expr.Mode = sc.Mode
+	expr.Precompute = sc.Precompute
+	expr.Unit = sc.Unit
+	expr.Parent = sc.ID
+	sc.record(expr, now)
+	sc.advance(now)
+	data, err := json.Marshal(sc)
+	if err != nil {
+		fmt.Printf("Error updating DB for schedule %s: %v\n", sc.ID, err)
+		return
+	}
+	var next sql.NullInt64
+	if sc.Next != nil {
+		next = sql.NullInt64{Int64: sc.Next.Unix(), Valid: true}
+	}
+	result, err := s.db.Exec("UPDATE schedules SET status = ?, next_run = ?, data = ? WHERE id = ? AND status = 'active' AND next_run = ?",
+		sc.Status, next, string(data), sc.ID, due)
+	if err != nil {
+		fmt.Printf("Error updating DB for schedule %s: %v\n", sc.ID, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+	// ссылки на результаты разрешаются в момент запуска: ans - последний результат
+	// пользователя к этому времени
+	refs, err := s.references(sc.UserID, calculation.References(sc.Expression))
+	if err != nil {
+		expr.fail(err)
+	}
+	expr.references = refs
+	if err := s.submit(expr); err != nil {
+		fmt.Printf("Error submitting run of schedule %s: %v\n", sc.ID, err)
+	}
+}
+
+func (s *Server) GetTask(ctx context.Context, _ *Empty) (*Task, error) {
+	worker := s.touchWorker(ctx)
+	select {
+	case task := <-s.tasks:
+		s.mu.Lock()
+		for _, expr := range s.expressions {
+			if expr.Owns(task.ID) {
+				expr.StartTask(task.ID, worker, time.Now())
+				break
+			}
+		}
+		s.mu.Unlock()
+		return taskToProto(task), nil
+	default:
+		return nil, status.Errorf(codes.NotFound, "No tasks available")
+	}
+}
+
+func (s *Server) SendResult(ctx context.Context, result *Result) (*Empty, error) {
+	s.touchWorker(ctx)
+	value := calculation.FloatValue(result.Result)
+	if result.Value != nil {
+		v, err := ValueFromProto(result.Value)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		}
+		value = v
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, expr := range s.expressions {
+		if !expr.Owns(result.Id) {
+			continue
+		}
+		var updated bool
+		if result.Error != "" {
+			var err error = errors.New(result.Error)
+			if result.ErrorId != "" {
+				err = calculation.NewError(calculation.MessageID(result.ErrorId), result.ErrorArgs...)
+			}
+			updated = expr.FailTask(result.Id, err)
+		} else {
+			updated = expr.UpdateTaskValue(result.Id, value)
+		}
+		if updated {
+			if err := s.saveExpression(expr); err != nil {
+				fmt.Printf("Error updating DB for expr %s: %v\n", expr.ID, err)
+				return nil, status.Errorf(codes.Internal, err.Error())
+			}
+			return &Empty{}, nil
+		}
+	}
+	fmt.Printf("Task %s not found in expressions\n", result.Id)
+	return nil, status.Errorf(codes.NotFound, "Task not found")
+}
+
+// touchWorker отмечает обращение агента и возвращает его идентификатор. Агенты без
+// идентификатора различаются по адресу.
+func (s *Server) touchWorker(ctx context.Context) string {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(WorkerIDKey)) > 0 {
+		id = md.Get(WorkerIDKey)[0]
+	} else if p, ok := peer.FromContext(ctx); ok {
+		id = p.Addr.String()
+	}
+	if id == "" {
+		return ""
+	}
+	s.mu.Lock()
+	s.workers[id] = time.Now()
+	s.mu.Unlock()
+	return id
+}
+
+// liveWorkers возвращает число агентов, обращавшихся за последние workerTimeout.
+// Вызывается под s.mu.
+func (s *Server) liveWorkers() int {
+	count := 0
+	for id, seen := range s.workers {
+		if time.Since(seen) > workerTimeout {
+			delete(s.workers, id)
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+func (s *Server) saveExpression(expr *Expression) error {
+	steps, err := json.Marshal(expr.Steps)
+	if err != nil {
+		return err
+	}
+	var assignments sql.NullString
+	if len(expr.Assignments) > 0 {
+		data, err := json.Marshal(expr.Assignments)
+		if err != nil {
+			return err
+		}
+		assignments = sql.NullString{String: string(data), Valid: true}
+	}
+	var lower, upper sql.NullFloat64
+	if expr.Interval != nil {
+		lower = sql.NullFloat64{Float64: expr.Interval.Lower, Valid: true}
+		upper = sql.NullFloat64{Float64: expr.Interval.Upper, Valid: true}
+	}
+	// точное значение для ссылок на выражение; бесконечности JSON не записывает,
+	// и тогда ссылки берут result
+	var value sql.NullString
+	if data, err := json.Marshal(expr.value); err == nil && expr.Status == "completed" {
+		value = sql.NullString{String: string(data), Valid: true}
+	}
+	variables, err := json.Marshal(expr.Variables)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("UPDATE expressions SET result = ?, status = ?, fraction = ?, imag = ?, integer_value = ?, array_value = ?, interval_lower = ?, interval_upper = ?, unit = ?, assignments = ?, simplified = ?, eliminated = ?, steps = ?, value = ?, variables = ? WHERE id = ?",
+		expr.Result, expr.Status, expr.Fraction, expr.Imag, expr.Integer, expr.Array, lower, upper, expr.Unit, assignments, expr.Simplified, expr.Eliminated, string(steps), value, string(variables), expr.ID)
+	return err
+}
+
+// storedBounds восстанавливает границы результата из базы; у результатов других
+// режимов границ нет.
+func storedBounds(lower, upper sql.NullFloat64) *Bounds {
+	if !lower.Valid || !upper.Valid {
+		return nil
+	}
+	return newBounds(&calculation.Interval{Lo: lower.Float64, Hi: upper.Float64})
+}
+
+// saveEquation сохраняет уравнение; created - уравнение ещё не записано в базу.
+// Вызывается под s.mu.
+func (s *Server) saveEquation(eq *Equation, created bool) error {
+	data, err := json.Marshal(eq)
+	if err != nil {
+		return err
+	}
+	if created {
+		uid, _ := strconv.Atoi(eq.UserID)
+		_, err = s.db.Exec("INSERT INTO equations (id, user_id, equation, status, data) VALUES (?, ?, ?, ?, ?)", eq.ID, uid, eq.Equation, eq.Status, string(data))
+		return err
+	}
+	_, err = s.db.Exec("UPDATE equations SET status = ?, data = ? WHERE id = ?", eq.Status, string(data), eq.ID)
+	return err
+}
+
+// saveBatch сохраняет пакет с результатами проверки его элементов; created - пакет
+// ещё не записан в базу.
+func (s *Server) saveBatch(batch *Batch, created bool) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	if created {
+		uid, _ := strconv.Atoi(batch.UserID)
+		_, err = s.db.Exec("INSERT INTO batches (id, user_id, data) VALUES (?, ?, ?)", batch.ID, uid, string(data))
+		return err
+	}
+	_, err = s.db.Exec("UPDATE batches SET data = ? WHERE id = ?", string(data), batch.ID)
+	return err
+}
+
+// saveSweep сохраняет перебор; created - перебор ещё не записан в базу.
+// Вызывается под s.mu.
+func (s *Server) saveSweep(sw *Sweep, created bool) error {
+	data, err := json.Marshal(sw)
+	if err != nil {
+		return err
+	}
+	if created {
+		uid, _ := strconv.Atoi(sw.UserID)
+		_, err = s.db.Exec("INSERT INTO sweeps (id, user_id, expression, status, data) VALUES (?, ?, ?, ?, ?)", sw.ID, uid, sw.Expression, sw.Status, string(data))
+		return err
+	}
+	_, err = s.db.Exec("UPDATE sweeps SET status = ?, data = ? WHERE id = ?", sw.Status, string(data), sw.ID)
+	return err
+}
+
+// saveSchedule записывает новое расписание. Дальше расписание меняют только
+// планировщик и отмена, условными обновлениями.
+func (s *Server) saveSchedule(sc *Schedule) error {
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return err
+	}
+	uid, _ := strconv.Atoi(sc.UserID)
+	_, err = s.db.Exec("INSERT INTO schedules (id, user_id, status, next_run, data) VALUES (?, ?, ?, ?, ?)", sc.ID, uid, sc.Status, sc.Next.Unix(), string(data))
+	return err
+}
+
+// storedSheet - запись таблицы в базе вместе с лентой изменений и точными значениями
+// вычисленных ячеек.
+type storedSheet struct {
+	*Sheet
+	Changes []Change
+	Values  map[string]calculation.Value
+}
+
+// saveSheet сохраняет таблицу; created - таблица ещё не записана в базу.
+// Вызывается под s.mu.
+func (s *Server) saveSheet(sheet *Sheet, created bool) error {
+	values := make(map[string]calculation.Value)
+	for name, cell := range sheet.Cells {
+		if cell.Status == "completed" {
+			values[name] = cell.value
+		}
+	}
+	data, err := json.Marshal(storedSheet{Sheet: sheet, Changes: sheet.Changes, Values: values})
+	if err != nil {
+		return err
+	}
+	if created {
+		uid, _ := strconv.Atoi(sheet.UserID)
+		_, err = s.db.Exec("INSERT INTO sheets (id, user_id, data) VALUES (?, ?, ?)", sheet.ID, uid, string(data))
+		return err
+	}
+	_, err = s.db.Exec("UPDATE sheets SET data = ? WHERE id = ?", string(data), sheet.ID)
+	return err
+}
+
+// handleSettings сохраняет настройки пользователя. Пока это только язык сообщений.
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	lang := s.lang(r, userID)
+	var req struct {
+		Lang string `json:"lang"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidRequestBody)
+		return
+	}
+	userLang, ok := calculation.ParseLang(req.Lang)
+	if !ok {
+		httpError(w, lang, http.StatusUnprocessableEntity, MsgInvalidLanguage)
+		return
+	}
+	if _, err := s.db.Exec("UPDATE users SET lang = ? WHERE id = ?", string(userLang), userID); err != nil {
+		httpError(w, lang, http.StatusInternalServerError, MsgInternalError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"lang": string(userLang)})
+}
+
+// lang выбирает язык ответа: язык из настроек пользователя, затем заголовок
+// Accept-Language. Пустой язык означает язык каталога по умолчанию.
+func (s *Server) lang(r *http.Request, userID string) calculation.Lang {
+	if userID != "" {
+		var stored string
+		s.db.QueryRow("SELECT COALESCE(lang, '') FROM users WHERE id = ?", userID).Scan(&stored)
+		if lang, ok := calculation.ParseLang(stored); ok {
+			return lang
+		}
+	}
+	return calculation.AcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+func httpError(w http.ResponseWriter, lang calculation.Lang, code int, id calculation.MessageID, args ...string) {
+	http.Error(w, messages.Localize(lang, id, args...), code)
+}
+
+// addColumn добавляет колонку в таблицу, созданную более старой версией сервера.
+func addColumn(db *sql.DB, table, column, definition string) {
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Fatal(err)
 	}
-	fmt.Printf("Task %s not found in expressions\n", result.Id)
-	return nil, status.Errorf(codes.NotFound, "Task not found")
 }
 
 func createTables(db *sql.DB) {
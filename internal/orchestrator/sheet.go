@@ -0,0 +1,292 @@
+package orchestrator
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
+)
+
+// Sheet - таблица: именованные ячейки с выражениями, которые ссылаются на другие
+// ячейки (A1 = 10, B1 = A1*2). После изменения ячейки пересчитываются только она
+// и зависящие от неё ячейки: каждую вычисляют агенты, как обычное выражение, когда
+// готовы все ячейки, на которые она ссылается. Каждое новое значение ячейки
+// попадает в ленту изменений Changes.
+type Sheet struct {
+	ID     string
+	UserID string
+	Mode   calculation.Mode
+	Cells  map[string]*Cell
+	// Version - номер последнего изменения в ленте.
+	Version int
+	Changes []Change `json:"-"`
+
+	// dirty - ячейки, ждущие пересчёта; running - выражения ячеек, которые сейчас
+	// вычисляют агенты.
+	dirty   map[string]bool
+	running map[string]string
+}
+
+// Cell - ячейка таблицы. Status - pending, completed или error.
+type Cell struct {
+	Expression string
+	// References - ячейки, на которые ссылается выражение.
+	References []string `json:",omitempty"`
+	Status     string
+	Value      string  `json:",omitempty"`
+	Result     float64 `json:",omitempty"`
+	Error      string  `json:",omitempty"`
+	// ExpressionID - выражение, которым агенты вычислили ячейку последний раз.
+	ExpressionID string `json:",omitempty"`
+	// Version - номер изменения, в котором получено текущее значение.
+	Version int `json:",omitempty"`
+
+	// value - точное значение ячейки, которое получают ссылающиеся на неё ячейки.
+	value calculation.Value
+	err   error
+}
+
+// Change - запись ленты изменений: новое значение ячейки после пересчёта.
+type Change struct {
+	Version int       `json:"version"`
+	Cell    string    `json:"cell"`
+	Status  string    `json:"status"`
+	Value   string    `json:"value,omitempty"`
+	Result  float64   `json:"result"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+
+	err error
+}
+
+// maxChanges - сколько последних изменений хранит лента таблицы.
+const maxChanges = 1000
+
+func generateSheetID() string {
+	return "sheet-" + uuid.New().String()
+}
+
+func NewSheet(id, userID string, mode calculation.Mode) *Sheet {
+	return &Sheet{
+		ID:      id,
+		UserID:  userID,
+		Mode:    mode,
+		Cells:   make(map[string]*Cell),
+		dirty:   make(map[string]bool),
+		running: make(map[string]string),
+	}
+}
+
+// Set задаёт выражения ячеек cells и отмечает для пересчёта их и все зависящие от них
+// ячейки. Циклическая ссылка отклоняется, и таблица остаётся прежней. Возвращает
+// отмеченные ячейки в порядке имён.
+func (sh *Sheet) Set(cells map[string]string) ([]string, error) {
+	for name := range cells {
+		if !calculation.IsCell(name) {
+			return nil, messages.Error(MsgInvalidCell, name)
+		}
+	}
+	previous := make(map[string]*Cell, len(cells))
+	for name, expression := range cells {
+		previous[name] = sh.Cells[name]
+		sh.Cells[name] = &Cell{Expression: expression, References: calculation.Cells(expression), Status: "pending"}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cells)) {
+		if cycle := sh.cycle(name); cycle != nil {
+			for name, cell := range previous {
+				if cell == nil {
+					delete(sh.Cells, name)
+				} else {
+					sh.Cells[name] = cell
+				}
+			}
+			return nil, messages.Error(MsgCircularReference, strings.Join(cycle, " -> "))
+		}
+	}
+	marked := make(map[string]bool)
+	for name := range cells {
+		sh.mark(name, marked)
+	}
+	for name := range marked {
+		sh.dirty[name] = true
+		if cell := sh.Cells[name]; cell != nil {
+			cell.Status = "pending"
+		}
+	}
+	return slices.Sorted(maps.Keys(marked)), nil
+}
+
+// mark отмечает ячейку name и все ячейки, которые ссылаются на неё прямо или через
+// другие ячейки.
+func (sh *Sheet) mark(name string, marked map[string]bool) {
+	if marked[name] {
+		return
+	}
+	marked[name] = true
+	for other, cell := range sh.Cells {
+		if slices.Contains(cell.References, name) {
+			sh.mark(other, marked)
+		}
+	}
+}
+
+// cycle возвращает путь по ссылкам от ячейки name обратно к ней или nil, если
+// такого пути нет.
+func (sh *Sheet) cycle(name string) []string {
+	visited := make(map[string]bool)
+	var walk func(path []string) []string
+	walk = func(path []string) []string {
+		cell := sh.Cells[path[len(path)-1]]
+		if cell == nil {
+			return nil
+		}
+		for _, ref := range cell.References {
+			if ref == name {
+				return append(path, ref)
+			}
+			if visited[ref] {
+				continue
+			}
+			visited[ref] = true
+			if cycle := walk(append(path, ref)); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	return walk([]string{name})
+}
+
+// Idle сообщает, что в таблице нет ячеек, ждущих пересчёта.
+func (sh *Sheet) Idle() bool {
+	return len(sh.dirty) == 0 && len(sh.running) == 0
+}
+
+// take выбирает ячейки, готовые к вычислению: все ячейки, на которые они ссылаются,
+// уже пересчитаны. Возвращает выражения этих ячеек, в которые подставлены значения
+// ссылок; выражения записываются в running. Ячейка, ссылающаяся на пустую или
+// ошибочную ячейку, сразу получает ошибку.
+func (sh *Sheet) take() map[string]*Expression {
+	ready := make(map[string]*Expression)
+	for progress := true; progress; {
+		progress = false
+		for _, name := range slices.Sorted(maps.Keys(sh.dirty)) {
+			cell := sh.Cells[name]
+			if cell == nil {
+				delete(sh.dirty, name)
+				continue
+			}
+			if slices.ContainsFunc(cell.References, func(ref string) bool { return sh.dirty[ref] || sh.running[ref] != "" }) {
+				continue
+			}
+			delete(sh.dirty, name)
+			progress = true
+			values, err := sh.values(cell)
+			if err != nil {
+				cell.ExpressionID = ""
+				sh.publish(name, cell, calculation.Value{}, err)
+				continue
+			}
+			expr := NewExpression(generateID(), sh.UserID, cell.Expression)
+			expr.Mode = sh.Mode
+			expr.Variables = make(map[string]string, len(values))
+			for ref, value := range values {
+				expr.Variables[ref] = value.String()
+			}
+			expr.constants = values
+			expr.Parent = sh.ID
+			sh.running[name] = expr.ID
+			ready[name] = expr
+		}
+	}
+	return ready
+}
+
+// values возвращает точные значения ячеек, на которые ссылается cell.
+func (sh *Sheet) values(cell *Cell) (map[string]calculation.Value, error) {
+	values := make(map[string]calculation.Value, len(cell.References))
+	for _, ref := range cell.References {
+		other := sh.Cells[ref]
+		switch {
+		case other == nil:
+			return nil, messages.Error(MsgEmptyCell, ref)
+		case other.Status != "completed":
+			return nil, messages.Error(MsgCellError, ref)
+		}
+		values[ref] = other.value
+	}
+	return values, nil
+}
+
+// record записывает результат вычисления ячейки name выражением expr. Если ячейку
+// успели изменить или снова отметить для пересчёта, результат устарел и отбрасывается.
+func (sh *Sheet) record(name string, expr *Expression) {
+	if sh.running[name] != expr.ID {
+		return
+	}
+	delete(sh.running, name)
+	cell := sh.Cells[name]
+	if cell == nil || sh.dirty[name] {
+		return
+	}
+	cell.ExpressionID = expr.ID
+	if expr.Status != "completed" {
+		sh.publish(name, cell, calculation.Value{}, expr.err)
+		return
+	}
+	sh.publish(name, cell, expr.value, nil)
+}
+
+// publish присваивает ячейке значение или ошибку и добавляет запись в ленту изменений.
+func (sh *Sheet) publish(name string, cell *Cell, value calculation.Value, err error) {
+	sh.Version++
+	cell.Version = sh.Version
+	change := Change{Version: sh.Version, Cell: name, Time: time.Now()}
+	if err != nil {
+		cell.Status, cell.Value, cell.Result, cell.value = "error", "", 0, calculation.Value{}
+		cell.Error, cell.err = err.Error(), err
+		change.Error, change.err = cell.Error, err
+	} else {
+		cell.Status, cell.Value, cell.Result, cell.value = "completed", value.String(), finite(value.Approx()), value
+		cell.Error, cell.err = "", nil
+		change.Value, change.Result = cell.Value, cell.Result
+	}
+	change.Status = cell.Status
+	sh.Changes = append(sh.Changes, change)
+	if len(sh.Changes) > maxChanges {
+		sh.Changes = slices.Clone(sh.Changes[len(sh.Changes)-maxChanges:])
+	}
+}
+
+// Since возвращает изменения после версии version на языке lang.
+func (sh *Sheet) Since(version int, lang calculation.Lang) []Change {
+	changes := []Change{}
+	for _, change := range sh.Changes {
+		if change.Version <= version {
+			continue
+		}
+		if change.err != nil {
+			change.Error = calculation.Translate(change.err, lang)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// Localize возвращает копию таблицы с сообщениями об ошибках на языке lang.
+func (sh *Sheet) Localize(lang calculation.Lang) Sheet {
+	localized := *sh
+	localized.Cells = make(map[string]*Cell, len(sh.Cells))
+	for name, cell := range sh.Cells {
+		copied := *cell
+		if cell.err != nil {
+			copied.Error = calculation.Translate(cell.err, lang)
+		}
+		localized.Cells[name] = &copied
+	}
+	return localized
+}
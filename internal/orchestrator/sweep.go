@@ -0,0 +1,200 @@
+package orchestrator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math/big"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
+)
+
+// Sweep - перебор параметров: шаблон выражения "p*(1+r)^n" вычисляется для каждой
+// точки сетки значений параметров. Каждая точка - отдельное выражение с Parent,
+// равным ID перебора; агенты вычисляют их параллельно, как обычные выражения.
+type Sweep struct {
+	ID         string
+	UserID     string
+	Expression string
+	Mode       calculation.Mode
+	// Parameters - имена параметров в порядке столбцов таблицы.
+	Parameters []string
+	// Status - pending, пока не вычислены все точки, затем completed.
+	Status    string
+	Total     int
+	Completed int
+	Failed    int
+	// Progress - доля вычисленных точек, от 0 до 1.
+	Progress float64
+	Rows     []SweepRow
+
+	// expressions - выражения точек в порядке строк; nil - точка ещё не отправлена.
+	expressions []*Expression
+}
+
+// SweepRow - строка таблицы результатов: значения параметров и результат точки.
+type SweepRow struct {
+	Values       []string `json:"values"`
+	ExpressionID string   `json:"expression_id,omitempty"`
+	Status       string   `json:"status"`
+	Result       float64  `json:"result"`
+	Value        string   `json:"value,omitempty"`
+	Error        string   `json:"error,omitempty"`
+
+	err error
+}
+
+// SweepRange - значения параметра от From до To с шагом Step.
+type SweepRange struct {
+	From json.Number `json:"from"`
+	To   json.Number `json:"to"`
+	Step json.Number `json:"step"`
+}
+
+// maxSweepPoints - наибольшее число точек сетки перебора.
+const maxSweepPoints = 10000
+
+func generateSweepID() string {
+	return "sweep-" + uuid.New().String()
+}
+
+// sweepValues разбирает значения параметра: список чисел [5, 10, 20] или диапазон
+// {"from": 0.01, "to": 0.1, "step": 0.01}. Значения диапазона вычисляются точно,
+// без накопления ошибок округления шага.
+func sweepValues(name string, raw json.RawMessage) ([]string, error) {
+	var list []json.Number
+	if err := json.Unmarshal(raw, &list); err == nil {
+		if len(list) == 0 || len(list) > maxSweepPoints {
+			return nil, messages.Error(MsgInvalidParameter, name)
+		}
+		values := make([]string, len(list))
+		for i, value := range list {
+			values[i] = value.String()
+		}
+		return values, nil
+	}
+	var r SweepRange
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, messages.Error(MsgInvalidParameter, name)
+	}
+	from, ok1 := new(big.Rat).SetString(r.From.String())
+	to, ok2 := new(big.Rat).SetString(r.To.String())
+	step, ok3 := new(big.Rat).SetString(r.Step.String())
+	if !ok1 || !ok2 || !ok3 || step.Sign() <= 0 || to.Cmp(from) < 0 {
+		return nil, messages.Error(MsgInvalidParameter, name)
+	}
+	count := new(big.Rat).Quo(new(big.Rat).Sub(to, from), step)
+	n := new(big.Int).Quo(count.Num(), count.Denom())
+	if !n.IsInt64() || n.Int64() >= maxSweepPoints {
+		return nil, messages.Error(MsgTooManyPoints, n.Add(n, big.NewInt(1)).String(), strconv.Itoa(maxSweepPoints))
+	}
+	values := make([]string, 0, n.Int64()+1)
+	for k := int64(0); k <= n.Int64(); k++ {
+		x := new(big.Rat).Mul(step, new(big.Rat).SetInt64(k))
+		values = append(values, decimal(x.Add(x, from)))
+	}
+	return values, nil
+}
+
+// decimal записывает число десятичной дробью; дробь, не представимая конечной
+// десятичной записью, округляется до ближайшего float64.
+func decimal(x *big.Rat) string {
+	if x.IsInt() {
+		return x.RatString()
+	}
+	if s, exact := x.FloatPrec(); exact {
+		return x.FloatString(s)
+	}
+	f, _ := x.Float64()
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// grid возвращает все точки сетки: декартово произведение значений параметров,
+// в котором последний параметр меняется быстрее всех.
+func grid(values [][]string) [][]string {
+	points := [][]string{{}}
+	for _, column := range values {
+		next := make([][]string, 0, len(points)*len(column))
+		for _, point := range points {
+			for _, value := range column {
+				next = append(next, append(slices.Clip(point), value))
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+// variables возвращает значения параметров в строке i.
+func (sw *Sweep) variables(i int) map[string]string {
+	values := make(map[string]string, len(sw.Parameters))
+	for j, name := range sw.Parameters {
+		values[name] = sw.Rows[i].Values[j]
+	}
+	return values
+}
+
+// refresh переносит в строки результаты вычисленных выражений и пересчитывает
+// прогресс. Вызывается под s.mu.
+func (sw *Sweep) refresh() {
+	sw.Completed, sw.Failed = 0, 0
+	for i, expr := range sw.expressions {
+		if expr == nil {
+			continue
+		}
+		row := &sw.Rows[i]
+		row.ExpressionID, row.Status = expr.ID, expr.Status
+		switch expr.Status {
+		case "completed":
+			row.Result, row.Value = expr.Result, expr.value.String()
+			sw.Completed++
+		case "error":
+			row.Error, row.err = expr.Error, expr.err
+			sw.Failed++
+		}
+	}
+	if sw.Total > 0 {
+		sw.Progress = float64(sw.Completed+sw.Failed) / float64(sw.Total)
+	}
+	if sw.Completed+sw.Failed == sw.Total {
+		sw.Status = "completed"
+	}
+}
+
+// Localize возвращает копию перебора с сообщениями об ошибках на языке lang.
+func (sw *Sweep) Localize(lang calculation.Lang) Sweep {
+	localized := *sw
+	localized.Rows = slices.Clone(sw.Rows)
+	for i, row := range localized.Rows {
+		if row.err != nil {
+			localized.Rows[i].Error = calculation.Translate(row.err, lang)
+		}
+	}
+	return localized
+}
+
+// WriteCSV записывает таблицу результатов в CSV: столбцы параметров, затем результат,
+// точное значение, статус, ошибка и идентификатор выражения.
+func (sw *Sweep) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	header := append(slices.Clone(sw.Parameters), "result", "value", "status", "error", "expression_id")
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, row := range sw.Rows {
+		result := ""
+		if row.Status == "completed" {
+			result = strconv.FormatFloat(row.Result, 'g', -1, 64)
+		}
+		record := append(slices.Clone(row.Values), result, row.Value, row.Status, row.Error, row.ExpressionID)
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
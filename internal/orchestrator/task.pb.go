@@ -57,6 +57,432 @@ func (*Empty) Descriptor() ([]byte, []int) {
 	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{0}
 }
 
+type Rational struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Num           string                 `protobuf:"bytes,1,opt,name=num,proto3" json:"num,omitempty"`
+	Den           string                 `protobuf:"bytes,2,opt,name=den,proto3" json:"den,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Rational) Reset() {
+	*x = Rational{}
+	mi := &file_internal_orchestrator_task_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Rational) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Rational) ProtoMessage() {}
+
+func (x *Rational) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_orchestrator_task_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Rational.ProtoReflect.Descriptor instead.
+func (*Rational) Descriptor() ([]byte, []int) {
+	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Rational) GetNum() string {
+	if x != nil {
+		return x.Num
+	}
+	return ""
+}
+
+func (x *Rational) GetDen() string {
+	if x != nil {
+		return x.Den
+	}
+	return ""
+}
+
+type Complex struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Re            float64                `protobuf:"fixed64,1,opt,name=re,proto3" json:"re,omitempty"`
+	Im            float64                `protobuf:"fixed64,2,opt,name=im,proto3" json:"im,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Complex) Reset() {
+	*x = Complex{}
+	mi := &file_internal_orchestrator_task_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Complex) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Complex) ProtoMessage() {}
+
+func (x *Complex) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_orchestrator_task_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Complex.ProtoReflect.Descriptor instead.
+func (*Complex) Descriptor() ([]byte, []int) {
+	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Complex) GetRe() float64 {
+	if x != nil {
+		return x.Re
+	}
+	return 0
+}
+
+func (x *Complex) GetIm() float64 {
+	if x != nil {
+		return x.Im
+	}
+	return 0
+}
+
+type Integer struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Value         string                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Remainder     string                 `protobuf:"bytes,2,opt,name=remainder,proto3" json:"remainder,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Integer) Reset() {
+	*x = Integer{}
+	mi := &file_internal_orchestrator_task_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Integer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Integer) ProtoMessage() {}
+
+func (x *Integer) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_orchestrator_task_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Integer.ProtoReflect.Descriptor instead.
+func (*Integer) Descriptor() ([]byte, []int) {
+	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Integer) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *Integer) GetRemainder() string {
+	if x != nil {
+		return x.Remainder
+	}
+	return ""
+}
+
+// Interval - отрезок [lo, hi] режима interval.
+type Interval struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lo            float64                `protobuf:"fixed64,1,opt,name=lo,proto3" json:"lo,omitempty"`
+	Hi            float64                `protobuf:"fixed64,2,opt,name=hi,proto3" json:"hi,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Interval) Reset() {
+	*x = Interval{}
+	mi := &file_internal_orchestrator_task_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Interval) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Interval) ProtoMessage() {}
+
+func (x *Interval) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_orchestrator_task_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Interval.ProtoReflect.Descriptor instead.
+func (*Interval) Descriptor() ([]byte, []int) {
+	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Interval) GetLo() float64 {
+	if x != nil {
+		return x.Lo
+	}
+	return 0
+}
+
+func (x *Interval) GetHi() float64 {
+	if x != nil {
+		return x.Hi
+	}
+	return 0
+}
+
+// Array - вектор (rows = 0) или матрица; элементы хранятся по строкам.
+type Array struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rows          int32                  `protobuf:"varint,1,opt,name=rows,proto3" json:"rows,omitempty"`
+	Cols          int32                  `protobuf:"varint,2,opt,name=cols,proto3" json:"cols,omitempty"`
+	Elems         []*Value               `protobuf:"bytes,3,rep,name=elems,proto3" json:"elems,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Array) Reset() {
+	*x = Array{}
+	mi := &file_internal_orchestrator_task_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Array) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Array) ProtoMessage() {}
+
+func (x *Array) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_orchestrator_task_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Array.ProtoReflect.Descriptor instead.
+func (*Array) Descriptor() ([]byte, []int) {
+	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Array) GetRows() int32 {
+	if x != nil {
+		return x.Rows
+	}
+	return 0
+}
+
+func (x *Array) GetCols() int32 {
+	if x != nil {
+		return x.Cols
+	}
+	return 0
+}
+
+func (x *Array) GetElems() []*Value {
+	if x != nil {
+		return x.Elems
+	}
+	return nil
+}
+
+type Value struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Kind:
+	//
+	//	*Value_Real
+	//	*Value_Rational
+	//	*Value_Complex
+	//	*Value_Integer
+	//	*Value_Array
+	//	*Value_Interval
+	Kind isValue_Kind `protobuf_oneof:"kind"`
+	// dimension - показатели степеней основных единиц СИ (m, kg, s, A, K, mol, cd)
+	// у величины с единицей измерения; у безразмерного значения пуст.
+	Dimension     []int32 `protobuf:"varint,7,rep,packed,name=dimension,proto3" json:"dimension,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Value) Reset() {
+	*x = Value{}
+	mi := &file_internal_orchestrator_task_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Value) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Value) ProtoMessage() {}
+
+func (x *Value) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_orchestrator_task_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Value.ProtoReflect.Descriptor instead.
+func (*Value) Descriptor() ([]byte, []int) {
+	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Value) GetKind() isValue_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return nil
+}
+
+func (x *Value) GetReal() float64 {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Real); ok {
+			return x.Real
+		}
+	}
+	return 0
+}
+
+func (x *Value) GetRational() *Rational {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Rational); ok {
+			return x.Rational
+		}
+	}
+	return nil
+}
+
+func (x *Value) GetComplex() *Complex {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Complex); ok {
+			return x.Complex
+		}
+	}
+	return nil
+}
+
+func (x *Value) GetInteger() *Integer {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Integer); ok {
+			return x.Integer
+		}
+	}
+	return nil
+}
+
+func (x *Value) GetArray() *Array {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Array); ok {
+			return x.Array
+		}
+	}
+	return nil
+}
+
+func (x *Value) GetInterval() *Interval {
+	if x != nil {
+		if x, ok := x.Kind.(*Value_Interval); ok {
+			return x.Interval
+		}
+	}
+	return nil
+}
+
+func (x *Value) GetDimension() []int32 {
+	if x != nil {
+		return x.Dimension
+	}
+	return nil
+}
+
+type isValue_Kind interface {
+	isValue_Kind()
+}
+
+type Value_Real struct {
+	Real float64 `protobuf:"fixed64,1,opt,name=real,proto3,oneof"`
+}
+
+type Value_Rational struct {
+	Rational *Rational `protobuf:"bytes,2,opt,name=rational,proto3,oneof"`
+}
+
+type Value_Complex struct {
+	Complex *Complex `protobuf:"bytes,3,opt,name=complex,proto3,oneof"`
+}
+
+type Value_Integer struct {
+	Integer *Integer `protobuf:"bytes,4,opt,name=integer,proto3,oneof"`
+}
+
+type Value_Array struct {
+	Array *Array `protobuf:"bytes,5,opt,name=array,proto3,oneof"`
+}
+
+type Value_Interval struct {
+	Interval *Interval `protobuf:"bytes,6,opt,name=interval,proto3,oneof"`
+}
+
+func (*Value_Real) isValue_Kind() {}
+
+func (*Value_Rational) isValue_Kind() {}
+
+func (*Value_Complex) isValue_Kind() {}
+
+func (*Value_Integer) isValue_Kind() {}
+
+func (*Value_Array) isValue_Kind() {}
+
+func (*Value_Interval) isValue_Kind() {}
+
 type Task struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
@@ -64,13 +490,16 @@ type Task struct {
 	Arg2          float64                `protobuf:"fixed64,3,opt,name=arg2,proto3" json:"arg2,omitempty"`
 	Operation     string                 `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`
 	OperationTime int32                  `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"`
+	Mode          string                 `protobuf:"bytes,6,opt,name=mode,proto3" json:"mode,omitempty"`
+	Args          []*Value               `protobuf:"bytes,7,rep,name=args,proto3" json:"args,omitempty"`
+	Body          string                 `protobuf:"bytes,8,opt,name=body,proto3" json:"body,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Task) Reset() {
 	*x = Task{}
-	mi := &file_internal_orchestrator_task_proto_msgTypes[1]
+	mi := &file_internal_orchestrator_task_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -82,7 +511,7 @@ func (x *Task) String() string {
 func (*Task) ProtoMessage() {}
 
 func (x *Task) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_orchestrator_task_proto_msgTypes[1]
+	mi := &file_internal_orchestrator_task_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -95,7 +524,7 @@ func (x *Task) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Task.ProtoReflect.Descriptor instead.
 func (*Task) Descriptor() ([]byte, []int) {
-	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{1}
+	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *Task) GetId() string {
@@ -133,17 +562,42 @@ func (x *Task) GetOperationTime() int32 {
 	return 0
 }
 
+func (x *Task) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *Task) GetArgs() []*Value {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *Task) GetBody() string {
+	if x != nil {
+		return x.Body
+	}
+	return ""
+}
+
 type Result struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Result        float64                `protobuf:"fixed64,2,opt,name=result,proto3" json:"result,omitempty"`
+	Value         *Value                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	ErrorId       string                 `protobuf:"bytes,5,opt,name=error_id,json=errorId,proto3" json:"error_id,omitempty"`
+	ErrorArgs     []string               `protobuf:"bytes,6,rep,name=error_args,json=errorArgs,proto3" json:"error_args,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Result) Reset() {
 	*x = Result{}
-	mi := &file_internal_orchestrator_task_proto_msgTypes[2]
+	mi := &file_internal_orchestrator_task_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -155,7 +609,7 @@ func (x *Result) String() string {
 func (*Result) ProtoMessage() {}
 
 func (x *Result) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_orchestrator_task_proto_msgTypes[2]
+	mi := &file_internal_orchestrator_task_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -168,7 +622,7 @@ func (x *Result) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Result.ProtoReflect.Descriptor instead.
 func (*Result) Descriptor() ([]byte, []int) {
-	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{2}
+	return file_internal_orchestrator_task_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *Result) GetId() string {
@@ -185,21 +639,82 @@ func (x *Result) GetResult() float64 {
 	return 0
 }
 
+func (x *Result) GetValue() *Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *Result) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Result) GetErrorId() string {
+	if x != nil {
+		return x.ErrorId
+	}
+	return ""
+}
+
+func (x *Result) GetErrorArgs() []string {
+	if x != nil {
+		return x.ErrorArgs
+	}
+	return nil
+}
+
 var File_internal_orchestrator_task_proto protoreflect.FileDescriptor
 
 const file_internal_orchestrator_task_proto_rawDesc = "" +
 	"\n" +
 	" internal/orchestrator/task.proto\x12\tcalculate\"\a\n" +
-	"\x05Empty\"\x83\x01\n" +
+	"\x05Empty\".\n" +
+	"\bRational\x12\x10\n" +
+	"\x03num\x18\x01 \x01(\tR\x03num\x12\x10\n" +
+	"\x03den\x18\x02 \x01(\tR\x03den\")\n" +
+	"\aComplex\x12\x0e\n" +
+	"\x02re\x18\x01 \x01(\x01R\x02re\x12\x0e\n" +
+	"\x02im\x18\x02 \x01(\x01R\x02im\"=\n" +
+	"\aInteger\x12\x14\n" +
+	"\x05value\x18\x01 \x01(\tR\x05value\x12\x1c\n" +
+	"\tremainder\x18\x02 \x01(\tR\tremainder\"*\n" +
+	"\bInterval\x12\x0e\n" +
+	"\x02lo\x18\x01 \x01(\x01R\x02lo\x12\x0e\n" +
+	"\x02hi\x18\x02 \x01(\x01R\x02hi\"W\n" +
+	"\x05Array\x12\x12\n" +
+	"\x04rows\x18\x01 \x01(\x05R\x04rows\x12\x12\n" +
+	"\x04cols\x18\x02 \x01(\x05R\x04cols\x12&\n" +
+	"\x05elems\x18\x03 \x03(\v2\x10.calculate.ValueR\x05elems\"\xb3\x02\n" +
+	"\x05Value\x12\x14\n" +
+	"\x04real\x18\x01 \x01(\x01H\x00R\x04real\x121\n" +
+	"\brational\x18\x02 \x01(\v2\x13.calculate.RationalH\x00R\brational\x12.\n" +
+	"\acomplex\x18\x03 \x01(\v2\x12.calculate.ComplexH\x00R\acomplex\x12.\n" +
+	"\ainteger\x18\x04 \x01(\v2\x12.calculate.IntegerH\x00R\ainteger\x12(\n" +
+	"\x05array\x18\x05 \x01(\v2\x10.calculate.ArrayH\x00R\x05array\x121\n" +
+	"\binterval\x18\x06 \x01(\v2\x13.calculate.IntervalH\x00R\binterval\x12\x1c\n" +
+	"\tdimension\x18\a \x03(\x05R\tdimensionB\x06\n" +
+	"\x04kind\"\xd1\x01\n" +
 	"\x04Task\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
 	"\x04arg1\x18\x02 \x01(\x01R\x04arg1\x12\x12\n" +
 	"\x04arg2\x18\x03 \x01(\x01R\x04arg2\x12\x1c\n" +
 	"\toperation\x18\x04 \x01(\tR\toperation\x12%\n" +
-	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\"0\n" +
+	"\x0eoperation_time\x18\x05 \x01(\x05R\roperationTime\x12\x12\n" +
+	"\x04mode\x18\x06 \x01(\tR\x04mode\x12$\n" +
+	"\x04args\x18\a \x03(\v2\x10.calculate.ValueR\x04args\x12\x12\n" +
+	"\x04body\x18\b \x01(\tR\x04body\"\xa8\x01\n" +
 	"\x06Result\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
-	"\x06result\x18\x02 \x01(\x01R\x06result2r\n" +
+	"\x06result\x18\x02 \x01(\x01R\x06result\x12&\n" +
+	"\x05value\x18\x03 \x01(\v2\x10.calculate.ValueR\x05value\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12\x19\n" +
+	"\berror_id\x18\x05 \x01(\tR\aerrorId\x12\x1d\n" +
+	"\n" +
+	"error_args\x18\x06 \x03(\tR\terrorArgs2r\n" +
 	"\vTaskService\x12.\n" +
 	"\aGetTask\x12\x10.calculate.Empty\x1a\x0f.calculate.Task\"\x00\x123\n" +
 	"\n" +
@@ -217,22 +732,36 @@ func file_internal_orchestrator_task_proto_rawDescGZIP() []byte {
 	return file_internal_orchestrator_task_proto_rawDescData
 }
 
-var file_internal_orchestrator_task_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_internal_orchestrator_task_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_internal_orchestrator_task_proto_goTypes = []any{
-	(*Empty)(nil),  // 0: calculate.Empty
-	(*Task)(nil),   // 1: calculate.Task
-	(*Result)(nil), // 2: calculate.Result
+	(*Empty)(nil),    // 0: calculate.Empty
+	(*Rational)(nil), // 1: calculate.Rational
+	(*Complex)(nil),  // 2: calculate.Complex
+	(*Integer)(nil),  // 3: calculate.Integer
+	(*Interval)(nil), // 4: calculate.Interval
+	(*Array)(nil),    // 5: calculate.Array
+	(*Value)(nil),    // 6: calculate.Value
+	(*Task)(nil),     // 7: calculate.Task
+	(*Result)(nil),   // 8: calculate.Result
 }
 var file_internal_orchestrator_task_proto_depIdxs = []int32{
-	0, // 0: calculate.TaskService.GetTask:input_type -> calculate.Empty
-	2, // 1: calculate.TaskService.SendResult:input_type -> calculate.Result
-	1, // 2: calculate.TaskService.GetTask:output_type -> calculate.Task
-	0, // 3: calculate.TaskService.SendResult:output_type -> calculate.Empty
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	6,  // 0: calculate.Array.elems:type_name -> calculate.Value
+	1,  // 1: calculate.Value.rational:type_name -> calculate.Rational
+	2,  // 2: calculate.Value.complex:type_name -> calculate.Complex
+	3,  // 3: calculate.Value.integer:type_name -> calculate.Integer
+	5,  // 4: calculate.Value.array:type_name -> calculate.Array
+	4,  // 5: calculate.Value.interval:type_name -> calculate.Interval
+	6,  // 6: calculate.Task.args:type_name -> calculate.Value
+	6,  // 7: calculate.Result.value:type_name -> calculate.Value
+	0,  // 8: calculate.TaskService.GetTask:input_type -> calculate.Empty
+	8,  // 9: calculate.TaskService.SendResult:input_type -> calculate.Result
+	7,  // 10: calculate.TaskService.GetTask:output_type -> calculate.Task
+	0,  // 11: calculate.TaskService.SendResult:output_type -> calculate.Empty
+	10, // [10:12] is the sub-list for method output_type
+	8,  // [8:10] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_internal_orchestrator_task_proto_init() }
@@ -240,13 +769,21 @@ func file_internal_orchestrator_task_proto_init() {
 	if File_internal_orchestrator_task_proto != nil {
 		return
 	}
+	file_internal_orchestrator_task_proto_msgTypes[6].OneofWrappers = []any{
+		(*Value_Real)(nil),
+		(*Value_Rational)(nil),
+		(*Value_Complex)(nil),
+		(*Value_Integer)(nil),
+		(*Value_Array)(nil),
+		(*Value_Interval)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_orchestrator_task_proto_rawDesc), len(file_internal_orchestrator_task_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
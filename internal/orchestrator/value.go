@@ -0,0 +1,140 @@
+package orchestrator
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/TimofeySar/ya_go_calculate.go/internal/calculation"
+)
+
+func ValueToProto(v calculation.Value) *Value {
+	if v.Dim != nil {
+		dim := *v.Dim
+		v.Dim = nil
+		p := ValueToProto(v)
+		for _, e := range dim {
+			p.Dimension = append(p.Dimension, int32(e))
+		}
+		return p
+	}
+	if v.IsArray() {
+		elems := make([]*Value, len(v.Elems))
+		for i, elem := range v.Elems {
+			elems[i] = ValueToProto(elem)
+		}
+		return &Value{Kind: &Value_Array{Array: &Array{Rows: int32(v.Rows), Cols: int32(v.Cols), Elems: elems}}}
+	}
+	if v.Int != nil {
+		integer := &Integer{Value: v.Int.String()}
+		if v.Rem != nil {
+			integer.Remainder = v.Rem.String()
+		}
+		return &Value{Kind: &Value_Integer{Integer: integer}}
+	}
+	if v.Rat != nil {
+		return &Value{Kind: &Value_Rational{Rational: &Rational{
+			Num: v.Rat.Num().String(),
+			Den: v.Rat.Denom().String(),
+		}}}
+	}
+	if v.Interval != nil {
+		return &Value{Kind: &Value_Interval{Interval: &Interval{Lo: v.Interval.Lo, Hi: v.Interval.Hi}}}
+	}
+	if v.IsComplex() {
+		return &Value{Kind: &Value_Complex{Complex: &Complex{Re: v.Float, Im: v.Imag}}}
+	}
+	return &Value{Kind: &Value_Real{Real: v.Float}}
+}
+
+func ValueFromProto(v *Value) (calculation.Value, error) {
+	if exponents := v.GetDimension(); len(exponents) > 0 {
+		var dim calculation.Dimension
+		if len(exponents) != len(dim) {
+			return calculation.Value{}, fmt.Errorf("invalid dimension %v", exponents)
+		}
+		for i, e := range exponents {
+			if e < math.MinInt8 || e > math.MaxInt8 {
+				return calculation.Value{}, fmt.Errorf("invalid dimension %v", exponents)
+			}
+			dim[i] = int8(e)
+		}
+		value, err := ValueFromProto(&Value{Kind: v.GetKind()})
+		if err != nil || dim.IsZero() {
+			return value, err
+		}
+		value.Dim = &dim
+		return value, nil
+	}
+	switch kind := v.GetKind().(type) {
+	case *Value_Rational:
+		num, ok := new(big.Int).SetString(kind.Rational.GetNum(), 10)
+		if !ok {
+			return calculation.Value{}, fmt.Errorf("invalid numerator %q", kind.Rational.GetNum())
+		}
+		den, ok := new(big.Int).SetString(kind.Rational.GetDen(), 10)
+		if !ok || den.Sign() == 0 {
+			return calculation.Value{}, fmt.Errorf("invalid denominator %q", kind.Rational.GetDen())
+		}
+		return calculation.RatValue(new(big.Rat).SetFrac(num, den)), nil
+	case *Value_Integer:
+		i, ok := new(big.Int).SetString(kind.Integer.GetValue(), 10)
+		if !ok {
+			return calculation.Value{}, fmt.Errorf("invalid integer %q", kind.Integer.GetValue())
+		}
+		value := calculation.IntValue(i)
+		if kind.Integer.GetRemainder() != "" {
+			rem, ok := new(big.Int).SetString(kind.Integer.GetRemainder(), 10)
+			if !ok {
+				return calculation.Value{}, fmt.Errorf("invalid remainder %q", kind.Integer.GetRemainder())
+			}
+			value.Rem = rem
+		}
+		return value, nil
+	case *Value_Complex:
+		return calculation.ComplexValue(complex(kind.Complex.GetRe(), kind.Complex.GetIm())), nil
+	case *Value_Real:
+		return calculation.FloatValue(kind.Real), nil
+	case *Value_Interval:
+		lo, hi := kind.Interval.GetLo(), kind.Interval.GetHi()
+		if !(lo <= hi) {
+			return calculation.Value{}, fmt.Errorf("invalid interval [%g, %g]", lo, hi)
+		}
+		return calculation.IntervalValue(lo, hi), nil
+	case *Value_Array:
+		rows, cols := int(kind.Array.GetRows()), int(kind.Array.GetCols())
+		if cols <= 0 || rows < 0 || max(rows, 1)*cols != len(kind.Array.GetElems()) {
+			return calculation.Value{}, fmt.Errorf("invalid array %dx%d with %d elements", rows, cols, len(kind.Array.GetElems()))
+		}
+		elems := make([]calculation.Value, len(kind.Array.GetElems()))
+		for i, elem := range kind.Array.GetElems() {
+			value, err := ValueFromProto(elem)
+			if err != nil {
+				return calculation.Value{}, err
+			}
+			if value.IsArray() {
+				return calculation.Value{}, fmt.Errorf("nested array element")
+			}
+			elems[i] = value
+		}
+		return calculation.MatrixValue(rows, cols, elems), nil
+	}
+	return calculation.Value{}, fmt.Errorf("empty value")
+}
+
+func taskToProto(task *calculation.Task) *Task {
+	args := make([]*Value, len(task.Args))
+	for i, arg := range task.Args {
+		args[i] = ValueToProto(arg)
+	}
+	return &Task{
+		Id:            task.ID,
+		Arg1:          task.Arg1,
+		Arg2:          task.Arg2,
+		Operation:     task.Operation,
+		OperationTime: int32(task.OperationTime),
+		Mode:          string(task.Mode),
+		Args:          args,
+		Body:          task.Body,
+	}
+}